@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"os"
+	"sso/config"
+	"sso/internal/app"
+	"sso/internal/storage/postgresql"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const usage = `usage: clients <command> [flags]
+
+commands:
+  secret  generate a new client secret for an app; the secret is printed once
+          and the previous one stops working
+
+flags:
+  -config  path to config file (default $CONFIG_PATH)
+  -app-id  app id
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+
+	var (
+		configPath string
+		appID      int
+	)
+
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.StringVar(&configPath, "config", os.Getenv("CONFIG_PATH"), "path to config file")
+	fs.IntVar(&appID, "app-id", 0, "app id")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	_ = fs.Parse(os.Args[2:])
+
+	cfg := config.MustLoadPath(configPath)
+
+	secrets, err := app.SecretsKeyring(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	storage, err := postgresql.New(cfg.Connection, secrets)
+	if err != nil {
+		panic(err)
+	}
+	defer storage.Stop()
+
+	ctx := context.Background()
+
+	switch command {
+	case "secret":
+		if appID == 0 {
+			panic("app-id is required")
+		}
+
+		secret := rand.Text()
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := storage.SaveClientSecret(ctx, appID, hash); err != nil {
+			panic(err)
+		}
+
+		fmt.Println(secret)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sso/config"
+	"sso/internal/app"
+	"sso/internal/services/keys"
+	"sso/internal/storage/postgresql"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: keys <command> [flags]
+
+commands:
+  list     show signing keys of an app
+  prepare  generate a pending key to be published before rotation
+  rotate   activate the pending key (or a new one) and retire the active key
+  revoke   revoke a key immediately
+
+flags:
+  -config  path to config file (default $CONFIG_PATH)
+  -app-id  app id (list, prepare, rotate)
+  -kid     key id (revoke)
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+
+	var (
+		configPath string
+		appID      int
+		kid        string
+	)
+
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.StringVar(&configPath, "config", os.Getenv("CONFIG_PATH"), "path to config file")
+	fs.IntVar(&appID, "app-id", 0, "app id")
+	fs.StringVar(&kid, "kid", "", "key id")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	_ = fs.Parse(os.Args[2:])
+
+	cfg := config.MustLoadPath(configPath)
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	secrets, err := app.SecretsKeyring(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	storage, err := postgresql.New(cfg.Connection, secrets)
+	if err != nil {
+		panic(err)
+	}
+	defer storage.Stop()
+
+	keysService := keys.New(log, storage, storage, cfg.JWT.Algorithm, cfg.JWT.AllowedAlgorithms, cfg.TokenTTL)
+
+	ctx := context.Background()
+
+	switch command {
+	case "list":
+		requireAppID(appID)
+
+		list, err := storage.SigningKeys(ctx, appID)
+		if err != nil {
+			panic(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KID\tALG\tSTATE\tCREATED\tUPDATED")
+		for _, key := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				key.ID,
+				key.Algorithm,
+				key.State,
+				key.CreatedAt.Format(time.RFC3339),
+				key.UpdatedAt.Format(time.RFC3339),
+			)
+		}
+		w.Flush()
+	case "prepare":
+		requireAppID(appID)
+
+		key, err := keysService.PrepareKey(ctx, appID)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("pending key %s (%s) created\n", key.ID, key.Algorithm)
+	case "rotate":
+		requireAppID(appID)
+
+		app, err := storage.App(ctx, appID)
+		if err != nil {
+			panic(err)
+		}
+
+		key, err := keysService.RotateKey(ctx, app)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("key %s (%s) is now active\n", key.ID, key.Algorithm)
+	case "revoke":
+		if kid == "" {
+			panic("kid is required")
+		}
+
+		if err := keysService.RevokeKey(ctx, kid); err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("key %s revoked\n", kid)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func requireAppID(appID int) {
+	if appID == 0 {
+		panic("app-id is required")
+	}
+}
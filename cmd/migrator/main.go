@@ -64,14 +64,22 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"sso/config"
+	"sso/internal/app"
+	"sso/internal/storage/postgresql"
+	"sso/internal/storage/sqlite"
 
 	// Библиотека для миграций
 	"github.com/golang-migrate/migrate/v4"
 	// Драйвер для выполнения миграций PostgreSQL
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	// Драйвер для выполнения миграций SQLite 3
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	// Драйвер для получения миграций из файлов
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
@@ -84,8 +92,11 @@ func main() {
 		dbPassword      string
 		dbName          string
 		sslMode         string
+		storagePath     string
 		migrationsPath  string
 		migrationsTable string
+		encryptSecrets  bool
+		configPath      string
 	)
 
 	// Получаем параметры подключения к PostgreSQL
@@ -95,15 +106,21 @@ func main() {
 	flag.StringVar(&dbPassword, "db-password", "", "database password")
 	flag.StringVar(&dbName, "db-name", "", "database name")
 	flag.StringVar(&sslMode, "ssl-mode", "disable", "SSL mode (disable, require, verify-full, etc.)")
+	// Либо путь до файла SQLite, тогда параметры PostgreSQL не нужны
+	flag.StringVar(&storagePath, "storage-path", "", "path to SQLite storage, migrated instead of PostgreSQL")
 	flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations")
 	flag.StringVar(&migrationsTable, "migrations-table", "migrations", "name of migrations table")
+	// Шифрование секретов, сохранённых открытым текстом или старым ключом
+	flag.BoolVar(&encryptSecrets, "encrypt-secrets", false,
+		"encrypt with the current key every secret stored in plaintext or with an old key; run it after adding or changing the key")
+	flag.StringVar(&configPath, "config", os.Getenv("CONFIG_PATH"), "path to config file with the encryption keys")
 	flag.Parse()
 
 	// Валидация параметров
-	if dbUser == "" {
+	if storagePath == "" && dbUser == "" {
 		panic("db-user is required")
 	}
-	if dbName == "" {
+	if storagePath == "" && dbName == "" {
 		panic("db-name is required")
 	}
 	if migrationsPath == "" {
@@ -111,17 +128,21 @@ func main() {
 	}
 
 	// Формируем DSN (Data Source Name) для PostgreSQL
-	dsn := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=%s&x-migrations-table=%s",
+	connection := fmt.Sprintf(
+		"postgres://%s:%s@%s:%s/%s?sslmode=%s",
 		dbUser,
 		dbPassword,
 		dbHost,
 		dbPort,
 		dbName,
 		sslMode,
-		migrationsTable,
 	)
 
+	dsn := connection + "&x-migrations-table=" + migrationsTable
+	if storagePath != "" {
+		dsn = fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable)
+	}
+
 	// Создаем объект мигратора
 	m, err := migrate.New(
 		"file://"+migrationsPath,
@@ -133,13 +154,56 @@ func main() {
 
 	// Выполняем миграции до последней версии
 	if err := m.Up(); err != nil {
-		if errors.Is(err, migrate.ErrNoChange) {
-			fmt.Println("no migrations to apply")
-			return
+		if !errors.Is(err, migrate.ErrNoChange) {
+			panic(err)
 		}
 
+		fmt.Println("no migrations to apply")
+	} else {
+		fmt.Println("migrations applied successfully")
+	}
+
+	if encryptSecrets {
+		reencryptSecrets(configPath, storagePath, connection)
+	}
+}
+
+// reencryptSecrets encrypts the secrets of the migrated storage
+// with the current key from the config.
+func reencryptSecrets(configPath string, storagePath string, connection string) {
+	cfg := config.MustLoadPath(configPath)
+
+	secrets, err := app.SecretsKeyring(cfg)
+	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("migrations applied successfully")
+	ctx := context.Background()
+
+	var n int
+	if storagePath != "" {
+		storage, err := sqlite.New(storagePath, secrets)
+		if err != nil {
+			panic(err)
+		}
+		defer storage.Stop()
+
+		n, err = storage.ReencryptSecrets(ctx)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		storage, err := postgresql.New(connection, secrets)
+		if err != nil {
+			panic(err)
+		}
+		defer storage.Stop()
+
+		n, err = storage.ReencryptSecrets(ctx)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	fmt.Printf("%d rows encrypted with key version %d\n", n, cfg.Encryption.KeyVersion)
 }
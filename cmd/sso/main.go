@@ -6,6 +6,7 @@ import (
 	"os/signal"
 	"sso/config"
 	"sso/internal/app"
+	"sso/internal/lib/logger/sl"
 	"syscall"
 )
 
@@ -20,20 +21,57 @@ func main() {
 
 	log := setupLogger(cfg.Env)
 
-	application := app.New(log, cfg.GRPC.Port, cfg.Connection, cfg.TokenTTL, cfg.RefreshTokenTTL)
+	application := app.New(log, cfg)
 
 	go func() {
 		application.GRPCServer.MustRun()
 	}()
 
+	if application.HTTPServer != nil {
+		go func() {
+			application.HTTPServer.MustRun()
+		}()
+	}
+
+	go reloadOnHangup(log, cfg.Path, application)
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 
 	<-stop
 	application.GRPCServer.Stop()
+	if application.HTTPServer != nil {
+		application.HTTPServer.Stop()
+	}
+	application.Denylist.Stop()
+	application.Throttle.Stop()
+	application.RateLimit.Stop()
+	// After the servers, so that events of the last requests are saved
+	application.Audit.Stop()
 	application.Storage.Stop()
 	log.Info("Gracefully stopped")
 }
+
+// reloadOnHangup rereads the config on SIGHUP and applies the settings
+// that can change without a restart.
+func reloadOnHangup(log *slog.Logger, path string, application *app.App) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		cfg, err := config.Load(path)
+		if err != nil {
+			log.Error("failed to reload config", sl.Err(err))
+
+			continue
+		}
+
+		application.RateLimit.Update(app.RateLimitPolicies(cfg.RateLimit))
+
+		log.Info("config reloaded")
+	}
+}
+
 func setupLogger(env string) *slog.Logger {
 	var log *slog.Logger
 
@@ -13,9 +13,24 @@ type Config struct {
 	//StoragePath    string     `yaml:"storage_path" env-required:"true"`
 	Connection      string     `yaml:"connection_string" env-required:"true"`
 	GRPC            GRPCConfig `yaml:"grpc"`
+	HTTP            HTTPConfig `yaml:"http"`
 	MigrationsPath  string
-	TokenTTL        time.Duration `yaml:"token_ttl" env-default:"1h"`
-	RefreshTokenTTL time.Duration `yaml:"refresh_ttl" env-default:"336h"`
+	TokenTTL        time.Duration    `yaml:"token_ttl" env-default:"1h"`
+	RefreshTokenTTL time.Duration    `yaml:"refresh_ttl" env-default:"336h"`
+	JWT             JWTConfig        `yaml:"jwt"`
+	Revocation      RevocationConfig `yaml:"revocation"`
+	OAuth           OAuthConfig      `yaml:"oauth"`
+	MFA             MFAConfig        `yaml:"mfa"`
+	WebAuthn        WebAuthnConfig   `yaml:"webauthn"`
+	Email           EmailConfig      `yaml:"email"`
+	Throttle        ThrottleConfig   `yaml:"throttle"`
+	RateLimit       RateLimitConfig  `yaml:"rate_limit"`
+	Password        PasswordConfig   `yaml:"password"`
+	Encryption      EncryptionConfig `yaml:"encryption"`
+	Audit           AuditConfig      `yaml:"audit"`
+	Admin           AdminConfig      `yaml:"admin"`
+	// File the config was loaded from, to reload it
+	Path string `yaml:"-" env:"-"`
 }
 
 type GRPCConfig struct {
@@ -23,28 +38,191 @@ type GRPCConfig struct {
 	Timeout time.Duration `yaml:"timeout"`
 }
 
-func MustLoad() *Config {
-	configPath := fetchConfigPath()
-	if configPath == "" {
-		panic("config path is empty")
-	}
+// HTTPConfig configures the optional HTTP server. It is disabled if Port is 0.
+type HTTPConfig struct {
+	Port    int           `yaml:"port"`
+	Timeout time.Duration `yaml:"timeout" env-default:"10s"`
+	// Serve expvar metrics at /debug/vars, keep the port private if enabled
+	Metrics bool `yaml:"metrics"`
+}
 
-	// check if file exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		panic("config file does not exist: " + configPath)
-	}
+// RevocationConfig tunes the in-memory cache of revoked access tokens.
+type RevocationConfig struct {
+	// How long a token found not revoked is trusted without asking storage
+	CacheTTL      time.Duration `yaml:"cache_ttl" env-default:"5s"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env-default:"1m"`
+}
 
-	var cfg Config
+type OAuthConfig struct {
+	// Lifetime of authorization codes, should be short (RFC 6749 recommends at most 10m)
+	CodeTTL time.Duration `yaml:"code_ttl" env-default:"1m"`
+	// OpenID Connect issuer, the public base URL of the HTTP server.
+	// Defaults to http://localhost:<http port>
+	Issuer string `yaml:"issuer"`
+}
 
-	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
-		panic("config path is empty: " + err.Error())
-	}
+type MFAConfig struct {
+	// Base64-encoded 32-byte key TOTP secrets were encrypted with before
+	// EncryptionConfig, still used to decrypt them until they are re-encrypted.
+	// It is the master key too if EncryptionConfig has none
+	EncryptionKey string `yaml:"encryption_key" env:"MFA_ENCRYPTION_KEY"`
+	// Name shown in authenticator apps
+	Issuer string `yaml:"issuer" env-default:"sso"`
+	// Refuse admin RPCs to tokens issued without a second factor
+	RequireForAdmins bool `yaml:"require_for_admins" env-default:"true"`
+}
 
-	return &cfg
+// EncryptionConfig holds the master key app secrets, signing keys
+// and TOTP secrets are encrypted with at rest.
+type EncryptionConfig struct {
+	// Base64-encoded 32-byte key
+	Key string `yaml:"key" env:"ENCRYPTION_KEY"`
+	// File holding the base64-encoded key, used if key is empty
+	KeyFile string `yaml:"key_file" env:"ENCRYPTION_KEY_FILE"`
+	// Stored with everything the key encrypts, give every new key a new version
+	KeyVersion int `yaml:"key_version" env:"ENCRYPTION_KEY_VERSION" env-default:"1"`
+	// Previous keys by version, needed until "migrator -encrypt-secrets" re-encrypts their data
+	OldKeys map[int]string `yaml:"old_keys"`
 }
 
-func MustLoadPath(configPath string) *Config {
+// AdminConfig configures the admin API.
+type AdminConfig struct {
+	// App whose admins manage all users and apps. Admins of other apps
+	// manage only their own app, users can't be managed without it
+	AppID int `yaml:"app_id" env:"ADMIN_APP_ID"`
+}
+
+// AuditConfig tunes how audit events are written to storage.
+type AuditConfig struct {
+	// Events waiting to be saved, more are dropped
+	BufferSize    int           `yaml:"buffer_size" env-default:"4096"`
+	BatchSize     int           `yaml:"batch_size" env-default:"100"`
+	FlushInterval time.Duration `yaml:"flush_interval" env-default:"1s"`
+}
 
+// WebAuthnConfig describes the relying party passkeys are bound to.
+type WebAuthnConfig struct {
+	// Domain passkeys are scoped to, must be the origins' host or its parent
+	RPID   string `yaml:"rp_id" env-default:"localhost"`
+	RPName string `yaml:"rp_name" env-default:"sso"`
+	// Origins of the web apps allowed to run ceremonies
+	Origins      []string      `yaml:"origins" env-default:"http://localhost"`
+	ChallengeTTL time.Duration `yaml:"challenge_ttl" env-default:"5m"`
+}
+
+type EmailConfig struct {
+	// HMAC secret email verification links are signed with.
+	// If unset, email verification is off: no links are sent, emails can't be
+	// changed and unverified users can't log in to apps requiring verified emails.
+	TokenSecret     string        `yaml:"token_secret" env:"EMAIL_TOKEN_SECRET"`
+	VerificationTTL time.Duration `yaml:"verification_ttl" env-default:"24h"`
+	// Page of the web app that passes ?token= to VerifyEmail
+	VerificationURL string `yaml:"verification_url" env-default:"http://localhost/verify-email"`
+	// Password reset links are short-lived, anyone with access to the mailbox can use them
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl" env-default:"30m"`
+	// Page of the web app that passes ?token= and the new password to ResetPassword
+	PasswordResetURL string `yaml:"password_reset_url" env-default:"http://localhost/reset-password"`
+	From             string `yaml:"from" env-default:"sso@localhost"`
+	// smtp, or file to write emails to Dir instead of sending them
+	Mailer string     `yaml:"mailer" env-default:"file"`
+	Dir    string     `yaml:"dir" env-default:"./storage/mail"`
+	SMTP   SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host" env-default:"localhost"`
+	Port     int    `yaml:"port" env-default:"25"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD"`
+}
+
+// ThrottleConfig limits failed logins, see throttle.Policy.
+type ThrottleConfig struct {
+	// memory for a single replica, or database to share state between replicas
+	Store           string        `yaml:"store" env-default:"database"`
+	FreeAttempts    int           `yaml:"free_attempts" env-default:"5"`
+	IPFreeAttempts  int           `yaml:"ip_free_attempts" env-default:"20"`
+	BaseDelay       time.Duration `yaml:"base_delay" env-default:"1s"`
+	MaxDelay        time.Duration `yaml:"max_delay" env-default:"5m"`
+	LockoutAfter    int           `yaml:"lockout_after" env-default:"10"`
+	LockoutDuration time.Duration `yaml:"lockout_duration" env-default:"15m"`
+	Window          time.Duration `yaml:"window" env-default:"15m"`
+}
+
+// RateLimitConfig limits RPCs per client address with token buckets.
+// It is reloaded on SIGHUP.
+type RateLimitConfig struct {
+	// Policies by method name, e.g. Register. Methods not listed are not limited
+	Methods map[string]RateLimitPolicy `yaml:"methods"`
+	// Overrides of Methods for requests with the given app_id
+	Apps          map[int]map[string]RateLimitPolicy `yaml:"apps"`
+	SweepInterval time.Duration                      `yaml:"sweep_interval" env-default:"1m"`
+}
+
+type RateLimitPolicy struct {
+	// Calls per second, 0 disables limiting
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// defaultRateLimits are used if the config has no rate_limit.methods.
+var defaultRateLimits = map[string]RateLimitPolicy{
+	"Register":     {Rate: 0.2, Burst: 5},
+	"RefreshToken": {Rate: 1, Burst: 20},
+	// Unauthenticated and stores a challenge per call
+	"BeginPasskeyLogin": {Rate: 1, Burst: 10},
+}
+
+// PasswordConfig is the policy new passwords must meet.
+type PasswordConfig struct {
+	Policy PasswordPolicy `yaml:"policy"`
+	// Policies of apps replacing Policy for them, fields not set are zero
+	Apps map[int]PasswordPolicy `yaml:"apps"`
+	// Directory with Pwned Passwords range files, see password.Breached.
+	// Breached passwords are accepted if empty
+	BreachedDir string `yaml:"breached_dir"`
+	// BreachedDir holds only some ranges, passwords of the others are accepted.
+	// Without it passwords of missing ranges are rejected with an error
+	BreachedPartial bool               `yaml:"breached_partial"`
+	Hash            PasswordHashConfig `yaml:"hash"`
+}
+
+// PasswordHashConfig selects how new password hashes are made.
+// Hashes made with other settings are replaced when users log in.
+type PasswordHashConfig struct {
+	// argon2id or bcrypt
+	Algorithm  string `yaml:"algorithm" env-default:"argon2id"`
+	BcryptCost int    `yaml:"bcrypt_cost" env-default:"10"`
+	// Memory in KiB, the defaults follow the OWASP recommendation
+	Argon2Memory      uint32 `yaml:"argon2_memory" env-default:"19456"`
+	Argon2Iterations  uint32 `yaml:"argon2_iterations" env-default:"2"`
+	Argon2Parallelism uint8  `yaml:"argon2_parallelism" env-default:"1"`
+}
+
+type PasswordPolicy struct {
+	MinLength int `yaml:"min_length" env-default:"8"`
+	// In bytes, bcrypt ignores anything past 72
+	MaxLength     int  `yaml:"max_length" env-default:"72"`
+	RequireLower  bool `yaml:"require_lower"`
+	RequireUpper  bool `yaml:"require_upper"`
+	RequireDigit  bool `yaml:"require_digit"`
+	RequireSymbol bool `yaml:"require_symbol"`
+	// Allow passwords containing the user's email
+	AllowEmail bool `yaml:"allow_email"`
+}
+
+type JWTConfig struct {
+	// Algorithm new access tokens are signed with: HS256, RS256, ES256 or EdDSA
+	Algorithm string `yaml:"algorithm" env-default:"HS256"`
+	// Algorithms accepted on verification, defaults to Algorithm
+	AllowedAlgorithms []string `yaml:"allowed_algorithms"`
+}
+
+func MustLoad() *Config {
+	return MustLoadPath(fetchConfigPath())
+}
+
+func MustLoadPath(configPath string) *Config {
 	if configPath == "" {
 		panic("config path is empty")
 	}
@@ -54,13 +232,28 @@ func MustLoadPath(configPath string) *Config {
 		panic("config file does not exist: " + configPath)
 	}
 
+	cfg, err := Load(configPath)
+	if err != nil {
+		panic("config path is empty: " + err.Error())
+	}
+
+	return cfg
+}
+
+// Load reads the config without panicking, to reload it in a running server.
+func Load(configPath string) (*Config, error) {
 	var cfg Config
 
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
-		panic("config path is empty: " + err.Error())
+		return nil, err
+	}
+
+	if cfg.RateLimit.Methods == nil {
+		cfg.RateLimit.Methods = defaultRateLimits
 	}
+	cfg.Path = configPath
 
-	return &cfg
+	return &cfg, nil
 }
 
 func fetchConfigPath() string {
@@ -1,37 +1,315 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
-	"time"
+	"os"
+	"strings"
 
+	"sso/config"
 	grpcapp "sso/internal/app/grpc"
+	httpapp "sso/internal/app/http"
+	"sso/internal/lib/encryption"
+	"sso/internal/lib/mailer"
+	"sso/internal/lib/passhash"
+	"sso/internal/lib/password"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/lib/webauthn"
+	"sso/internal/services/apps"
+	"sso/internal/services/audit"
 	"sso/internal/services/auth"
+	"sso/internal/services/keys"
+	"sso/internal/services/mfa"
+	"sso/internal/services/passkey"
+	"sso/internal/services/rbac"
+	"sso/internal/services/throttle"
+	"sso/internal/services/users"
+	"sso/internal/services/verification"
+	"sso/internal/storage/denylist"
 	"sso/internal/storage/postgresql"
 )
 
 type App struct {
 	GRPCServer *grpcapp.App
+	HTTPServer *httpapp.App // nil if HTTP is disabled
 	Storage    *postgresql.Storage
+	Denylist   *denylist.Denylist
+	Throttle   *throttle.Throttle
+	RateLimit  *ratelimit.Limiter
+	Audit      *audit.Log
 }
 
 func New(
 	log *slog.Logger,
-	grpcPort int,
-	connection string,
-	tokenTTL time.Duration,
-	refreshTokenTTL time.Duration,
+	cfg *config.Config,
 ) *App {
-	storage, err := postgresql.New(connection)
+	secrets, err := SecretsKeyring(cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	authService := auth.New(log, storage, storage, storage, tokenTTL, refreshTokenTTL)
+	storage, err := postgresql.New(cfg.Connection, secrets)
+	if err != nil {
+		panic(err)
+	}
+
+	keysService := keys.New(log, storage, storage, cfg.JWT.Algorithm, cfg.JWT.AllowedAlgorithms, cfg.TokenTTL)
+
+	rbacService := rbac.New(log, storage, storage)
+
+	mfaService := mfa.New(log, storage, storage, storage, secrets, cfg.MFA.Issuer)
+
+	passkeyService := passkey.New(
+		log,
+		storage,
+		storage,
+		storage,
+		storage,
+		webauthn.RelyingParty{
+			ID:      cfg.WebAuthn.RPID,
+			Name:    cfg.WebAuthn.RPName,
+			Origins: cfg.WebAuthn.Origins,
+		},
+		cfg.WebAuthn.ChallengeTTL,
+	)
+
+	mail := mustMailer(log, cfg.Email)
+
+	if cfg.Email.TokenSecret == "" {
+		log.Warn("email verification is off, email.token_secret is not set")
+	}
+
+	verificationService := verification.New(
+		log,
+		storage,
+		storage,
+		storage,
+		mail,
+		cfg.Email.TokenSecret,
+		cfg.Email.VerificationTTL,
+		cfg.Email.VerificationURL,
+	)
+
+	auditLog := audit.New(log, storage, cfg.Audit.BufferSize, cfg.Audit.BatchSize, cfg.Audit.FlushInterval)
+
+	var throttleStore throttle.Store = storage
+	if cfg.Throttle.Store == "memory" {
+		throttleStore = throttle.NewMemory()
+	}
+
+	throttleService := throttle.New(log, throttleStore, auditLog, throttle.Policy{
+		FreeAttempts:    cfg.Throttle.FreeAttempts,
+		IPFreeAttempts:  cfg.Throttle.IPFreeAttempts,
+		BaseDelay:       cfg.Throttle.BaseDelay,
+		MaxDelay:        cfg.Throttle.MaxDelay,
+		LockoutAfter:    cfg.Throttle.LockoutAfter,
+		LockoutDuration: cfg.Throttle.LockoutDuration,
+		Window:          cfg.Throttle.Window,
+	})
+
+	passwordValidator := newPasswordValidator(cfg.Password)
 
-	grpcApp := grpcapp.New(log, authService, grpcPort)
+	hasher, err := passhash.New(cfg.Password.Hash.Algorithm, cfg.Password.Hash.BcryptCost, passhash.Argon2Params{
+		Memory:      cfg.Password.Hash.Argon2Memory,
+		Iterations:  cfg.Password.Hash.Argon2Iterations,
+		Parallelism: cfg.Password.Hash.Argon2Parallelism,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	tokenDenylist := denylist.New(log, storage, cfg.Revocation.CacheTTL, cfg.Revocation.SweepInterval)
+
+	issuer := cfg.OAuth.Issuer
+	if issuer == "" {
+		issuer = fmt.Sprintf("http://localhost:%d", cfg.HTTP.Port)
+	}
+
+	authService := auth.New(
+		log,
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+		keysService,
+		tokenDenylist,
+		storage,
+		storage,
+		mfaService,
+		storage,
+		passkeyService,
+		verificationService,
+		storage,
+		mail,
+		throttleService,
+		passwordValidator,
+		hasher,
+		auditLog,
+		cfg.TokenTTL,
+		cfg.RefreshTokenTTL,
+		cfg.OAuth.CodeTTL,
+		cfg.Email.PasswordResetTTL,
+		issuer,
+		cfg.Email.PasswordResetURL,
+	)
+
+	usersService := users.New(log, storage, storage, storage)
+	appsService := apps.New(log, storage, storage)
+
+	limiter := ratelimit.New(RateLimitPolicies(cfg.RateLimit), cfg.RateLimit.SweepInterval)
+
+	grpcApp := grpcapp.New(
+		log,
+		authService,
+		keysService,
+		rbacService,
+		mfaService,
+		passkeyService,
+		verificationService,
+		throttleService,
+		usersService,
+		appsService,
+		limiter,
+		auditLog,
+		cfg.Admin.AppID,
+		cfg.MFA.RequireForAdmins,
+		cfg.GRPC.Port,
+	)
+
+	var httpApp *httpapp.App
+	if cfg.HTTP.Port != 0 {
+		httpApp = httpapp.New(log, keysService, authService, issuer, cfg.HTTP.Metrics, cfg.HTTP.Port, cfg.HTTP.Timeout)
+	}
 
 	return &App{
 		GRPCServer: grpcApp,
+		HTTPServer: httpApp,
 		Storage:    storage,
+		Denylist:   tokenDenylist,
+		Throttle:   throttleService,
+		RateLimit:  limiter,
+		Audit:      auditLog,
+	}
+}
+
+// SecretsKeyring builds the keyring secrets are encrypted with at rest.
+// Without a master key of its own the MFA encryption key is used.
+func SecretsKeyring(cfg *config.Config) (*encryption.Keyring, error) {
+	key := cfg.Encryption.Key
+	if key == "" && cfg.Encryption.KeyFile != "" {
+		raw, err := os.ReadFile(cfg.Encryption.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+
+		key = strings.TrimSpace(string(raw))
+	}
+	if key == "" {
+		key = cfg.MFA.EncryptionKey
+	}
+	if key == "" {
+		return nil, errors.New("encryption key is required")
+	}
+
+	ciphers := make(map[int]*encryption.Cipher, len(cfg.Encryption.OldKeys)+1)
+	for version, oldKey := range cfg.Encryption.OldKeys {
+		cipher, err := encryption.NewFromBase64(oldKey)
+		if err != nil {
+			return nil, fmt.Errorf("old encryption key %d: %w", version, err)
+		}
+
+		ciphers[version] = cipher
+	}
+
+	current, err := encryption.NewFromBase64(key)
+	if err != nil {
+		return nil, err
+	}
+	ciphers[cfg.Encryption.KeyVersion] = current
+
+	var legacy *encryption.Cipher
+	if cfg.MFA.EncryptionKey != "" {
+		legacy, err = encryption.NewFromBase64(cfg.MFA.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return encryption.NewKeyring(cfg.Encryption.KeyVersion, ciphers, legacy)
+}
+
+// RateLimitPolicies converts the config for ratelimit.Limiter.
+func RateLimitPolicies(cfg config.RateLimitConfig) ratelimit.Policies {
+	convert := func(methods map[string]config.RateLimitPolicy) map[string]ratelimit.Policy {
+		res := make(map[string]ratelimit.Policy, len(methods))
+		for method, p := range methods {
+			res[method] = ratelimit.Policy{Rate: p.Rate, Burst: p.Burst}
+		}
+
+		return res
+	}
+
+	policies := ratelimit.Policies{
+		Methods: convert(cfg.Methods),
+		Apps:    make(map[int]map[string]ratelimit.Policy, len(cfg.Apps)),
+	}
+	for appID, methods := range cfg.Apps {
+		policies.Apps[appID] = convert(methods)
+	}
+
+	return policies
+}
+
+func newPasswordValidator(cfg config.PasswordConfig) *password.Validator {
+	convert := func(p config.PasswordPolicy) password.Policy {
+		return password.Policy{
+			MinLength:     p.MinLength,
+			MaxLength:     p.MaxLength,
+			RequireLower:  p.RequireLower,
+			RequireUpper:  p.RequireUpper,
+			RequireDigit:  p.RequireDigit,
+			RequireSymbol: p.RequireSymbol,
+			AllowEmail:    p.AllowEmail,
+		}
+	}
+
+	apps := make(map[int]password.Policy, len(cfg.Apps))
+	for appID, p := range cfg.Apps {
+		apps[appID] = convert(p)
+	}
+
+	var breached *password.Breached
+	if cfg.BreachedDir != "" {
+		var err error
+		breached, err = password.NewBreached(cfg.BreachedDir, cfg.BreachedPartial)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return password.NewValidator(convert(cfg.Policy), apps, breached)
+}
+
+func mustMailer(log *slog.Logger, cfg config.EmailConfig) verification.Mailer {
+	switch cfg.Mailer {
+	case "smtp":
+		return &mailer.SMTP{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.From,
+		}
+	case "file":
+		m, err := mailer.NewFile(log, cfg.Dir, cfg.From)
+		if err != nil {
+			panic(err)
+		}
+
+		return m
+	default:
+		panic("unknown mailer: " + cfg.Mailer)
 	}
 }
@@ -6,11 +6,16 @@ import (
 	"log/slog"
 	"net"
 	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/lib/peeraddr"
+	"sso/internal/lib/ratelimit"
+	"sso/internal/services/audit"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/selector"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -29,6 +34,18 @@ func InterceptorLogger(l *slog.Logger) logging.Logger {
 func New(
 	log *slog.Logger,
 	authService authgrpc.Auth,
+	keysService authgrpc.Keys,
+	rbacService authgrpc.RBAC,
+	mfaService authgrpc.MFA,
+	passkeyService authgrpc.Passkeys,
+	verificationService authgrpc.Verification,
+	throttleService authgrpc.Throttle,
+	usersService authgrpc.Users,
+	appsService authgrpc.Apps,
+	limiter *ratelimit.Limiter,
+	auditLog *audit.Log,
+	adminAppID int,
+	requireAdminMFA bool,
 	port int,
 ) *App {
 	loggingOpts := []logging.Option{
@@ -47,10 +64,31 @@ func New(
 
 	gRPCServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
 		recovery.UnaryServerInterceptor(recoveryOpts...),
-		logging.UnaryServerInterceptor(InterceptorLogger(log), loggingOpts...),
+		selector.UnaryServerInterceptor(
+			logging.UnaryServerInterceptor(InterceptorLogger(log), loggingOpts...),
+			selector.MatchFunc(logsPayload),
+		),
+		peerAddrInterceptor,
+		userAgentInterceptor,
+		rateLimitInterceptor(log, limiter),
+		auditInterceptor(auditLog, authService),
 	))
 
-	authgrpc.Register(gRPCServer, authService)
+	authgrpc.Register(
+		gRPCServer,
+		authService,
+		keysService,
+		rbacService,
+		mfaService,
+		passkeyService,
+		verificationService,
+		throttleService,
+		usersService,
+		appsService,
+		auditLog,
+		adminAppID,
+		requireAdminMFA,
+	)
 
 	return &App{
 		log:        log,
@@ -59,6 +97,20 @@ func New(
 	}
 }
 
+// peerAddrInterceptor passes the client address to services, see peeraddr.
+func peerAddrInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		ctx = peeraddr.With(ctx, p.Addr.String())
+	}
+
+	return handler(ctx, req)
+}
+
 func (a *App) MustRun() {
 	if err := a.Run(); err != nil {
 		panic(err)
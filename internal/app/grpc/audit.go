@@ -0,0 +1,144 @@
+package grpcapp
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sso/internal/domain/models"
+	"sso/internal/lib/useragent"
+	"sso/internal/services/audit"
+	"strings"
+
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Admin methods recorded in the audit log, calls that only read are not.
+var auditedMethods = map[string]bool{
+	"DisableUser":     true,
+	"EnableUser":      true,
+	"LogoutUser":      true,
+	"DeleteUser":      true,
+	"SetUserRoles":    true,
+	"CreateApp":       true,
+	"UpdateApp":       true,
+	"DeleteApp":       true,
+	"RotateAppSecret": true,
+	"AssignRole":      true,
+	"RevokeRole":      true,
+	"UnlockAccount":   true,
+}
+
+// TokenValidator identifies the admin making a call.
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (models.TokenInfo, error)
+}
+
+// userAgentInterceptor passes the client's user agent to services, see useragent.
+func userAgentInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			ctx = useragent.With(ctx, values[0])
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+// auditInterceptor records calls of auditedMethods with their outcome,
+// including the ones refused for lack of permissions.
+// It must run after peerAddrInterceptor and userAgentInterceptor.
+func auditInterceptor(auditLog *audit.Log, tokens TokenValidator) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		method := path.Base(info.FullMethod)
+		if !auditedMethods[method] {
+			return handler(ctx, req)
+		}
+
+		resp, err := handler(ctx, req)
+
+		event := models.AuditEvent{
+			Type:    models.AuditAdmin,
+			Outcome: models.AuditSuccess,
+			UserID:  callerID(ctx, tokens),
+			Action:  method,
+			Target:  auditTarget(req, resp),
+		}
+		if r, ok := req.(appRequest); ok {
+			event.AppID = int(r.GetAppId())
+		}
+
+		switch status.Code(err) {
+		case codes.OK:
+		case codes.PermissionDenied, codes.Unauthenticated:
+			event.Outcome = models.AuditDenied
+			event.Reason = status.Convert(err).Message()
+		default:
+			event.Outcome = models.AuditFailure
+			event.Reason = status.Convert(err).Message()
+		}
+
+		auditLog.Record(ctx, event)
+
+		return resp, err
+	}
+}
+
+// callerID returns the user of the access token of the call, 0 if it is missing or invalid.
+func callerID(ctx context.Context, tokens TokenValidator) int64 {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return 0
+	}
+
+	scheme, token, found := strings.Cut(values[0], " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return 0
+	}
+
+	info, err := tokens.ValidateToken(ctx, token)
+	if err != nil || !info.Active {
+		return 0
+	}
+
+	return info.UserID
+}
+
+// auditTarget describes what the call is done to: a user, an app or an account email.
+func auditTarget(req interface{}, resp interface{}) string {
+	switch r := req.(type) {
+	case interface{ GetUserId() int64 }:
+		return fmt.Sprintf("user:%d", r.GetUserId())
+	case interface{ GetClientId() int32 }:
+		return fmt.Sprintf("app:%d", r.GetClientId())
+	case interface{ GetEmail() string }:
+		return "email:" + r.GetEmail()
+	}
+
+	// CreateApp has the ID of the app only in the response
+	for _, msg := range []interface{}{resp, req} {
+		if r, ok := msg.(interface{ GetApp() *ssov1.App }); ok && r.GetApp().GetId() != 0 {
+			return fmt.Sprintf("app:%d", r.GetApp().GetId())
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,28 @@
+package grpcapp
+
+import (
+	"context"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+)
+
+// secretPayloads are the methods whose requests or responses carry
+// passwords, client secrets or second factors, they are never logged.
+var secretPayloads = map[string]bool{
+	ssov1.Auth_ClientCredentials_FullMethodName: true,
+	ssov1.Auth_VerifyMFA_FullMethodName:         true,
+	ssov1.Auth_EnrollTOTP_FullMethodName:        true,
+	ssov1.Auth_ConfirmTOTP_FullMethodName:       true,
+	ssov1.Auth_DisableTOTP_FullMethodName:       true,
+	ssov1.Auth_ResetPassword_FullMethodName:     true,
+	ssov1.Auth_ChangePassword_FullMethodName:    true,
+	ssov1.Auth_ChangeEmail_FullMethodName:       true,
+	ssov1.Admin_CreateApp_FullMethodName:        true,
+	ssov1.Admin_RotateAppSecret_FullMethodName:  true,
+}
+
+// logsPayload reports whether the payloads of the call may be logged.
+func logsPayload(_ context.Context, callMeta interceptors.CallMeta) bool {
+	return !secretPayloads[callMeta.FullMethod()]
+}
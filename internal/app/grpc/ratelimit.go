@@ -0,0 +1,56 @@
+package grpcapp
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"path"
+	"sso/internal/lib/peeraddr"
+	"sso/internal/lib/ratelimit"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// appRequest is implemented by requests carrying an app_id.
+type appRequest interface {
+	GetAppId() int32
+}
+
+// rateLimitInterceptor limits calls per method, app and client address.
+// It must run after peerAddrInterceptor.
+func rateLimitInterceptor(log *slog.Logger, limiter *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		method := path.Base(info.FullMethod)
+
+		var appID int
+		if r, ok := req.(appRequest); ok {
+			appID = int(r.GetAppId())
+		}
+
+		client := peeraddr.From(ctx)
+
+		if ok, retryAfter := limiter.Allow(method, appID, client); !ok {
+			log.Warn("rate limit exceeded",
+				slog.String("method", method),
+				slog.Int("app_id", appID),
+				slog.String("addr", client),
+			)
+
+			seconds := int64(math.Ceil(retryAfter.Seconds()))
+			_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.FormatInt(seconds, 10)))
+
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded, try again later")
+		}
+
+		return handler(ctx, req)
+	}
+}
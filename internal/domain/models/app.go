@@ -1,8 +1,51 @@
 package models
 
+import (
+	"slices"
+	"time"
+)
+
+// OAuth 2.0 grant types an app may be allowed to use
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// Access token claims an app may leave out of its tokens
+const (
+	ClaimEmail = "email"
+	ClaimRoles = "roles"
+)
+
 type App struct {
 	ID             int
 	Name           string
 	Secret         string
 	Refresh_secret string
+	Scopes         []string // scopes clients of the app may request
+
+	// OAuth 2.0 client settings
+	RedirectURIs     []string
+	ClientSecretHash []byte // empty for public clients
+	GrantTypes       []string
+
+	// Client credentials grant restrictions
+	ClientScopes []string // scopes the app may request for itself
+	Audiences    []string // services the app's own tokens may be used at
+
+	// Users with unverified email can't log in to the app
+	RequireVerifiedEmail bool
+
+	// Token lifetimes, zero means the global default
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Claims left out of the app's access and refresh tokens, see ClaimEmail
+	OmitClaims []string
+}
+
+// AllowsGrant reports whether the app may use the OAuth 2.0 grant type.
+func (a App) AllowsGrant(grantType string) bool {
+	return slices.Contains(a.GrantTypes, grantType)
 }
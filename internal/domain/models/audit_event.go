@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// Types of audit events
+const (
+	AuditLogin      = "login"
+	AuditRegister   = "register"
+	AuditRefresh    = "refresh"
+	AuditTokenReuse = "token_reuse" // a used refresh token was presented again
+	AuditLockout    = "lockout"     // an account was locked after failed logins
+	AuditAdmin      = "admin"       // a call of the admin API, see AuditEvent.Action
+)
+
+// Outcomes of audit events
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	// The caller is not allowed to do it
+	AuditDenied = "denied"
+	// The password or passkey is accepted, the second factor is required
+	AuditMFARequired = "mfa_required"
+)
+
+// AuditEvent records a security-relevant event. Events are never changed once saved.
+type AuditEvent struct {
+	ID        int64
+	CreatedAt time.Time
+	Type      string
+	Outcome   string
+	UserID    int64  // the user acting, 0 if unknown
+	Email     string // the email given to log in, also of users that don't exist
+	AppID     int
+	IP        string
+	UserAgent string
+	Action    string // admin API method, e.g. "DisableUser"
+	Target    string // what the admin action is done to, e.g. "user:42"
+	Reason    string // why it failed
+}
+
+// AuditFilter selects audit events. Zero fields match any event.
+type AuditFilter struct {
+	Type    string
+	Outcome string
+	UserID  int64
+	Email   string
+	AppID   int
+	IP      string
+	Since   time.Time
+	Until   time.Time // exclusive
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// AuthorizationCode is a pending OAuth 2.0 authorization code grant.
+// The code itself is never stored, only its hash.
+type AuthorizationCode struct {
+	Hash          string // hex-encoded SHA-256 of the code
+	AppID         int
+	UserID        int64
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string // PKCE S256 challenge
+	Nonce         string // OpenID Connect nonce, echoed in the ID token
+	AuthTime      time.Time
+	AMR           []string
+	ExpiresAt     time.Time
+}
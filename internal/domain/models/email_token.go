@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+const (
+	EmailTokenVerification  = "email_verification"
+	EmailTokenPasswordReset = "password_reset"
+	EmailTokenEmailChange   = "email_change"
+)
+
+// EmailToken is a single-use token sent to the user by email.
+// Only its ID is stored: the jti of a signed verification token
+// or the hash of a random password reset token.
+type EmailToken struct {
+	ID        string
+	UserID    int64
+	Purpose   string
+	ExpiresAt time.Time
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential registered by a user.
+type Passkey struct {
+	ID         []byte // credential ID chosen by the authenticator
+	UserID     int64
+	PublicKey  []byte // PKIX, DER
+	Algorithm  string
+	SignCount  uint32
+	Transports []string
+	CreatedAt  time.Time
+	LastUsedAt time.Time // zero if never used
+}
+
+const (
+	WebAuthnRegistration = "registration"
+	WebAuthnLogin        = "login"
+)
+
+// WebAuthnChallenge is a pending registration or login ceremony.
+// Each challenge can be used once.
+type WebAuthnChallenge struct {
+	Challenge string // base64url, as in client data
+	UserID    int64  // 0 for login, the user is not known yet
+	Purpose   string
+	ExpiresAt time.Time
+}
+
+// PasskeyAssertion is the response of navigator.credentials.get.
+type PasskeyAssertion struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte // optional
+}
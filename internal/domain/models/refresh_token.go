@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// RefreshToken is a server-side record of an issued refresh token.
+// The token itself is never stored, only its hash.
+type RefreshToken struct {
+	ID        string // jti claim
+	FamilyID  string // shared by all tokens rotated from the same login
+	UserID    int64
+	AppID     int
+	TokenHash []byte
+	ExpiresAt time.Time
+	Scopes    []string // granted at login, may only be narrowed on refresh
+	AMR       []string // how the user authenticated (RFC 8176), kept for the session
+	Used      bool
+	Revoked   bool
+}
@@ -0,0 +1,13 @@
+package models
+
+// RoleAdmin is granted every permission in its app.
+const RoleAdmin = "admin"
+
+// PermissionManageUsers grants access to the admin API for user accounts.
+const PermissionManageUsers = "users:manage"
+
+// PermissionManageApps grants access to the admin API for apps.
+const PermissionManageApps = "apps:manage"
+
+// PermissionViewAudit grants access to the audit log.
+const PermissionViewAudit = "audit:read"
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type KeyState string
+
+const (
+	// KeyStatePending keys are published but not used for signing yet,
+	// so verifiers can pick them up before rotation.
+	KeyStatePending KeyState = "pending"
+	// KeyStateActive is the key new tokens are signed with.
+	KeyStateActive KeyState = "active"
+	// KeyStateRetiring keys only verify tokens issued before rotation.
+	KeyStateRetiring KeyState = "retiring"
+	// KeyStateRevoked keys are neither used nor published.
+	KeyStateRevoked KeyState = "revoked"
+)
+
+// SigningKey is a key used to sign access tokens of an app.
+type SigningKey struct {
+	ID         string // kid header
+	AppID      int
+	Algorithm  string // HS256, RS256, ES256 or EdDSA
+	PrivateKey []byte // PKCS #8 DER, or the raw secret for HS256
+	PublicKey  []byte // PKIX DER, empty for HS256
+	State      KeyState
+	CreatedAt  time.Time
+	UpdatedAt  time.Time // time of the last state change
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// TokenInfo is the result of access token introspection (RFC 7662).
+// Only Active is meaningful for inactive tokens.
+type TokenInfo struct {
+	Active    bool
+	Revoked   bool
+	UserID    int64
+	Email     string
+	AppID     int
+	Scopes    []string
+	Roles     []string
+	MFA       bool   // the user passed a second factor
+	SessionID string // refresh token family the token was issued with
+	ExpiresAt time.Time
+}
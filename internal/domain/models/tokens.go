@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Tokens is a result of a successful grant.
+// RefreshToken is empty for grants that don't issue one,
+// IDToken is set only if the openid scope is granted.
+// If a second factor is required, only MFAToken is set.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	MFAToken     string
+	ExpiresAt    time.Time // access token expiry
+	Scopes       []string
+}
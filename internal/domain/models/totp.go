@@ -0,0 +1,10 @@
+package models
+
+// TOTP is a user's authenticator app enrollment.
+// MFA is enabled for the user once the enrollment is confirmed.
+type TOTP struct {
+	UserID       int64
+	Secret       []byte // encrypted
+	Confirmed    bool
+	LastUsedStep int64 // time step of the last accepted code, to reject replays
+}
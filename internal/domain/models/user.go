@@ -4,4 +4,8 @@ type User struct {
 	ID       int64
 	Email    string
 	PassHash []byte
+
+	EmailVerified bool
+	// Disabled users can't log in, see users.Users.Disable
+	Disabled bool
 }
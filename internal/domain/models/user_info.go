@@ -0,0 +1,8 @@
+package models
+
+// UserInfo holds OpenID Connect claims about the user (OIDC Core, 5.3.2).
+// Email is empty unless the email scope is granted.
+type UserInfo struct {
+	UserID int64
+	Email  string
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"sso/internal/lib/password"
+	"sso/internal/services/auth"
+	"sso/internal/services/throttle"
+	"sso/internal/services/verification"
+	"sso/internal/storage"
+
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RequestPasswordReset mails a password reset link. It succeeds
+// for unknown emails too, so it can't be used to find registered users.
+func (s *serverAPI) RequestPasswordReset(
+	ctx context.Context,
+	in *ssov1.RequestPasswordResetRequest,
+) (*ssov1.RequestPasswordResetResponse, error) {
+	if in.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+	if _, err := mail.ParseAddress(in.GetEmail()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+
+	if err := s.auth.RequestPasswordReset(ctx, in.GetEmail()); err != nil {
+		return nil, status.Error(codes.Internal, "failed to request password reset")
+	}
+
+	return &ssov1.RequestPasswordResetResponse{}, nil
+}
+
+// ResetPassword sets a new password with the token from the reset link.
+func (s *serverAPI) ResetPassword(
+	ctx context.Context,
+	in *ssov1.ResetPasswordRequest,
+) (*ssov1.ResetPasswordResponse, error) {
+	if in.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	if in.GetNewPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "new_password is required")
+	}
+
+	if err := s.auth.ResetPassword(ctx, in.GetToken(), in.GetNewPassword(), int(in.GetAppId())); err != nil {
+		if errors.Is(err, auth.ErrInvalidResetToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired token")
+		}
+		var policyErr *password.PolicyError
+		if errors.As(err, &policyErr) {
+			return nil, passwordPolicyError(policyErr, "new_password")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	return &ssov1.ResetPasswordResponse{}, nil
+}
+
+// ChangePassword replaces the caller's password and ends their other sessions.
+func (s *serverAPI) ChangePassword(
+	ctx context.Context,
+	in *ssov1.ChangePasswordRequest,
+) (*ssov1.ChangePasswordResponse, error) {
+	if in.GetCurrentPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "current_password is required")
+	}
+	if in.GetNewPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "new_password is required")
+	}
+
+	info, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.UserID == 0 {
+		return nil, status.Error(codes.PermissionDenied, "user token is required")
+	}
+
+	err = s.auth.ChangePassword(
+		ctx,
+		info.UserID,
+		info.AppID,
+		info.SessionID,
+		in.GetCurrentPassword(),
+		in.GetNewPassword(),
+	)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid current password")
+		}
+		if errors.Is(err, throttle.ErrThrottled) {
+			return nil, throttledError(ctx, err)
+		}
+		var policyErr *password.PolicyError
+		if errors.As(err, &policyErr) {
+			return nil, passwordPolicyError(policyErr, "new_password")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to change password")
+	}
+
+	return &ssov1.ChangePasswordResponse{}, nil
+}
+
+// ChangeEmail sends a confirmation link to the caller's new email,
+// see VerifyEmail.
+func (s *serverAPI) ChangeEmail(
+	ctx context.Context,
+	in *ssov1.ChangeEmailRequest,
+) (*ssov1.ChangeEmailResponse, error) {
+	if in.GetCurrentPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "current_password is required")
+	}
+	if in.GetNewEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "new_email is required")
+	}
+	if _, err := mail.ParseAddress(in.GetNewEmail()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+
+	info, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.UserID == 0 {
+		return nil, status.Error(codes.PermissionDenied, "user token is required")
+	}
+
+	if err := s.auth.ChangeEmail(ctx, info.UserID, in.GetCurrentPassword(), in.GetNewEmail()); err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid current password")
+		}
+		if errors.Is(err, throttle.ErrThrottled) {
+			return nil, throttledError(ctx, err)
+		}
+		if errors.Is(err, storage.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
+		if errors.Is(err, verification.ErrDisabled) {
+			return nil, status.Error(codes.FailedPrecondition, "email verification is disabled")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to change email")
+	}
+
+	return &ssov1.ChangeEmailResponse{}, nil
+}
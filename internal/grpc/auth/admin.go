@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/services/users"
+	"strconv"
+	"strings"
+
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type Users interface {
+	List(ctx context.Context, emailQuery string, afterID int64, limit int) ([]models.User, error)
+	User(ctx context.Context, userID int64) (models.User, error)
+	Disable(ctx context.Context, userID int64) error
+	Enable(ctx context.Context, userID int64) error
+	Logout(ctx context.Context, userID int64) error
+	Delete(ctx context.Context, userID int64) error
+}
+
+// adminAPI serves the Admin service, callers must be granted
+// models.PermissionManageUsers, models.PermissionManageApps or
+// models.PermissionViewAudit in the app of the request. Users are
+// shared by all apps, so only the admin app manages them; admins
+// of other apps manage only their own app. Roles are set only by
+// admins of the app.
+type adminAPI struct {
+	ssov1.UnimplementedAdminServer
+	api   *serverAPI
+	users Users
+	apps  Apps
+	audit Audit
+}
+
+func (s *adminAPI) ListUsers(
+	ctx context.Context,
+	in *ssov1.ListUsersRequest,
+) (*ssov1.ListUsersResponse, error) {
+	if in.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if in.GetPageSize() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+
+	afterID, err := decodePageToken(in.GetPageToken())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+
+	pageSize := int(in.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	pageSize = min(pageSize, maxPageSize)
+
+	if _, err := s.api.requireGlobalPermission(ctx, int(in.GetAppId()), models.PermissionManageUsers); err != nil {
+		return nil, err
+	}
+
+	// One more than asked tells if there is a next page
+	list, err := s.users.List(ctx, strings.TrimSpace(in.GetEmailQuery()), afterID, pageSize+1)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to list users")
+	}
+
+	resp := &ssov1.ListUsersResponse{}
+	if len(list) > pageSize {
+		list = list[:pageSize]
+		resp.NextPageToken = encodePageToken(list[len(list)-1].ID)
+	}
+
+	for _, user := range list {
+		resp.Users = append(resp.Users, userToProto(user))
+	}
+
+	return resp, nil
+}
+
+func (s *adminAPI) GetUser(
+	ctx context.Context,
+	in *ssov1.GetUserRequest,
+) (*ssov1.GetUserResponse, error) {
+	if err := validateUserRequest(in.GetAppId(), in.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.api.requireGlobalPermission(ctx, int(in.GetAppId()), models.PermissionManageUsers); err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.User(ctx, in.GetUserId())
+	if err != nil {
+		return nil, userError(err, "failed to get user")
+	}
+
+	roles, err := s.api.rbac.UserRoles(ctx, user.ID, int(in.GetAppId()))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get user roles")
+	}
+
+	return &ssov1.GetUserResponse{User: userToProto(user), Roles: roles}, nil
+}
+
+// DisableUser stops the user from logging in and ends their sessions.
+func (s *adminAPI) DisableUser(
+	ctx context.Context,
+	in *ssov1.DisableUserRequest,
+) (*ssov1.DisableUserResponse, error) {
+	if err := validateUserRequest(in.GetAppId(), in.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	info, err := s.api.requireGlobalPermission(ctx, int(in.GetAppId()), models.PermissionManageUsers)
+	if err != nil {
+		return nil, err
+	}
+	if info.UserID == in.GetUserId() {
+		return nil, status.Error(codes.FailedPrecondition, "can't disable yourself")
+	}
+
+	if err := s.users.Disable(ctx, in.GetUserId()); err != nil {
+		return nil, userError(err, "failed to disable user")
+	}
+
+	return &ssov1.DisableUserResponse{}, nil
+}
+
+func (s *adminAPI) EnableUser(
+	ctx context.Context,
+	in *ssov1.EnableUserRequest,
+) (*ssov1.EnableUserResponse, error) {
+	if err := validateUserRequest(in.GetAppId(), in.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.api.requireGlobalPermission(ctx, int(in.GetAppId()), models.PermissionManageUsers); err != nil {
+		return nil, err
+	}
+
+	if err := s.users.Enable(ctx, in.GetUserId()); err != nil {
+		return nil, userError(err, "failed to enable user")
+	}
+
+	return &ssov1.EnableUserResponse{}, nil
+}
+
+// LogoutUser ends all sessions of the user.
+func (s *adminAPI) LogoutUser(
+	ctx context.Context,
+	in *ssov1.LogoutUserRequest,
+) (*ssov1.LogoutUserResponse, error) {
+	if err := validateUserRequest(in.GetAppId(), in.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.api.requireGlobalPermission(ctx, int(in.GetAppId()), models.PermissionManageUsers); err != nil {
+		return nil, err
+	}
+
+	if err := s.users.Logout(ctx, in.GetUserId()); err != nil {
+		return nil, userError(err, "failed to log out user")
+	}
+
+	return &ssov1.LogoutUserResponse{}, nil
+}
+
+func (s *adminAPI) DeleteUser(
+	ctx context.Context,
+	in *ssov1.DeleteUserRequest,
+) (*ssov1.DeleteUserResponse, error) {
+	if err := validateUserRequest(in.GetAppId(), in.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	info, err := s.api.requireGlobalPermission(ctx, int(in.GetAppId()), models.PermissionManageUsers)
+	if err != nil {
+		return nil, err
+	}
+	if info.UserID == in.GetUserId() {
+		return nil, status.Error(codes.FailedPrecondition, "can't delete yourself")
+	}
+
+	if err := s.users.Delete(ctx, in.GetUserId()); err != nil {
+		return nil, userError(err, "failed to delete user")
+	}
+
+	return &ssov1.DeleteUserResponse{}, nil
+}
+
+// SetUserRoles replaces the roles of the user in the app. Roles may grant
+// more than models.PermissionManageUsers, so only admins of the app set them,
+// as with AssignRole.
+func (s *adminAPI) SetUserRoles(
+	ctx context.Context,
+	in *ssov1.SetUserRolesRequest,
+) (*ssov1.SetUserRolesResponse, error) {
+	if err := validateUserRequest(in.GetAppId(), in.GetUserId()); err != nil {
+		return nil, err
+	}
+
+	roles := make([]string, 0, len(in.GetRoles()))
+	for _, role := range in.GetRoles() {
+		role = strings.TrimSpace(role)
+		if role == "" {
+			return nil, status.Error(codes.InvalidArgument, "roles must not be empty")
+		}
+
+		roles = append(roles, role)
+	}
+	slices.Sort(roles)
+	roles = slices.Compact(roles)
+
+	if _, err := s.api.requireAdmin(ctx, int(in.GetAppId())); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.users.User(ctx, in.GetUserId()); err != nil {
+		return nil, userError(err, "failed to set user roles")
+	}
+
+	if err := s.api.rbac.SetRoles(ctx, in.GetUserId(), int(in.GetAppId()), roles); err != nil {
+		return nil, status.Error(codes.Internal, "failed to set user roles")
+	}
+
+	return &ssov1.SetUserRolesResponse{Roles: roles}, nil
+}
+
+func validateUserRequest(appID int32, userID int64) error {
+	if appID == 0 {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if userID == 0 {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	return nil
+}
+
+func userError(err error, msg string) error {
+	if errors.Is(err, users.ErrUserNotFound) {
+		return status.Error(codes.NotFound, "user not found")
+	}
+
+	return status.Error(codes.Internal, msg)
+}
+
+func userToProto(user models.User) *ssov1.User {
+	return &ssov1.User{
+		Id:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Disabled:      user.Disabled,
+	}
+}
+
+// Page tokens are opaque to clients, they hold the last ID of the previous page.
+func encodePageToken(lastID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(lastID, 10)))
+}
+
+func decodePageToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(raw), 10, 64)
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/scopes"
+	"sso/internal/services/apps"
+	"strings"
+	"time"
+	"unicode"
+
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var grantTypes = []string{
+	models.GrantAuthorizationCode,
+	models.GrantRefreshToken,
+	models.GrantClientCredentials,
+}
+
+// Claims the server itself relies on, such as sid and amr, can't be omitted
+var omittableClaims = []string{
+	models.ClaimEmail,
+	models.ClaimRoles,
+}
+
+type Apps interface {
+	Create(ctx context.Context, app models.App, confidential bool) (models.App, string, error)
+	List(ctx context.Context, afterID int, limit int) ([]models.App, error)
+	App(ctx context.Context, id int) (models.App, error)
+	Update(ctx context.Context, app models.App) (models.App, error)
+	Delete(ctx context.Context, id int) error
+	RotateSecret(ctx context.Context, id int) (string, error)
+}
+
+// CreateApp registers an app, only the admin app can. The client secret
+// of a confidential app is returned only here and can't be shown again.
+func (s *adminAPI) CreateApp(
+	ctx context.Context,
+	in *ssov1.CreateAppRequest,
+) (*ssov1.CreateAppResponse, error) {
+	if in.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	app, err := appFromProto(in.GetApp())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.api.requireGlobalPermission(ctx, int(in.GetAppId()), models.PermissionManageApps); err != nil {
+		return nil, err
+	}
+
+	app, clientSecret, err := s.apps.Create(ctx, app, in.GetApp().GetConfidential())
+	if err != nil {
+		return nil, appError(err, "failed to create app")
+	}
+
+	return &ssov1.CreateAppResponse{App: appToProto(app), ClientSecret: clientSecret}, nil
+}
+
+// ListApps lists all apps, only the admin app can.
+func (s *adminAPI) ListApps(
+	ctx context.Context,
+	in *ssov1.ListAppsRequest,
+) (*ssov1.ListAppsResponse, error) {
+	if in.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if in.GetPageSize() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+
+	afterID, err := decodePageToken(in.GetPageToken())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+
+	pageSize := int(in.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	pageSize = min(pageSize, maxPageSize)
+
+	if _, err := s.api.requireGlobalPermission(ctx, int(in.GetAppId()), models.PermissionManageApps); err != nil {
+		return nil, err
+	}
+
+	list, err := s.apps.List(ctx, int(afterID), pageSize+1)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to list apps")
+	}
+
+	resp := &ssov1.ListAppsResponse{}
+	if len(list) > pageSize {
+		list = list[:pageSize]
+		resp.NextPageToken = encodePageToken(int64(list[len(list)-1].ID))
+	}
+
+	for _, app := range list {
+		resp.Apps = append(resp.Apps, appToProto(app))
+	}
+
+	return resp, nil
+}
+
+func (s *adminAPI) GetApp(
+	ctx context.Context,
+	in *ssov1.GetAppRequest,
+) (*ssov1.GetAppResponse, error) {
+	if err := validateAppRequest(in.GetAppId(), in.GetClientId()); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.api.requireAppPermission(ctx, int(in.GetAppId()), int(in.GetClientId()), models.PermissionManageApps); err != nil {
+		return nil, err
+	}
+
+	app, err := s.apps.App(ctx, int(in.GetClientId()))
+	if err != nil {
+		return nil, appError(err, "failed to get app")
+	}
+
+	return &ssov1.GetAppResponse{App: appToProto(app)}, nil
+}
+
+// UpdateApp replaces the settings of the app. Secrets are kept,
+// so the confidential field is ignored, see RotateAppSecret.
+func (s *adminAPI) UpdateApp(
+	ctx context.Context,
+	in *ssov1.UpdateAppRequest,
+) (*ssov1.UpdateAppResponse, error) {
+	if err := validateAppRequest(in.GetAppId(), in.GetApp().GetId()); err != nil {
+		return nil, err
+	}
+
+	app, err := appFromProto(in.GetApp())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.api.requireAppPermission(ctx, int(in.GetAppId()), app.ID, models.PermissionManageApps); err != nil {
+		return nil, err
+	}
+
+	app, err = s.apps.Update(ctx, app)
+	if err != nil {
+		return nil, appError(err, "failed to update app")
+	}
+
+	return &ssov1.UpdateAppResponse{App: appToProto(app)}, nil
+}
+
+// DeleteApp deletes the app with all tokens, keys and roles of it.
+func (s *adminAPI) DeleteApp(
+	ctx context.Context,
+	in *ssov1.DeleteAppRequest,
+) (*ssov1.DeleteAppResponse, error) {
+	if err := validateAppRequest(in.GetAppId(), in.GetClientId()); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.api.requireAppPermission(ctx, int(in.GetAppId()), int(in.GetClientId()), models.PermissionManageApps); err != nil {
+		return nil, err
+	}
+	if in.GetClientId() == in.GetAppId() {
+		return nil, status.Error(codes.FailedPrecondition, "can't delete the app of the request")
+	}
+
+	if err := s.apps.Delete(ctx, int(in.GetClientId())); err != nil {
+		return nil, appError(err, "failed to delete app")
+	}
+
+	return &ssov1.DeleteAppResponse{}, nil
+}
+
+// RotateAppSecret replaces the client secret of the app and returns the new one.
+func (s *adminAPI) RotateAppSecret(
+	ctx context.Context,
+	in *ssov1.RotateAppSecretRequest,
+) (*ssov1.RotateAppSecretResponse, error) {
+	if err := validateAppRequest(in.GetAppId(), in.GetClientId()); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.api.requireAppPermission(ctx, int(in.GetAppId()), int(in.GetClientId()), models.PermissionManageApps); err != nil {
+		return nil, err
+	}
+
+	secret, err := s.apps.RotateSecret(ctx, int(in.GetClientId()))
+	if err != nil {
+		return nil, appError(err, "failed to rotate client secret")
+	}
+
+	return &ssov1.RotateAppSecretResponse{ClientSecret: secret}, nil
+}
+
+func validateAppRequest(appID int32, clientID int32) error {
+	if appID == 0 {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if clientID == 0 {
+		return status.Error(codes.InvalidArgument, "client_id is required")
+	}
+
+	return nil
+}
+
+// appFromProto validates the app settings of a request.
+func appFromProto(in *ssov1.App) (models.App, error) {
+	if in == nil {
+		return models.App{}, status.Error(codes.InvalidArgument, "app is required")
+	}
+
+	name := strings.TrimSpace(in.GetName())
+	if name == "" {
+		return models.App{}, status.Error(codes.InvalidArgument, "name is required")
+	}
+	if in.GetAccessTokenTtlSeconds() < 0 || in.GetRefreshTokenTtlSeconds() < 0 {
+		return models.App{}, status.Error(codes.InvalidArgument, "token ttl must not be negative")
+	}
+
+	// Lists are stored space-separated
+	for field, values := range map[string][]string{
+		"scopes":        in.GetScopes(),
+		"redirect_uris": in.GetRedirectUris(),
+		"client_scopes": in.GetClientScopes(),
+		"audiences":     in.GetAudiences(),
+		"grant_types":   in.GetGrantTypes(),
+		"omit_claims":   in.GetOmitClaims(),
+	} {
+		for _, v := range values {
+			if v == "" || strings.ContainsFunc(v, unicode.IsSpace) {
+				return models.App{}, status.Errorf(codes.InvalidArgument, "%s must not be empty or contain spaces", field)
+			}
+		}
+	}
+
+	for _, uri := range in.GetRedirectUris() {
+		// RFC 6749, section 3.1.2
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return models.App{}, status.Errorf(codes.InvalidArgument, "invalid redirect uri %q", uri)
+		}
+	}
+
+	for _, grantType := range in.GetGrantTypes() {
+		if !slices.Contains(grantTypes, grantType) {
+			return models.App{}, status.Errorf(codes.InvalidArgument, "unsupported grant type %q", grantType)
+		}
+	}
+
+	for _, claim := range in.GetOmitClaims() {
+		if !slices.Contains(omittableClaims, claim) {
+			return models.App{}, status.Errorf(codes.InvalidArgument, "claim %q can't be omitted", claim)
+		}
+	}
+
+	return models.App{
+		ID:                   int(in.GetId()),
+		Name:                 name,
+		Scopes:               scopes.Normalize(in.GetScopes()),
+		RedirectURIs:         slices.Compact(slices.Sorted(slices.Values(in.GetRedirectUris()))),
+		GrantTypes:           slices.Compact(slices.Sorted(slices.Values(in.GetGrantTypes()))),
+		ClientScopes:         scopes.Normalize(in.GetClientScopes()),
+		Audiences:            slices.Compact(slices.Sorted(slices.Values(in.GetAudiences()))),
+		RequireVerifiedEmail: in.GetRequireVerifiedEmail(),
+		AccessTokenTTL:       time.Duration(in.GetAccessTokenTtlSeconds()) * time.Second,
+		RefreshTokenTTL:      time.Duration(in.GetRefreshTokenTtlSeconds()) * time.Second,
+		OmitClaims:           slices.Compact(slices.Sorted(slices.Values(in.GetOmitClaims()))),
+	}, nil
+}
+
+func appError(err error, msg string) error {
+	switch {
+	case errors.Is(err, apps.ErrAppNotFound):
+		return status.Error(codes.NotFound, "app not found")
+	case errors.Is(err, apps.ErrAppExists):
+		return status.Error(codes.AlreadyExists, "app with this name already exists")
+	}
+
+	return status.Error(codes.Internal, msg)
+}
+
+// appToProto never includes secrets.
+func appToProto(app models.App) *ssov1.App {
+	return &ssov1.App{
+		Id:                     int32(app.ID),
+		Name:                   app.Name,
+		Scopes:                 app.Scopes,
+		RedirectUris:           app.RedirectURIs,
+		ClientScopes:           app.ClientScopes,
+		Audiences:              app.Audiences,
+		GrantTypes:             app.GrantTypes,
+		RequireVerifiedEmail:   app.RequireVerifiedEmail,
+		Confidential:           len(app.ClientSecretHash) > 0,
+		AccessTokenTtlSeconds:  int64(app.AccessTokenTTL / time.Second),
+		RefreshTokenTtlSeconds: int64(app.RefreshTokenTTL / time.Second),
+		OmitClaims:             app.OmitClaims,
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/domain/models"
+	"time"
+
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Audit interface {
+	Events(ctx context.Context, filter models.AuditFilter, beforeID int64, limit int) ([]models.AuditEvent, error)
+}
+
+// ListAuditEvents returns the audit events matching the filters of
+// the request, newest first. client_id filters by the app of the event,
+// admins of apps other than the admin app see only the events of their app.
+// since and until are Unix times, until is exclusive.
+func (s *adminAPI) ListAuditEvents(
+	ctx context.Context,
+	in *ssov1.ListAuditEventsRequest,
+) (*ssov1.ListAuditEventsResponse, error) {
+	if in.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if in.GetPageSize() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+	if in.GetSince() != 0 && in.GetUntil() != 0 && in.GetSince() >= in.GetUntil() {
+		return nil, status.Error(codes.InvalidArgument, "since must be before until")
+	}
+
+	beforeID, err := decodePageToken(in.GetPageToken())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+
+	pageSize := int(in.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	pageSize = min(pageSize, maxPageSize)
+
+	appID, clientID := int(in.GetAppId()), int(in.GetClientId())
+	if clientID == 0 && !s.api.isAdminApp(appID) {
+		clientID = appID
+	}
+
+	if _, err := s.api.requireAppPermission(ctx, appID, clientID, models.PermissionViewAudit); err != nil {
+		return nil, err
+	}
+
+	filter := models.AuditFilter{
+		Type:    in.GetType(),
+		Outcome: in.GetOutcome(),
+		UserID:  in.GetUserId(),
+		Email:   in.GetEmail(),
+		AppID:   clientID,
+		IP:      in.GetIp(),
+	}
+	if in.GetSince() != 0 {
+		filter.Since = time.Unix(in.GetSince(), 0)
+	}
+	if in.GetUntil() != 0 {
+		filter.Until = time.Unix(in.GetUntil(), 0)
+	}
+
+	events, err := s.audit.Events(ctx, filter, beforeID, pageSize+1)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to list audit events")
+	}
+
+	resp := &ssov1.ListAuditEventsResponse{}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		resp.NextPageToken = encodePageToken(events[len(events)-1].ID)
+	}
+
+	for _, event := range events {
+		resp.Events = append(resp.Events, auditEventToProto(event))
+	}
+
+	return resp, nil
+}
+
+func auditEventToProto(event models.AuditEvent) *ssov1.AuditEvent {
+	return &ssov1.AuditEvent{
+		Id:        event.ID,
+		CreatedAt: event.CreatedAt.Unix(),
+		Type:      event.Type,
+		Outcome:   event.Outcome,
+		UserId:    event.UserID,
+		Email:     event.Email,
+		AppId:     int32(event.AppID),
+		Ip:        event.IP,
+		UserAgent: event.UserAgent,
+		Action:    event.Action,
+		Target:    event.Target,
+		Reason:    event.Reason,
+	}
+}
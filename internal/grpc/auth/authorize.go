@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"sso/internal/domain/models"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// caller authenticates the caller by the access token passed
+// in the "authorization: Bearer <token>" metadata.
+func (s *serverAPI) caller(ctx context.Context) (models.TokenInfo, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return models.TokenInfo{}, status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return models.TokenInfo{}, status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	scheme, token, found := strings.Cut(values[0], " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return models.TokenInfo{}, status.Error(codes.Unauthenticated, "invalid authorization header")
+	}
+
+	info, err := s.auth.ValidateToken(ctx, token)
+	if err != nil {
+		return models.TokenInfo{}, status.Error(codes.Internal, "failed to validate token")
+	}
+	if !info.Active {
+		return models.TokenInfo{}, status.Error(codes.Unauthenticated, "invalid access token")
+	}
+
+	return info, nil
+}
+
+// callerOfApp authenticates the caller with an access token issued for the app,
+// so that roles in one app can't be used with a token of another.
+func (s *serverAPI) callerOfApp(ctx context.Context, appID int) (models.TokenInfo, error) {
+	info, err := s.caller(ctx)
+	if err != nil {
+		return models.TokenInfo{}, err
+	}
+	if info.AppID != appID {
+		return models.TokenInfo{}, status.Error(codes.PermissionDenied, "access token is issued for another app")
+	}
+
+	return info, nil
+}
+
+// requireAdmin authenticates the caller and checks they are an admin of the app.
+func (s *serverAPI) requireAdmin(ctx context.Context, appID int) (models.TokenInfo, error) {
+	info, err := s.callerOfApp(ctx, appID)
+	if err != nil {
+		return models.TokenInfo{}, err
+	}
+
+	isAdmin, err := s.rbac.IsAdmin(ctx, info.UserID, appID)
+	if err != nil {
+		return models.TokenInfo{}, status.Error(codes.Internal, "failed to check permissions")
+	}
+	if !isAdmin {
+		return models.TokenInfo{}, status.Error(codes.PermissionDenied, "admin role is required")
+	}
+	if s.requireAdminMFA && !info.MFA {
+		return models.TokenInfo{}, status.Error(codes.PermissionDenied, "multi-factor authentication is required")
+	}
+
+	return info, nil
+}
+
+// requirePermission authenticates the caller and checks they are granted
+// the permission in the app. Admins of the app are granted every permission.
+func (s *serverAPI) requirePermission(ctx context.Context, appID int, permission string) (models.TokenInfo, error) {
+	info, err := s.callerOfApp(ctx, appID)
+	if err != nil {
+		return models.TokenInfo{}, err
+	}
+
+	allowed, err := s.rbac.HasPermission(ctx, info.UserID, appID, permission)
+	if err != nil {
+		return models.TokenInfo{}, status.Error(codes.Internal, "failed to check permissions")
+	}
+	if !allowed {
+		return models.TokenInfo{}, status.Error(codes.PermissionDenied, permission+" permission is required")
+	}
+	if s.requireAdminMFA && !info.MFA {
+		return models.TokenInfo{}, status.Error(codes.PermissionDenied, "multi-factor authentication is required")
+	}
+
+	return info, nil
+}
+
+// requireGlobalPermission is requirePermission for actions reaching beyond
+// the app, such as managing users, who are shared by all apps.
+// Only the admin app is granted them.
+func (s *serverAPI) requireGlobalPermission(ctx context.Context, appID int, permission string) (models.TokenInfo, error) {
+	info, err := s.requirePermission(ctx, appID, permission)
+	if err != nil {
+		return models.TokenInfo{}, err
+	}
+	if !s.isAdminApp(appID) {
+		return models.TokenInfo{}, status.Error(codes.PermissionDenied, permission+" permission in the admin app is required")
+	}
+
+	return info, nil
+}
+
+// requireAppPermission is requirePermission for actions on the target app.
+// Admins manage their own app, the admin app manages every app.
+func (s *serverAPI) requireAppPermission(ctx context.Context, appID int, targetID int, permission string) (models.TokenInfo, error) {
+	info, err := s.requirePermission(ctx, appID, permission)
+	if err != nil {
+		return models.TokenInfo{}, err
+	}
+	if targetID != appID && !s.isAdminApp(appID) {
+		return models.TokenInfo{}, status.Error(codes.PermissionDenied, permission+" permission in the target app or the admin app is required")
+	}
+
+	return info, nil
+}
+
+// isAdminApp reports whether admins of the app manage all users and apps.
+func (s *serverAPI) isAdminApp(appID int) bool {
+	return s.adminAppID != 0 && appID == s.adminAppID
+}
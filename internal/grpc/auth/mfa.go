@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sso/internal/services/auth"
+	"sso/internal/services/mfa"
+	"sso/internal/services/throttle"
+
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VerifyMFA completes a login that returned mfa_required.
+func (s *serverAPI) VerifyMFA(
+	ctx context.Context,
+	in *ssov1.VerifyMFARequest,
+) (*ssov1.VerifyMFAResponse, error) {
+	if in.GetMfaToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "mfa_token is required")
+	}
+	if in.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "code is required")
+	}
+	if in.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	tokens, err := s.auth.VerifyMFA(ctx, in.GetMfaToken(), in.GetCode(), int(in.GetAppId()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidMFAToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid mfa token")
+		}
+		if errors.Is(err, auth.ErrInvalidMFACode) {
+			return nil, status.Error(codes.Unauthenticated, "invalid code")
+		}
+		if errors.Is(err, auth.ErrUserDisabled) {
+			return nil, status.Error(codes.PermissionDenied, "user disabled")
+		}
+		if errors.Is(err, throttle.ErrThrottled) {
+			return nil, throttledError(ctx, err)
+		}
+
+		return nil, status.Error(codes.Internal, "failed to verify mfa")
+	}
+
+	return &ssov1.VerifyMFAResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
+
+// EnrollTOTP starts TOTP enrollment for the caller.
+func (s *serverAPI) EnrollTOTP(
+	ctx context.Context,
+	in *ssov1.EnrollTOTPRequest,
+) (*ssov1.EnrollTOTPResponse, error) {
+	info, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.UserID == 0 {
+		return nil, status.Error(codes.PermissionDenied, "user token is required")
+	}
+
+	secret, uri, err := s.mfa.Enroll(ctx, info.UserID)
+	if err != nil {
+		if errors.Is(err, mfa.ErrAlreadyEnabled) {
+			return nil, status.Error(codes.FailedPrecondition, "mfa is already enabled")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to enroll totp")
+	}
+
+	return &ssov1.EnrollTOTPResponse{Secret: secret, Uri: uri}, nil
+}
+
+// ConfirmTOTP enables MFA for the caller and returns recovery codes.
+func (s *serverAPI) ConfirmTOTP(
+	ctx context.Context,
+	in *ssov1.ConfirmTOTPRequest,
+) (*ssov1.ConfirmTOTPResponse, error) {
+	if in.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "code is required")
+	}
+
+	info, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := s.mfa.Confirm(ctx, info.UserID, in.GetCode())
+	if err != nil {
+		return nil, mfaError(err, "failed to confirm totp")
+	}
+
+	return &ssov1.ConfirmTOTPResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// DisableTOTP turns MFA off for the caller.
+func (s *serverAPI) DisableTOTP(
+	ctx context.Context,
+	in *ssov1.DisableTOTPRequest,
+) (*ssov1.DisableTOTPResponse, error) {
+	if in.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, "code is required")
+	}
+
+	info, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.mfa.Disable(ctx, info.UserID, in.GetCode()); err != nil {
+		return nil, mfaError(err, "failed to disable totp")
+	}
+
+	return &ssov1.DisableTOTPResponse{}, nil
+}
+
+func mfaError(err error, msg string) error {
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode):
+		return status.Error(codes.InvalidArgument, "invalid code")
+	case errors.Is(err, mfa.ErrNotEnrolled):
+		return status.Error(codes.FailedPrecondition, "mfa is not enabled")
+	case errors.Is(err, mfa.ErrAlreadyEnabled):
+		return status.Error(codes.FailedPrecondition, "mfa is already enabled")
+	default:
+		return status.Error(codes.Internal, msg)
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"sso/internal/services/passkey"
+
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BeginPasskeyRegistration returns options for navigator.credentials.create.
+func (s *serverAPI) BeginPasskeyRegistration(
+	ctx context.Context,
+	in *ssov1.BeginPasskeyRegistrationRequest,
+) (*ssov1.BeginPasskeyRegistrationResponse, error) {
+	info, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.UserID == 0 {
+		return nil, status.Error(codes.PermissionDenied, "user token is required")
+	}
+
+	options, err := s.passkeys.BeginRegistration(ctx, info.UserID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to begin passkey registration")
+	}
+
+	return &ssov1.BeginPasskeyRegistrationResponse{Options: string(options)}, nil
+}
+
+// FinishPasskeyRegistration stores the passkey created by the caller's authenticator.
+func (s *serverAPI) FinishPasskeyRegistration(
+	ctx context.Context,
+	in *ssov1.FinishPasskeyRegistrationRequest,
+) (*ssov1.FinishPasskeyRegistrationResponse, error) {
+	if len(in.GetClientDataJson()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "client_data_json is required")
+	}
+	if len(in.GetAttestationObject()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "attestation_object is required")
+	}
+
+	info, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.UserID == 0 {
+		return nil, status.Error(codes.PermissionDenied, "user token is required")
+	}
+
+	created, err := s.passkeys.FinishRegistration(
+		ctx,
+		info.UserID,
+		in.GetClientDataJson(),
+		in.GetAttestationObject(),
+		in.GetTransports(),
+	)
+	if err != nil {
+		if errors.Is(err, passkey.ErrInvalidResponse) {
+			return nil, status.Error(codes.InvalidArgument, "invalid passkey response")
+		}
+		if errors.Is(err, passkey.ErrAlreadyRegistered) {
+			return nil, status.Error(codes.AlreadyExists, "passkey already registered")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to register passkey")
+	}
+
+	return &ssov1.FinishPasskeyRegistrationResponse{CredentialId: created.ID}, nil
+}
+
+// BeginPasskeyLogin returns options for navigator.credentials.get.
+func (s *serverAPI) BeginPasskeyLogin(
+	ctx context.Context,
+	in *ssov1.BeginPasskeyLoginRequest,
+) (*ssov1.BeginPasskeyLoginResponse, error) {
+	options, err := s.passkeys.BeginLogin(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to begin passkey login")
+	}
+
+	return &ssov1.BeginPasskeyLoginResponse{Options: string(options)}, nil
+}
+
+// FinishPasskeyLogin logs the user in with the assertion of their authenticator.
+func (s *serverAPI) FinishPasskeyLogin(
+	ctx context.Context,
+	in *ssov1.FinishPasskeyLoginRequest,
+) (*ssov1.FinishPasskeyLoginResponse, error) {
+	if len(in.GetCredentialId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "credential_id is required")
+	}
+	if len(in.GetClientDataJson()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "client_data_json is required")
+	}
+	if len(in.GetAuthenticatorData()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "authenticator_data is required")
+	}
+	if len(in.GetSignature()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "signature is required")
+	}
+	if in.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	assertion := models.PasskeyAssertion{
+		CredentialID:      in.GetCredentialId(),
+		ClientDataJSON:    in.GetClientDataJson(),
+		AuthenticatorData: in.GetAuthenticatorData(),
+		Signature:         in.GetSignature(),
+		UserHandle:        in.GetUserHandle(),
+	}
+
+	tokens, err := s.auth.LoginWithPasskey(ctx, assertion, int(in.GetAppId()), in.GetScopes())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPasskey) {
+			return nil, status.Error(codes.Unauthenticated, "invalid passkey")
+		}
+		if errors.Is(err, auth.ErrScopeNotAllowed) {
+			return nil, status.Error(codes.PermissionDenied, "scope not allowed")
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		if errors.Is(err, auth.ErrUserDisabled) {
+			return nil, status.Error(codes.PermissionDenied, "user disabled")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to login")
+	}
+
+	if tokens.MFAToken != "" {
+		return &ssov1.FinishPasskeyLoginResponse{MfaRequired: true, MfaToken: tokens.MFAToken}, nil
+	}
+
+	return &ssov1.FinishPasskeyLoginResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
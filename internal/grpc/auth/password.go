@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"sso/internal/lib/password"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// passwordPolicyError converts a rejected password to InvalidArgument with
+// a BadRequest detail per broken rule, so clients can show them next to the field.
+func passwordPolicyError(policyErr *password.PolicyError, field string) error {
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range policyErr.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: v.Description,
+			Reason:      v.Reason,
+		})
+	}
+
+	st, detailsErr := status.New(codes.InvalidArgument, policyErr.Error()).WithDetails(badRequest)
+	if detailsErr != nil {
+		return status.Error(codes.InvalidArgument, policyErr.Error())
+	}
+
+	return st.Err()
+}
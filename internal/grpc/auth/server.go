@@ -2,10 +2,16 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/password"
 	"sso/internal/services/auth"
+	"sso/internal/services/throttle"
 	"sso/internal/storage"
+	"time"
 
 	ssov1 "github.com/iluha481/protos/gen/go/sso"
 
@@ -18,6 +24,18 @@ import (
 type serverAPI struct {
 	ssov1.UnimplementedAuthServer
 	auth Auth
+	keys Keys
+	rbac RBAC
+	mfa  MFA
+
+	passkeys     Passkeys
+	verification Verification
+	throttle     Throttle
+
+	// App whose admins manage all users and apps, 0 if there is none
+	adminAppID int
+	// Admin RPCs are refused to tokens issued without a second factor
+	requireAdminMFA bool
 }
 type Auth interface {
 	Login(
@@ -25,21 +43,150 @@ type Auth interface {
 		email string,
 		password string,
 		appID int,
-	) (token string, refresh_token string, err error)
+		scopes []string,
+	) (models.Tokens, error)
+	VerifyMFA(
+		ctx context.Context,
+		mfaToken string,
+		code string,
+		appID int,
+	) (models.Tokens, error)
 	RegisterNewUser(
 		ctx context.Context,
 		email string,
 		password string,
+		appID int,
 	) (userID int64, err error)
 	RefreshToken(
 		ctx context.Context,
 		refresh_token string,
 		appID int,
+		scopes []string,
 	) (token string, new_refresh_token string, err error)
+	Logout(
+		ctx context.Context,
+		refresh_token string,
+	) error
+	LogoutAll(
+		ctx context.Context,
+		userID int64,
+	) error
+	ValidateToken(
+		ctx context.Context,
+		token string,
+	) (models.TokenInfo, error)
+	RevokeToken(
+		ctx context.Context,
+		token string,
+	) error
+	LoginWithPasskey(
+		ctx context.Context,
+		assertion models.PasskeyAssertion,
+		appID int,
+		scopes []string,
+	) (models.Tokens, error)
+	RequestPasswordReset(
+		ctx context.Context,
+		email string,
+	) error
+	ResetPassword(
+		ctx context.Context,
+		token string,
+		newPassword string,
+		appID int,
+	) error
+	ChangePassword(
+		ctx context.Context,
+		userID int64,
+		appID int,
+		sessionID string,
+		currentPassword string,
+		newPassword string,
+	) error
+	ChangeEmail(
+		ctx context.Context,
+		userID int64,
+		currentPassword string,
+		newEmail string,
+	) error
+	ClientCredentials(
+		ctx context.Context,
+		clientID int,
+		clientSecret string,
+		scopes []string,
+		audience string,
+	) (models.Tokens, error)
 }
 
-func Register(gRPCServer *grpc.Server, auth Auth) {
-	ssov1.RegisterAuthServer(gRPCServer, &serverAPI{auth: auth})
+type Keys interface {
+	JWKS(ctx context.Context, appID int) (jwt.JWKS, error)
+}
+
+type RBAC interface {
+	AssignRole(ctx context.Context, userID int64, appID int, role string) error
+	RevokeRole(ctx context.Context, userID int64, appID int, role string) error
+	IsAdmin(ctx context.Context, userID int64, appID int) (bool, error)
+	HasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error)
+	UserRoles(ctx context.Context, userID int64, appID int) ([]string, error)
+	SetRoles(ctx context.Context, userID int64, appID int, roles []string) error
+}
+
+type MFA interface {
+	Enroll(ctx context.Context, userID int64) (secret string, uri string, err error)
+	Confirm(ctx context.Context, userID int64, code string) (recoveryCodes []string, err error)
+	Disable(ctx context.Context, userID int64, code string) error
+}
+
+type Passkeys interface {
+	BeginRegistration(ctx context.Context, userID int64) (options []byte, err error)
+	FinishRegistration(
+		ctx context.Context,
+		userID int64,
+		clientDataJSON []byte,
+		attestationObject []byte,
+		transports []string,
+	) (models.Passkey, error)
+	BeginLogin(ctx context.Context) (options []byte, err error)
+}
+
+type Verification interface {
+	Verify(ctx context.Context, token string) (userID int64, err error)
+	Resend(ctx context.Context, email string) error
+}
+
+type Throttle interface {
+	Unlock(ctx context.Context, email string) error
+}
+
+func Register(
+	gRPCServer *grpc.Server,
+	auth Auth,
+	keys Keys,
+	rbac RBAC,
+	mfa MFA,
+	passkeys Passkeys,
+	verification Verification,
+	throttle Throttle,
+	users Users,
+	apps Apps,
+	audit Audit,
+	adminAppID int,
+	requireAdminMFA bool,
+) {
+	api := &serverAPI{
+		auth:            auth,
+		keys:            keys,
+		rbac:            rbac,
+		mfa:             mfa,
+		passkeys:        passkeys,
+		verification:    verification,
+		throttle:        throttle,
+		adminAppID:      adminAppID,
+		requireAdminMFA: requireAdminMFA,
+	}
+
+	ssov1.RegisterAuthServer(gRPCServer, api)
+	ssov1.RegisterAdminServer(gRPCServer, &adminAPI{api: api, users: users, apps: apps, audit: audit})
 }
 
 func (s *serverAPI) Login(
@@ -58,17 +205,33 @@ func (s *serverAPI) Login(
 		return nil, status.Error(codes.InvalidArgument, "app_id is required")
 	}
 
-	token, refresh_token, err := s.auth.Login(ctx, in.GetEmail(), in.GetPassword(), int(in.GetAppId()))
+	tokens, err := s.auth.Login(ctx, in.GetEmail(), in.GetPassword(), int(in.GetAppId()), in.GetScopes())
 	if err != nil {
 		// Ошибку auth.ErrInvalidCredentials мы создадим ниже
 		if errors.Is(err, auth.ErrInvalidCredentials) {
 			return nil, status.Error(codes.InvalidArgument, "invalid email or password")
 		}
+		if errors.Is(err, auth.ErrScopeNotAllowed) {
+			return nil, status.Error(codes.PermissionDenied, "scope not allowed")
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		if errors.Is(err, auth.ErrUserDisabled) {
+			return nil, status.Error(codes.PermissionDenied, "user disabled")
+		}
+		if errors.Is(err, throttle.ErrThrottled) {
+			return nil, throttledError(ctx, err)
+		}
 		fmt.Print(err)
 		return nil, status.Error(codes.Internal, "failed to login")
 	}
 
-	return &ssov1.LoginResponse{Token: token, RefreshToken: refresh_token}, nil
+	if tokens.MFAToken != "" {
+		return &ssov1.LoginResponse{MfaRequired: true, MfaToken: tokens.MFAToken}, nil
+	}
+
+	return &ssov1.LoginResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
 }
 func (s *serverAPI) Register(
 	ctx context.Context,
@@ -82,12 +245,16 @@ func (s *serverAPI) Register(
 		return nil, status.Error(codes.InvalidArgument, "password is required")
 	}
 
-	uid, err := s.auth.RegisterNewUser(ctx, in.GetEmail(), in.GetPassword())
+	uid, err := s.auth.RegisterNewUser(ctx, in.GetEmail(), in.GetPassword(), int(in.GetAppId()))
 	if err != nil {
 		// Ошибку storage.ErrUserExists мы создадим ниже
 		if errors.Is(err, storage.ErrUserExists) {
 			return nil, status.Error(codes.AlreadyExists, "user already exists")
 		}
+		var policyErr *password.PolicyError
+		if errors.As(err, &policyErr) {
+			return nil, passwordPolicyError(policyErr, "password")
+		}
 
 		return nil, status.Error(codes.Internal, "failed to register user")
 	}
@@ -105,9 +272,276 @@ func (s *serverAPI) RefreshToken(
 	if in.AppId == 0 {
 		return nil, status.Error(codes.InvalidArgument, "app_id is required")
 	}
-	token, refresh_token, err := s.auth.RefreshToken(ctx, in.RefreshToken, int(in.AppId))
+	token, refresh_token, err := s.auth.RefreshToken(ctx, in.RefreshToken, int(in.AppId), in.GetScopes())
 	if err != nil {
+		if errors.Is(err, auth.ErrScopeNotAllowed) {
+			return nil, status.Error(codes.PermissionDenied, "scope not allowed")
+		}
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			return nil, status.Error(codes.Unauthenticated, "refresh token reuse detected")
+		}
+		if errors.Is(err, auth.ErrInvalidRefreshToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+		if errors.Is(err, auth.ErrUnauthorizedClient) {
+			return nil, status.Error(codes.PermissionDenied, "refresh token grant not allowed for app")
+		}
+
 		return nil, status.Error(codes.InvalidArgument, "failed to refresh token")
 	}
 	return &ssov1.RefreshResponse{Token: token, RefreshToken: refresh_token}, nil
 }
+
+// ClientCredentials issues an access token to an app for calls on its own behalf.
+func (s *serverAPI) ClientCredentials(
+	ctx context.Context,
+	in *ssov1.ClientCredentialsRequest,
+) (*ssov1.ClientCredentialsResponse, error) {
+	if in.GetClientId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	if in.GetClientSecret() == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_secret is required")
+	}
+
+	tokens, err := s.auth.ClientCredentials(
+		ctx,
+		int(in.GetClientId()),
+		in.GetClientSecret(),
+		in.GetScopes(),
+		in.GetAudience(),
+	)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) {
+			return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+		}
+		if errors.Is(err, auth.ErrScopeNotAllowed) {
+			return nil, status.Error(codes.PermissionDenied, "scope not allowed")
+		}
+		if errors.Is(err, auth.ErrAudienceNotAllowed) {
+			return nil, status.Error(codes.PermissionDenied, "audience not allowed")
+		}
+		if errors.Is(err, auth.ErrUnauthorizedClient) {
+			return nil, status.Error(codes.PermissionDenied, "client credentials grant not allowed for app")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to issue token")
+	}
+
+	return &ssov1.ClientCredentialsResponse{
+		AccessToken: tokens.AccessToken,
+		ExpiresIn:   int64(time.Until(tokens.ExpiresAt).Seconds()),
+		Scopes:      tokens.Scopes,
+	}, nil
+}
+
+func (s *serverAPI) Logout(
+	ctx context.Context,
+	in *ssov1.LogoutRequest,
+) (*ssov1.LogoutResponse, error) {
+	if in.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh_token is required")
+	}
+
+	if err := s.auth.Logout(ctx, in.GetRefreshToken()); err != nil {
+		if errors.Is(err, auth.ErrInvalidRefreshToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to logout")
+	}
+
+	return &ssov1.LogoutResponse{}, nil
+}
+
+// LogoutAll ends all sessions of the user. Users log out themselves,
+// others need models.PermissionManageUsers in the admin app.
+func (s *serverAPI) LogoutAll(
+	ctx context.Context,
+	in *ssov1.LogoutAllRequest,
+) (*ssov1.LogoutAllResponse, error) {
+	if in.GetUserId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	info, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.UserID != in.GetUserId() {
+		if _, err := s.requireGlobalPermission(ctx, info.AppID, models.PermissionManageUsers); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.auth.LogoutAll(ctx, in.GetUserId()); err != nil {
+		return nil, status.Error(codes.Internal, "failed to logout")
+	}
+
+	return &ssov1.LogoutAllResponse{}, nil
+}
+
+// GetJWKS returns public signing keys as a JSON Web Key Set document.
+// If app_id is not set, keys of all apps are returned.
+func (s *serverAPI) GetJWKS(
+	ctx context.Context,
+	in *ssov1.GetJWKSRequest,
+) (*ssov1.GetJWKSResponse, error) {
+	set, err := s.keys.JWKS(ctx, int(in.GetAppId()))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get jwks")
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get jwks")
+	}
+
+	return &ssov1.GetJWKSResponse{Jwks: string(data)}, nil
+}
+
+// ValidateToken introspects an access token for resource servers.
+func (s *serverAPI) ValidateToken(
+	ctx context.Context,
+	in *ssov1.ValidateTokenRequest,
+) (*ssov1.ValidateTokenResponse, error) {
+	if in.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	info, err := s.auth.ValidateToken(ctx, in.GetToken())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to validate token")
+	}
+
+	if !info.Active {
+		return &ssov1.ValidateTokenResponse{Active: false, Revoked: info.Revoked}, nil
+	}
+
+	return &ssov1.ValidateTokenResponse{
+		Active:    true,
+		UserId:    info.UserID,
+		Email:     info.Email,
+		AppId:     int32(info.AppID),
+		Scopes:    info.Scopes,
+		ExpiresAt: info.ExpiresAt.Unix(),
+	}, nil
+}
+
+func (s *serverAPI) RevokeToken(
+	ctx context.Context,
+	in *ssov1.RevokeTokenRequest,
+) (*ssov1.RevokeTokenResponse, error) {
+	if in.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	if err := s.auth.RevokeToken(ctx, in.GetToken()); err != nil {
+		return nil, status.Error(codes.Internal, "failed to revoke token")
+	}
+
+	return &ssov1.RevokeTokenResponse{}, nil
+}
+
+// AssignRole grants a role to the user. The caller must be an admin of the app.
+func (s *serverAPI) AssignRole(
+	ctx context.Context,
+	in *ssov1.AssignRoleRequest,
+) (*ssov1.AssignRoleResponse, error) {
+	if err := validateRoleRequest(in.GetUserId(), in.GetAppId(), in.GetRole()); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.requireAdmin(ctx, int(in.GetAppId())); err != nil {
+		return nil, err
+	}
+
+	if err := s.rbac.AssignRole(ctx, in.GetUserId(), int(in.GetAppId()), in.GetRole()); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to assign role")
+	}
+
+	return &ssov1.AssignRoleResponse{}, nil
+}
+
+// RevokeRole takes a role from the user. The caller must be an admin of the app.
+func (s *serverAPI) RevokeRole(
+	ctx context.Context,
+	in *ssov1.RevokeRoleRequest,
+) (*ssov1.RevokeRoleResponse, error) {
+	if err := validateRoleRequest(in.GetUserId(), in.GetAppId(), in.GetRole()); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.requireAdmin(ctx, int(in.GetAppId())); err != nil {
+		return nil, err
+	}
+
+	if err := s.rbac.RevokeRole(ctx, in.GetUserId(), int(in.GetAppId()), in.GetRole()); err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to revoke role")
+	}
+
+	return &ssov1.RevokeRoleResponse{}, nil
+}
+
+func (s *serverAPI) IsAdmin(
+	ctx context.Context,
+	in *ssov1.IsAdminRequest,
+) (*ssov1.IsAdminResponse, error) {
+	if in.GetUserId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if in.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	isAdmin, err := s.rbac.IsAdmin(ctx, in.GetUserId(), int(in.GetAppId()))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to check admin")
+	}
+
+	return &ssov1.IsAdminResponse{IsAdmin: isAdmin}, nil
+}
+
+func (s *serverAPI) HasPermission(
+	ctx context.Context,
+	in *ssov1.HasPermissionRequest,
+) (*ssov1.HasPermissionResponse, error) {
+	if in.GetUserId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if in.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if in.GetPermission() == "" {
+		return nil, status.Error(codes.InvalidArgument, "permission is required")
+	}
+
+	allowed, err := s.rbac.HasPermission(ctx, in.GetUserId(), int(in.GetAppId()), in.GetPermission())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to check permission")
+	}
+
+	return &ssov1.HasPermissionResponse{Allowed: allowed}, nil
+}
+
+func validateRoleRequest(userID int64, appID int32, role string) error {
+	if userID == 0 {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if appID == 0 {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if role == "" {
+		return status.Error(codes.InvalidArgument, "role is required")
+	}
+
+	return nil
+}
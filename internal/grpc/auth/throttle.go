@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/mail"
+	"sso/internal/services/throttle"
+	"strconv"
+
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// throttledError returns ResourceExhausted and tells the client
+// when to retry in the "retry-after" header, in seconds.
+func throttledError(ctx context.Context, err error) error {
+	var retry *throttle.RetryError
+	if errors.As(err, &retry) {
+		seconds := int64(math.Ceil(retry.RetryAfter.Seconds()))
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.FormatInt(seconds, 10)))
+	}
+
+	return status.Error(codes.ResourceExhausted, "too many failed login attempts, try again later")
+}
+
+// UnlockAccount lifts the lockout of an account after failed logins.
+func (s *serverAPI) UnlockAccount(
+	ctx context.Context,
+	in *ssov1.UnlockAccountRequest,
+) (*ssov1.UnlockAccountResponse, error) {
+	if in.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+	if _, err := mail.ParseAddress(in.GetEmail()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+	if in.GetAppId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	if _, err := s.requireAdmin(ctx, int(in.GetAppId())); err != nil {
+		return nil, err
+	}
+
+	if err := s.throttle.Unlock(ctx, in.GetEmail()); err != nil {
+		return nil, status.Error(codes.Internal, "failed to unlock account")
+	}
+
+	return &ssov1.UnlockAccountResponse{}, nil
+}
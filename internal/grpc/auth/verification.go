@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"sso/internal/services/verification"
+
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VerifyEmail confirms the email with the token from the verification link.
+// For links sent by ChangeEmail it also switches the user to the new email.
+func (s *serverAPI) VerifyEmail(
+	ctx context.Context,
+	in *ssov1.VerifyEmailRequest,
+) (*ssov1.VerifyEmailResponse, error) {
+	if in.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	userID, err := s.verification.Verify(ctx, in.GetToken())
+	if err != nil {
+		if errors.Is(err, verification.ErrInvalidToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired token")
+		}
+		if errors.Is(err, verification.ErrEmailTaken) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
+		if errors.Is(err, verification.ErrDisabled) {
+			return nil, status.Error(codes.FailedPrecondition, "email verification is disabled")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to verify email")
+	}
+
+	return &ssov1.VerifyEmailResponse{UserId: userID}, nil
+}
+
+// ResendVerification mails a new verification link. It succeeds
+// for unknown emails too, so it can't be used to find registered users.
+func (s *serverAPI) ResendVerification(
+	ctx context.Context,
+	in *ssov1.ResendVerificationRequest,
+) (*ssov1.ResendVerificationResponse, error) {
+	if in.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+	if _, err := mail.ParseAddress(in.GetEmail()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+
+	if err := s.verification.Resend(ctx, in.GetEmail()); err != nil {
+		if errors.Is(err, verification.ErrDisabled) {
+			return nil, status.Error(codes.FailedPrecondition, "email verification is disabled")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to resend verification")
+	}
+
+	return &ssov1.ResendVerificationResponse{}, nil
+}
@@ -0,0 +1,413 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/peeraddr"
+	"sso/internal/lib/scopes"
+	"sso/internal/lib/useragent"
+	"sso/internal/services/auth"
+	"sso/internal/services/throttle"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Auth interface {
+	ValidateClient(ctx context.Context, clientID int, redirectURI string) (models.App, error)
+	Authorize(
+		ctx context.Context,
+		email string,
+		password string,
+		clientID int,
+		redirectURI string,
+		requestedScopes []string,
+		codeChallenge string,
+		nonce string,
+		otp string,
+	) (string, error)
+	ExchangeCode(
+		ctx context.Context,
+		code string,
+		clientID int,
+		clientSecret string,
+		redirectURI string,
+		codeVerifier string,
+	) (models.Tokens, error)
+	RefreshGrant(
+		ctx context.Context,
+		refreshToken string,
+		clientID int,
+		clientSecret string,
+		requestedScopes []string,
+	) (models.Tokens, error)
+	ClientCredentials(
+		ctx context.Context,
+		clientID int,
+		clientSecret string,
+		requestedScopes []string,
+		audience string,
+	) (models.Tokens, error)
+	UserInfo(ctx context.Context, token string) (models.UserInfo, error)
+}
+
+type handler struct {
+	log  *slog.Logger
+	auth Auth
+}
+
+func Register(mux *http.ServeMux, log *slog.Logger, auth Auth) {
+	h := &handler{log: log, auth: auth}
+
+	mux.HandleFunc("GET /authorize", h.authorizeForm)
+	mux.HandleFunc("POST /authorize", h.authorize)
+	mux.HandleFunc("POST /token", h.token)
+	mux.HandleFunc("GET /userinfo", h.userinfo)
+	mux.HandleFunc("POST /userinfo", h.userinfo)
+}
+
+// authorizeRequest holds parameters of the authorization request (RFC 6749, 4.1.1).
+// They are carried through the login form as hidden fields.
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	CSRFToken           string // see newCSRFToken
+	MFARequired         bool
+	Error               string
+}
+
+func parseAuthorizeRequest(v url.Values) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        v.Get("response_type"),
+		ClientID:            v.Get("client_id"),
+		RedirectURI:         v.Get("redirect_uri"),
+		Scope:               v.Get("scope"),
+		State:               v.Get("state"),
+		CodeChallenge:       v.Get("code_challenge"),
+		CodeChallengeMethod: v.Get("code_challenge_method"),
+		Nonce:               v.Get("nonce"),
+		CSRFToken:           v.Get("csrf_token"),
+	}
+}
+
+// validate checks the client and redirect URI, then the rest of the request.
+// Until the redirect URI is checked errors must be shown to the user
+// instead of redirecting.
+func (h *handler) validate(w http.ResponseWriter, r *http.Request, req authorizeRequest) (int, bool) {
+	clientID, err := strconv.Atoi(req.ClientID)
+	if err != nil {
+		http.Error(w, "invalid client_id", http.StatusBadRequest)
+		return 0, false
+	}
+
+	if _, err := h.auth.ValidateClient(r.Context(), clientID, req.RedirectURI); err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) || errors.Is(err, auth.ErrInvalidRedirectURI) {
+			http.Error(w, "invalid client_id or redirect_uri", http.StatusBadRequest)
+			return 0, false
+		}
+		if errors.Is(err, auth.ErrUnauthorizedClient) {
+			http.Error(w, "client may not use the authorization code grant", http.StatusBadRequest)
+			return 0, false
+		}
+
+		h.log.Error("failed to validate client", sl.Err(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return 0, false
+	}
+
+	if req.ResponseType != "code" {
+		redirectError(w, r, req, "unsupported_response_type")
+		return 0, false
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		redirectError(w, r, req, "invalid_request")
+		return 0, false
+	}
+
+	return clientID, true
+}
+
+func (h *handler) authorizeForm(w http.ResponseWriter, r *http.Request) {
+	req := parseAuthorizeRequest(r.URL.Query())
+
+	if _, ok := h.validate(w, r, req); !ok {
+		return
+	}
+
+	req.CSRFToken = newCSRFToken(w, r, req)
+	h.renderLogin(w, req)
+}
+
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	req := parseAuthorizeRequest(r.PostForm)
+
+	clientID, ok := h.validate(w, r, req)
+	if !ok {
+		return
+	}
+
+	// The form must have been rendered by GET /authorize for this request
+	if !validCSRFToken(r, req) {
+		req.CSRFToken = newCSRFToken(w, r, req)
+		req.Error = "The sign in form has expired, please try again"
+		w.WriteHeader(http.StatusForbidden)
+		h.renderLogin(w, req)
+		return
+	}
+
+	code, err := h.auth.Authorize(
+		useragent.With(peeraddr.With(r.Context(), r.RemoteAddr), r.UserAgent()),
+		r.PostForm.Get("email"),
+		r.PostForm.Get("password"),
+		clientID,
+		req.RedirectURI,
+		scopes.Parse(req.Scope),
+		req.CodeChallenge,
+		req.Nonce,
+		r.PostForm.Get("otp"),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			req.Error = "Invalid email or password"
+			w.WriteHeader(http.StatusUnauthorized)
+			h.renderLogin(w, req)
+		case errors.Is(err, auth.ErrMFARequired):
+			req.MFARequired = true
+			w.WriteHeader(http.StatusUnauthorized)
+			h.renderLogin(w, req)
+		case errors.Is(err, auth.ErrInvalidMFACode):
+			req.MFARequired = true
+			req.Error = "Invalid authentication code"
+			w.WriteHeader(http.StatusUnauthorized)
+			h.renderLogin(w, req)
+		case errors.Is(err, throttle.ErrThrottled):
+			var retry *throttle.RetryError
+			if errors.As(err, &retry) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+			}
+			req.Error = "Too many failed attempts, try again later"
+			w.WriteHeader(http.StatusTooManyRequests)
+			h.renderLogin(w, req)
+		case errors.Is(err, auth.ErrEmailNotVerified):
+			req.Error = "Confirm your email first, we have sent you a link"
+			w.WriteHeader(http.StatusForbidden)
+			h.renderLogin(w, req)
+		case errors.Is(err, auth.ErrUserDisabled):
+			req.Error = "Your account has been disabled"
+			w.WriteHeader(http.StatusForbidden)
+			h.renderLogin(w, req)
+		case errors.Is(err, auth.ErrScopeNotAllowed):
+			redirectError(w, r, req, "invalid_scope")
+		case errors.Is(err, auth.ErrInvalidPKCE):
+			redirectError(w, r, req, "invalid_request")
+		default:
+			h.log.Error("failed to authorize", sl.Err(err))
+			redirectError(w, r, req, "server_error")
+		}
+		return
+	}
+
+	redirect(w, r, req, url.Values{"code": {code}})
+}
+
+func (h *handler) renderLogin(w http.ResponseWriter, req authorizeRequest) {
+	// Login page must not be framed by other sites
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := loginPage.Execute(w, req); err != nil {
+		h.log.Error("failed to render login page", sl.Err(err))
+	}
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func redirectError(w http.ResponseWriter, r *http.Request, req authorizeRequest, code string) {
+	redirect(w, r, req, url.Values{"error": {code}})
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// token is the token endpoint (RFC 6749, 3.2). Clients authenticate
+// with HTTP Basic or client_id/client_secret form parameters.
+func (h *handler) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	rawID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		// Credentials in the header are form-urlencoded (RFC 6749, 2.3.1)
+		rawID, _ = url.QueryUnescape(rawID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		rawID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := strconv.Atoi(rawID)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	ctx := r.Context()
+	requested := scopes.Parse(r.PostForm.Get("scope"))
+
+	var tokens models.Tokens
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		tokens, err = h.auth.ExchangeCode(
+			ctx,
+			r.PostForm.Get("code"),
+			clientID,
+			clientSecret,
+			r.PostForm.Get("redirect_uri"),
+			r.PostForm.Get("code_verifier"),
+		)
+	case "refresh_token":
+		tokens, err = h.auth.RefreshGrant(ctx, r.PostForm.Get("refresh_token"), clientID, clientSecret, requested)
+	case "client_credentials":
+		tokens, err = h.auth.ClientCredentials(ctx, clientID, clientSecret, requested, r.PostForm.Get("audience"))
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			writeError(w, http.StatusUnauthorized, "invalid_client")
+		case errors.Is(err, auth.ErrInvalidGrant),
+			errors.Is(err, auth.ErrInvalidRefreshToken),
+			errors.Is(err, auth.ErrRefreshTokenReused),
+			errors.Is(err, auth.ErrUserDisabled):
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+		case errors.Is(err, auth.ErrUnauthorizedClient):
+			writeError(w, http.StatusBadRequest, "unauthorized_client")
+		case errors.Is(err, auth.ErrScopeNotAllowed):
+			writeError(w, http.StatusBadRequest, "invalid_scope")
+		case errors.Is(err, auth.ErrAudienceNotAllowed):
+			// RFC 8707, section 2
+			writeError(w, http.StatusBadRequest, "invalid_target")
+		default:
+			h.log.Error("failed to issue tokens", sl.Err(err))
+			writeError(w, http.StatusInternalServerError, "server_error")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(tokens.ExpiresAt).Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		IDToken:      tokens.IDToken,
+		Scope:        scopes.Format(tokens.Scopes),
+	})
+}
+
+type userInfoResponse struct {
+	Sub   string `json:"sub"`
+	Email string `json:"email,omitempty"`
+}
+
+// userinfo is the OpenID Connect UserInfo endpoint.
+// The access token is taken from the Authorization header (RFC 6750, 2.1).
+func (h *handler) userinfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	info, err := h.auth.UserInfo(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidToken):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sso", error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case errors.Is(err, auth.ErrInsufficientScope):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sso", error="insufficient_scope", scope="openid"`)
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			h.log.Error("failed to get user info", sl.Err(err))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userInfoResponse{
+		Sub:   strconv.FormatInt(info.UserID, 10),
+		Email: info.Email,
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+	}
+
+	writeJSON(w, status, errorResponse{Error: code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,110 @@
+package wellknown
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/scopes"
+	"strconv"
+	"strings"
+)
+
+type Keys interface {
+	JWKS(ctx context.Context, appID int) (jwt.JWKS, error)
+	Algorithms() []string
+}
+
+type handler struct {
+	log    *slog.Logger
+	keys   Keys
+	issuer string
+}
+
+// Register adds well-known endpoints to mux. Endpoint URLs in the
+// OpenID Connect discovery document are built from issuer.
+func Register(mux *http.ServeMux, log *slog.Logger, keys Keys, issuer string) {
+	h := &handler{log: log, keys: keys, issuer: strings.TrimSuffix(issuer, "/")}
+
+	mux.HandleFunc("GET /.well-known/jwks.json", h.jwks)
+	mux.HandleFunc("GET /.well-known/openid-configuration", h.openidConfiguration)
+}
+
+// jwks serves public signing keys. An optional app_id query parameter
+// limits the set to keys of one app.
+func (h *handler) jwks(w http.ResponseWriter, r *http.Request) {
+	var appID int
+	if v := r.URL.Query().Get("app_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid app_id", http.StatusBadRequest)
+			return
+		}
+		appID = id
+	}
+
+	set, err := h.keys.JWKS(r.Context(), appID)
+	if err != nil {
+		h.log.Error("failed to get jwks", sl.Err(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = json.NewEncoder(w).Encode(set)
+}
+
+// discovery is the OpenID Provider Metadata (OIDC Discovery 1.0, section 3).
+type discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// idTokenAlgorithms drops HS256: ID tokens are signed only with
+// asymmetric keys, relying parties do not know the app secret.
+func idTokenAlgorithms(algorithms []string) []string {
+	res := make([]string, 0, len(algorithms))
+	for _, alg := range algorithms {
+		if alg != jwt.AlgHS256 {
+			res = append(res, alg)
+		}
+	}
+
+	return res
+}
+
+func (h *handler) openidConfiguration(w http.ResponseWriter, r *http.Request) {
+	doc := discovery{
+		Issuer:                            h.issuer,
+		AuthorizationEndpoint:             h.issuer + "/authorize",
+		TokenEndpoint:                     h.issuer + "/token",
+		UserinfoEndpoint:                  h.issuer + "/userinfo",
+		JWKSURI:                           h.issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  idTokenAlgorithms(h.keys.Algorithms()),
+		ScopesSupported:                   []string{scopes.OpenID, scopes.Email},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_ = json.NewEncoder(w).Encode(doc)
+}
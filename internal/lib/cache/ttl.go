@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type item[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// TTL is a map whose entries expire at a given time.
+// Expired entries are never returned and are removed by a background sweeper.
+// It is safe for concurrent use.
+type TTL[K comparable, V any] struct {
+	mu    sync.RWMutex
+	items map[K]item[V]
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTTL creates a cache and starts sweeping it every sweepInterval.
+// Stop must be called to release the sweeper.
+func NewTTL[K comparable, V any](sweepInterval time.Duration) *TTL[K, V] {
+	c := &TTL[K, V]{
+		items: make(map[K]item[V]),
+		stop:  make(chan struct{}),
+	}
+
+	go c.sweepLoop(sweepInterval)
+
+	return c
+}
+
+func (c *TTL[K, V]) Get(key K) (V, bool) {
+	c.mu.RLock()
+	it, ok := c.items[key]
+	c.mu.RUnlock()
+
+	if !ok || !time.Now().Before(it.expiresAt) {
+		var zero V
+		return zero, false
+	}
+
+	return it.value, true
+}
+
+func (c *TTL[K, V]) Set(key K, value V, expiresAt time.Time) {
+	c.mu.Lock()
+	c.items[key] = item[V]{value: value, expiresAt: expiresAt}
+	c.mu.Unlock()
+}
+
+func (c *TTL[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.items)
+}
+
+// Sweep removes expired entries.
+func (c *TTL[K, V]) Sweep() {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, it := range c.items {
+		if !now.Before(it.expiresAt) {
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *TTL[K, V]) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *TTL[K, V]) sweepLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Sweep()
+		case <-c.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Encrypted data starts with prefix, the key version and a colon,
+// e.g. "enc:v2:", followed by the base64-encoded Cipher output.
+// Being text it fits text columns, and the prefix tells it apart
+// from data stored before it was encrypted.
+const prefix = "enc:v"
+
+var (
+	ErrUnknownKey   = errors.New("unknown encryption key version")
+	ErrNotEncrypted = errors.New("data is not encrypted")
+)
+
+// Keyring encrypts with the current key and decrypts with the key
+// the data was encrypted with, so the master key can be rotated:
+// data encrypted with an old key stays readable until it is re-encrypted.
+type Keyring struct {
+	current int
+	ciphers map[int]*Cipher
+	// decrypts Cipher output stored before keys had versions
+	legacy *Cipher
+}
+
+// NewKeyring creates a keyring encrypting with the key of the current version.
+// legacy may be nil if there is no unversioned data.
+func NewKeyring(current int, ciphers map[int]*Cipher, legacy *Cipher) (*Keyring, error) {
+	if current < 1 {
+		return nil, fmt.Errorf("encryption key version must be positive, got %d", current)
+	}
+	if ciphers[current] == nil {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, current)
+	}
+
+	return &Keyring{
+		current: current,
+		ciphers: ciphers,
+		legacy:  legacy,
+	}, nil
+}
+
+func (k *Keyring) Encrypt(plaintext []byte) ([]byte, error) {
+	ciphertext, err := k.ciphers[k.current].Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	out := fmt.Appendf(nil, "%s%d:", prefix, k.current)
+
+	return base64.StdEncoding.AppendEncode(out, ciphertext), nil
+}
+
+// Decrypt decrypts data made by Encrypt with any key of the keyring.
+// Data without the version prefix is decrypted with the legacy key;
+// without one ErrNotEncrypted is returned.
+func (k *Keyring) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		if k.legacy == nil {
+			return nil, ErrNotEncrypted
+		}
+
+		return k.legacy.Decrypt(data)
+	}
+
+	version, encoded, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher := k.ciphers[version]
+	if cipher == nil {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, version)
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return cipher.Decrypt(ciphertext)
+}
+
+// IsCurrent reports whether data is encrypted with the current key.
+// Anything else should be re-encrypted.
+func (k *Keyring) IsCurrent(data []byte) bool {
+	if !IsEncrypted(data) {
+		return false
+	}
+
+	version, _, err := parse(data)
+
+	return err == nil && version == k.current
+}
+
+// IsEncrypted reports whether data was made by Keyring.Encrypt.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(prefix))
+}
+
+func parse(data []byte) (int, []byte, error) {
+	rest := data[len(prefix):]
+
+	i := bytes.IndexByte(rest, ':')
+	if i < 1 {
+		return 0, nil, ErrDecrypt
+	}
+
+	version, err := strconv.Atoi(string(rest[:i]))
+	if err != nil {
+		return 0, nil, ErrDecrypt
+	}
+
+	return version, rest[i+1:], nil
+}
@@ -1,26 +1,205 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"sso/internal/domain/models"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var ErrInvalidToken = errors.New("invalid token")
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
 
-func NewToken(user models.User, app models.App, duration time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+// Authentication method references (RFC 8176)
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRHardwareKey = "hwk"
+	AMRMFA         = "mfa"
+)
+
+const typMFA = "mfa"
+
+var (
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// Keyring holds the material tokens of one app are verified with.
+type Keyring struct {
+	Secret string              // HMAC secret for tokens without kid
+	Keys   []models.SigningKey // keys selected by kid
+}
+
+// TokenOption adds optional claims to an access token.
+type TokenOption func(claims jwt.MapClaims)
+
+// WithSessionID binds the access token to a refresh token family,
+// so it stops being valid once the session is revoked.
+func WithSessionID(sid string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		claims["sid"] = sid
+	}
+}
+
+// WithRoles embeds roles of the user in the token's app.
+func WithRoles(roles []string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		claims["roles"] = roles
+	}
+}
+
+// WithScopes sets the space-delimited "scope" claim (RFC 9068).
+// Nothing is set if no scopes are granted.
+func WithScopes(scopes []string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		if len(scopes) > 0 {
+			claims["scope"] = strings.Join(scopes, " ")
+		}
+	}
+}
+
+// WithAudience sets the "aud" claim. A single audience is encoded
+// as a string, as most verifiers expect. Nothing is set if aud is empty.
+func WithAudience(aud []string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		switch len(aud) {
+		case 0:
+		case 1:
+			claims["aud"] = aud[0]
+		default:
+			claims["aud"] = aud
+		}
+	}
+}
+
+// WithAMR sets the "amr" claim listing how the user authenticated.
+// Nothing is set if amr is empty.
+func WithAMR(amr []string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		if len(amr) > 0 {
+			claims["amr"] = amr
+		}
+	}
+}
+
+// WithNonce sets the OpenID Connect "nonce" claim if it is not empty.
+func WithNonce(nonce string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+	}
+}
+
+// WithAuthTime sets the "auth_time" claim if t is not zero.
+func WithAuthTime(t time.Time) TokenOption {
+	return func(claims jwt.MapClaims) {
+		if !t.IsZero() {
+			claims["auth_time"] = t.Unix()
+		}
+	}
+}
+
+// WithEmail adds the user's email to an ID token.
+func WithEmail(email string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		claims["email"] = email
+	}
+}
+
+// WithoutClaims removes the named claims. It must be the last option.
+func WithoutClaims(names ...string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		for _, name := range names {
+			delete(claims, name)
+		}
+	}
+}
+
+// NewToken creates an access token signed with the key.
+// If key is empty, the token is signed with HS256 using app.Secret.
+func NewToken(
+	user models.User,
+	app models.App,
+	key models.SigningKey,
+	duration time.Duration,
+	opts ...TokenOption,
+) (string, error) {
+	method, signKey, err := signer(app, key)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.New(method)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
 
 	claims := token.Claims.(jwt.MapClaims)
 	claims["uid"] = user.ID
 	claims["email"] = user.Email
 	claims["exp"] = time.Now().Add(duration).Unix()
 	claims["app_id"] = app.ID
+	claims["jti"] = rand.Text()
+
+	for _, opt := range opts {
+		opt(claims)
+	}
+
+	tokenString, err := token.SignedString(signKey)
+	if err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// NewClientToken creates an access token for the app itself (client credentials grant).
+// The token has no user claims, its subject is the client id.
+func NewClientToken(
+	app models.App,
+	key models.SigningKey,
+	duration time.Duration,
+	opts ...TokenOption,
+) (string, error) {
+	method, signKey, err := signer(app, key)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.New(method)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["sub"] = strconv.Itoa(app.ID)
+	claims["client_id"] = strconv.Itoa(app.ID)
+	claims["exp"] = time.Now().Add(duration).Unix()
+	claims["app_id"] = app.ID
+	claims["jti"] = rand.Text()
+
+	for _, opt := range opts {
+		opt(claims)
+	}
 
-	tokenString, err := token.SignedString([]byte(app.Secret))
+	tokenString, err := token.SignedString(signKey)
 	if err != nil {
 		return "", err
 	}
@@ -28,14 +207,63 @@ func NewToken(user models.User, app models.App, duration time.Duration) (string,
 	return tokenString, nil
 }
 
-func NewRefreshToken(user models.User, app models.App, duration time.Duration) (string, error) {
+// NewIDToken creates an OpenID Connect ID token for the app as audience.
+// The key is chosen the same way as for access tokens.
+func NewIDToken(
+	user models.User,
+	app models.App,
+	key models.SigningKey,
+	issuer string,
+	duration time.Duration,
+	opts ...TokenOption,
+) (string, error) {
+	method, signKey, err := signer(app, key)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.New(method)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	now := time.Now()
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = issuer
+	claims["sub"] = strconv.FormatInt(user.ID, 10)
+	claims["aud"] = strconv.Itoa(app.ID)
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
+
+	for _, opt := range opts {
+		opt(claims)
+	}
+
+	tokenString, err := token.SignedString(signKey)
+	if err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// NewRefreshToken signs a refresh token for the given server-side record.
+// The record's ID and FamilyID are embedded as "jti" and "fid" claims.
+func NewRefreshToken(user models.User, app models.App, rt models.RefreshToken, opts ...TokenOption) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	claims := token.Claims.(jwt.MapClaims)
 	claims["uid"] = user.ID
 	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(duration).Unix()
+	claims["exp"] = rt.ExpiresAt.Unix()
 	claims["app_id"] = app.ID
+	claims["jti"] = rt.ID
+	claims["fid"] = rt.FamilyID
+
+	for _, opt := range opts {
+		opt(claims)
+	}
 
 	tokenString, err := token.SignedString([]byte(app.Refresh_secret))
 	if err != nil {
@@ -45,16 +273,159 @@ func NewRefreshToken(user models.User, app models.App, duration time.Duration) (
 	return tokenString, nil
 }
 
+// NewMFAToken creates a short-lived token proving the user passed the first factor,
+// amr tells which one. It is signed with the app's refresh secret,
+// so it is never accepted as an access token. Single use is enforced by the caller via id.
+func NewMFAToken(
+	user models.User,
+	app models.App,
+	id string,
+	scopes []string,
+	amr []string,
+	duration time.Duration,
+) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["typ"] = typMFA
+	claims["uid"] = user.ID
+	claims["app_id"] = app.ID
+	claims["exp"] = time.Now().Add(duration).Unix()
+	claims["jti"] = id
+	WithScopes(scopes)(claims)
+	WithAMR(amr)(claims)
+
+	return token.SignedString([]byte(app.Refresh_secret))
+}
+
+// MFAChallenge is the content of a token created by NewMFAToken.
+type MFAChallenge struct {
+	ID     string
+	UserID int64
+	Scopes []string // granted at login
+	AMR    []string // first factor
+}
+
+// ParseMFAToken verifies a token created by NewMFAToken.
+func ParseMFAToken(tokenStr string, app models.App) (MFAChallenge, error) {
+	claims, err := ParseJwtToken(tokenStr, Keyring{Secret: app.Refresh_secret}, []string{AlgHS256})
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+
+	if typ, _ := claims["typ"].(string); typ != typMFA {
+		return MFAChallenge{}, ErrInvalidToken
+	}
+
+	uid, ok := claims["uid"].(float64)
+	if !ok {
+		return MFAChallenge{}, ErrInvalidToken
+	}
+
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return MFAChallenge{}, ErrInvalidToken
+	}
+
+	res := MFAChallenge{ID: jti, UserID: int64(uid)}
+	if scope, ok := claims["scope"].(string); ok {
+		res.Scopes = strings.Fields(scope)
+	}
+	if amr, ok := claims["amr"].([]interface{}); ok {
+		for _, method := range amr {
+			if method, ok := method.(string); ok {
+				res.AMR = append(res.AMR, method)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// NewEmailToken creates a token sent to the user by email, e.g. in a
+// verification link. The purpose is put in typ, so a token issued for one
+// purpose can't be used for another. Single use is enforced by the caller via jti.
+func NewEmailToken(token models.EmailToken, email string, secret string) (string, error) {
+	t := jwt.New(jwt.SigningMethodHS256)
+
+	claims := t.Claims.(jwt.MapClaims)
+	claims["typ"] = token.Purpose
+	claims["uid"] = token.UserID
+	claims["email"] = email
+	claims["exp"] = token.ExpiresAt.Unix()
+	claims["jti"] = token.ID
+
+	return t.SignedString([]byte(secret))
+}
+
+// EmailClaims is the content of a token created by NewEmailToken.
+type EmailClaims struct {
+	ID      string
+	UserID  int64
+	Email   string // address the token was sent to
+	Purpose string
+}
+
+// ParseEmailToken verifies a token created by NewEmailToken.
+// The caller must check the purpose.
+func ParseEmailToken(tokenStr string, secret string) (EmailClaims, error) {
+	claims, err := ParseJwtToken(tokenStr, Keyring{Secret: secret}, []string{AlgHS256})
+	if err != nil {
+		return EmailClaims{}, err
+	}
+
+	uid, ok := claims["uid"].(float64)
+	if !ok {
+		return EmailClaims{}, ErrInvalidToken
+	}
+
+	res := EmailClaims{UserID: int64(uid)}
+	res.ID, _ = claims["jti"].(string)
+	res.Email, _ = claims["email"].(string)
+	res.Purpose, _ = claims["typ"].(string)
+
+	if res.ID == "" || res.Email == "" || res.Purpose == "" {
+		return EmailClaims{}, ErrInvalidToken
+	}
+
+	return res, nil
+}
+
+// ParseJwtToken verifies the token against the keyring and returns its claims.
+//
+// Only algorithms listed in algorithms are accepted, so "none" is always rejected.
+// A key named by kid is used only for the algorithm it was created for,
+// so a public key can never be used as an HMAC secret.
+// Tokens without kid are verified with the keyring secret using HMAC only.
 func ParseJwtToken(
 	tokenStr string,
-	appSecret string,
+	keyring Keyring,
+	algorithms []string,
 ) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || keyring.Secret == "" {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(keyring.Secret), nil
 		}
-		return []byte(appSecret), nil
-	})
+
+		for _, key := range keyring.Keys {
+			if key.ID != kid {
+				continue
+			}
+			if key.Algorithm != token.Method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method for key %s: %v", kid, token.Header["alg"])
+			}
+			if key.Algorithm == AlgHS256 {
+				return key.PrivateKey, nil
+			}
+			return x509.ParsePKIXPublicKey(key.PublicKey)
+		}
+
+		return nil, fmt.Errorf("unknown key: %q", kid)
+	}, jwt.WithValidMethods(algorithms))
 	if err != nil || !token.Valid {
 		return nil, ErrInvalidToken
 	}
@@ -71,3 +442,128 @@ func ParseJwtToken(
 		return nil, ErrInvalidToken
 	}
 }
+
+// ParseUnverified returns claims of the token without verifying its signature.
+// The result may only be used to pick the keys the token is then verified with.
+func ParseUnverified(tokenStr string) (jwt.MapClaims, error) {
+	token, _, err := jwt.NewParser().ParseUnverified(tokenStr, jwt.MapClaims{})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// TokenID returns the "jti" claim of the token without verifying its signature.
+// Callers must verify possession of the token by other means.
+func TokenID(tokenStr string) (string, error) {
+	claims, err := ParseUnverified(tokenStr)
+	if err != nil {
+		return "", err
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", ErrInvalidToken
+	}
+
+	return jti, nil
+}
+
+// GenerateSigningKey creates a new key for the app.
+// For HS256 it is a random secret, otherwise a key pair.
+func GenerateSigningKey(appID int, alg string) (models.SigningKey, error) {
+	var (
+		priv crypto.Signer
+		err  error
+	)
+
+	switch alg {
+	case AlgHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return models.SigningKey{}, err
+		}
+
+		return models.SigningKey{
+			ID:         rand.Text(),
+			AppID:      appID,
+			Algorithm:  alg,
+			PrivateKey: secret,
+			PublicKey:  []byte{},
+			CreatedAt:  time.Now(),
+		}, nil
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return models.SigningKey{}, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	return models.SigningKey{
+		ID:         rand.Text(),
+		AppID:      appID,
+		Algorithm:  alg,
+		PrivateKey: privDER,
+		PublicKey:  pubDER,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+func signer(app models.App, key models.SigningKey) (jwt.SigningMethod, interface{}, error) {
+	if key.ID == "" {
+		return jwt.SigningMethodHS256, []byte(app.Secret), nil
+	}
+
+	method, err := signingMethod(key.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if key.Algorithm == AlgHS256 {
+		return method, key.PrivateKey, nil
+	}
+
+	priv, err := x509.ParsePKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return method, priv, nil
+}
+
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case AlgHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgES256:
+		return jwt.SigningMethodES256, nil
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+}
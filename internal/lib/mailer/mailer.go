@@ -0,0 +1,114 @@
+// Package mailer sends transactional emails.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// SMTP sends messages through an SMTP server. Authentication is skipped
+// if Username is empty; net/smtp refuses to send credentials without TLS
+// to hosts other than localhost.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	const op = "mailer.SMTP.Send"
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	// net/smtp has no context support, at least don't start after cancellation
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := smtp.SendMail(addr, auth, m.From, []string{msg.To}, format(m.From, msg)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// File writes every message to Dir as an .eml file and logs it.
+// Meant for local development and tests, where the messages are read
+// from Dir instead of a mailbox.
+type File struct {
+	log  *slog.Logger
+	dir  string
+	from string
+}
+
+func NewFile(log *slog.Logger, dir string, from string) (*File, error) {
+	const op = "mailer.NewFile"
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &File{log: log, dir: dir, from: from}, nil
+}
+
+func (m *File) Send(_ context.Context, msg Message) error {
+	const op = "mailer.File.Send"
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), msg.To)
+	path := filepath.Join(m.dir, filepath.Base(name))
+
+	if err := os.WriteFile(path, format(m.from, msg), 0o644); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.log.Info("email written",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("path", path),
+	)
+
+	return nil
+}
+
+// Line breaks in header values would let them inject headers
+var headerValue = strings.NewReplacer("\r", "", "\n", "")
+
+// format encodes the message in RFC 5322 format.
+func format(from string, msg Message) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", headerValue.Replace(from))
+	fmt.Fprintf(&buf, "To: %s\r\n", headerValue.Replace(msg.To))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue.Replace(msg.Subject)))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return buf.Bytes()
+}
@@ -0,0 +1,182 @@
+// Package passhash hashes passwords with bcrypt or Argon2id.
+// Hashes are encoded in the PHC string format, bcrypt ones in
+// their usual modular crypt format, so the algorithm and parameters
+// of every stored hash are known and outdated hashes can be upgraded.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+var ErrUnknownFormat = errors.New("unknown password hash format")
+
+// Argon2Params are the Argon2id parameters, see RFC 9106.
+type Argon2Params struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Hasher makes hashes with the configured algorithm and verifies
+// hashes made with any supported one.
+type Hasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     Argon2Params
+}
+
+func New(algorithm string, bcryptCost int, argon2Params Argon2Params) (*Hasher, error) {
+	const op = "passhash.New"
+
+	switch algorithm {
+	case Bcrypt:
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("%s: invalid bcrypt cost %d", op, bcryptCost)
+		}
+	case Argon2id:
+		if argon2Params.Memory == 0 || argon2Params.Iterations == 0 || argon2Params.Parallelism == 0 {
+			return nil, fmt.Errorf("%s: argon2id memory, iterations and parallelism are required", op)
+		}
+		if argon2Params.SaltLength == 0 {
+			argon2Params.SaltLength = 16
+		}
+		if argon2Params.KeyLength == 0 {
+			argon2Params.KeyLength = 32
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown algorithm %q", op, algorithm)
+	}
+
+	return &Hasher{
+		algorithm:  algorithm,
+		bcryptCost: bcryptCost,
+		argon2:     argon2Params,
+	}, nil
+}
+
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	const op = "passhash.Hash"
+
+	if h.algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return hash, nil
+	}
+
+	salt := make([]byte, h.argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.argon2.Iterations, h.argon2.Memory, h.argon2.Parallelism, h.argon2.KeyLength)
+
+	return []byte(encodeArgon2(h.argon2, salt, key)), nil
+}
+
+// Verify reports whether password matches hash, and if it does, whether
+// the hash was made with other settings than the current ones and should
+// be replaced with a new one. A wrong password is not an error.
+func (h *Hasher) Verify(hash []byte, password string) (match bool, rehash bool, err error) {
+	const op = "passhash.Verify"
+
+	encoded := string(hash)
+
+	switch {
+	case strings.HasPrefix(encoded, "$2"):
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+
+			return false, false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return false, false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return true, h.algorithm != Bcrypt || cost != h.bcryptCost, nil
+	case strings.HasPrefix(encoded, "$"+Argon2id+"$"):
+		params, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(key, candidate) != 1 {
+			return false, false, nil
+		}
+
+		return true, h.algorithm != Argon2id || params != h.argon2, nil
+	default:
+		return false, false, fmt.Errorf("%s: %w", op, ErrUnknownFormat)
+	}
+}
+
+// encodeArgon2 formats $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+func encodeArgon2(params Argon2Params, salt []byte, key []byte) string {
+	return fmt.Sprintf(
+		"$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		Argon2id,
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	var params Argon2Params
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, ErrUnknownFormat
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
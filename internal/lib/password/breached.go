@@ -0,0 +1,81 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrRangeMissing is returned if the copy lacks the range of a password,
+// which means it is incomplete.
+var ErrRangeMissing = errors.New("breached passwords range file is missing")
+
+// Breached looks passwords up in a local copy of Pwned Passwords.
+// Dir holds one file per 5-character SHA-1 prefix, named like 21BD1.txt,
+// in the format of the range API: lines of "SUFFIX:COUNT" with the
+// remaining 35 characters of the hash in uppercase.
+type Breached struct {
+	dir     string
+	partial bool
+}
+
+// NewBreached checks that dir holds range files. A complete copy has a file
+// for every prefix, so a missing one is an error, unless partial is set
+// for copies holding only some ranges, e.g. test data.
+func NewBreached(dir string, partial bool) (*Breached, error) {
+	const op = "password.NewBreached"
+
+	ranges, err := filepath.Glob(filepath.Join(dir, "?????.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("%s: no range files in %s", op, dir)
+	}
+
+	return &Breached{dir: dir, partial: partial}, nil
+}
+
+// Contains reports whether the password has appeared in a breach.
+// Returns ErrRangeMissing if the copy has no file for the password's prefix.
+func (b *Breached) Contains(password string) (bool, error) {
+	const op = "password.Breached.Contains"
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			if b.partial {
+				return false, nil
+			}
+
+			return false, fmt.Errorf("%s: %w: %s", op, ErrRangeMissing, prefix)
+		}
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		candidate, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// Padding entries of the range API have a zero count
+		if strings.EqualFold(candidate, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return false, nil
+}
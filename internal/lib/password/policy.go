@@ -0,0 +1,137 @@
+// Package password checks new passwords against a policy
+// and a list of breached passwords.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcrypt ignores everything after the first 72 bytes
+const maxBytes = 72
+
+// Reasons of violations, stable identifiers for clients.
+const (
+	ReasonTooShort      = "PASSWORD_TOO_SHORT"
+	ReasonTooLong       = "PASSWORD_TOO_LONG"
+	ReasonMissingLower  = "PASSWORD_MISSING_LOWERCASE"
+	ReasonMissingUpper  = "PASSWORD_MISSING_UPPERCASE"
+	ReasonMissingDigit  = "PASSWORD_MISSING_DIGIT"
+	ReasonMissingSymbol = "PASSWORD_MISSING_SYMBOL"
+	ReasonContainsEmail = "PASSWORD_CONTAINS_EMAIL"
+	ReasonBreached      = "PASSWORD_BREACHED"
+)
+
+type Policy struct {
+	// Minimum length in characters
+	MinLength int
+	// Maximum length in bytes, 0 or more than 72 means 72
+	MaxLength     int
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Allow passwords containing the email or its local part
+	AllowEmail bool
+}
+
+type Violation struct {
+	Reason      string
+	Description string
+}
+
+// PolicyError lists all the rules a password breaks.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	descriptions := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		descriptions = append(descriptions, v.Description)
+	}
+
+	return "password " + strings.Join(descriptions, ", ")
+}
+
+// Check returns *PolicyError if password breaks the policy, email is the user's one.
+func (p Policy) Check(email string, password string) error {
+	if violations := p.violations(email, password); len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+func (p Policy) violations(email string, password string) []Violation {
+	var violations []Violation
+
+	add := func(reason string, format string, args ...any) {
+		violations = append(violations, Violation{Reason: reason, Description: fmt.Sprintf(format, args...)})
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		add(ReasonTooShort, "must be at least %d characters long", p.MinLength)
+	}
+
+	maxLength := p.MaxLength
+	if maxLength <= 0 || maxLength > maxBytes {
+		maxLength = maxBytes
+	}
+	if len(password) > maxLength {
+		add(ReasonTooLong, "must be at most %d bytes long", maxLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+
+	if p.RequireLower && !lower {
+		add(ReasonMissingLower, "must contain a lowercase letter")
+	}
+	if p.RequireUpper && !upper {
+		add(ReasonMissingUpper, "must contain an uppercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add(ReasonMissingDigit, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add(ReasonMissingSymbol, "must contain a symbol")
+	}
+
+	if !p.AllowEmail && containsEmail(email, password) {
+		add(ReasonContainsEmail, "must not contain the email")
+	}
+
+	return violations
+}
+
+// containsEmail reports whether password contains the email or its local part.
+// Local parts shorter than 3 characters are ignored, they appear in too many passwords.
+func containsEmail(email string, password string) bool {
+	email = strings.ToLower(email)
+	password = strings.ToLower(password)
+
+	if email == "" {
+		return false
+	}
+
+	local, _, _ := strings.Cut(email, "@")
+	if utf8.RuneCountInString(local) >= 3 && strings.Contains(password, local) {
+		return true
+	}
+
+	return strings.Contains(password, email)
+}
@@ -0,0 +1,49 @@
+package password
+
+// Validator checks passwords against the policy of the app they are set for.
+type Validator struct {
+	policy   Policy
+	apps     map[int]Policy
+	breached *Breached
+}
+
+// NewValidator creates a validator using policy for apps missing from apps.
+// breached may be nil to skip the breach check.
+func NewValidator(policy Policy, apps map[int]Policy, breached *Breached) *Validator {
+	return &Validator{
+		policy:   policy,
+		apps:     apps,
+		breached: breached,
+	}
+}
+
+// Validate returns *PolicyError if password breaks the policy of appID or
+// has been breached. appID is 0 if the app is unknown.
+func (v *Validator) Validate(appID int, email string, password string) error {
+	policy, ok := v.apps[appID]
+	if !ok {
+		policy = v.policy
+	}
+
+	violations := policy.violations(email, password)
+
+	if v.breached != nil {
+		breached, err := v.breached.Contains(password)
+		if err != nil {
+			return err
+		}
+
+		if breached {
+			violations = append(violations, Violation{
+				Reason:      ReasonBreached,
+				Description: "must not have appeared in a data breach",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+// Package peeraddr passes the client address of a request to services,
+// which don't know about the transport.
+package peeraddr
+
+import (
+	"context"
+	"net"
+)
+
+type ctxKey struct{}
+
+// With returns a context carrying the client's IP. addr may include a port.
+func With(ctx context.Context, addr string) context.Context {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return context.WithValue(ctx, ctxKey{}, addr)
+}
+
+// From returns the client's IP or "" if unknown.
+func From(ctx context.Context) string {
+	addr, _ := ctx.Value(ctxKey{}).(string)
+
+	return addr
+}
@@ -0,0 +1,159 @@
+// Package ratelimit implements token bucket rate limiting of RPCs
+// with per-method policies that can be overridden per app.
+package ratelimit
+
+import (
+	"expvar"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rejections counts rejected calls by method, served at /debug/vars.
+var Rejections = expvar.NewMap("ratelimit_rejections")
+
+// Policy allows Rate calls per second on average with bursts of up to Burst calls.
+// A zero Rate disables limiting.
+type Policy struct {
+	Rate  float64
+	Burst int
+}
+
+// Policies maps method names to their policy. Apps overrides them for
+// requests of the given app_id, methods missing from both are not limited.
+type Policies struct {
+	Methods map[string]Policy
+	Apps    map[int]map[string]Policy
+}
+
+// lookup returns the policy of method and the app it is overridden for,
+// 0 if the method's own policy applies.
+func (p *Policies) lookup(method string, appID int) (Policy, int, bool) {
+	if appID != 0 {
+		if policy, ok := p.Apps[appID][method]; ok {
+			return policy, appID, true
+		}
+	}
+
+	policy, ok := p.Methods[method]
+
+	return policy, 0, ok
+}
+
+type key struct {
+	method string
+	appID  int
+	client string
+}
+
+type bucket struct {
+	policy   Policy
+	tokens   float64
+	updated  time.Time
+	lastSeen time.Time
+}
+
+// Limiter keeps a bucket per method and client, and per app for methods
+// whose policy is overridden for it, so that a client can't get a fresh
+// bucket by sending another app_id with every request.
+// It is safe for concurrent use.
+type Limiter struct {
+	policies atomic.Pointer[Policies]
+
+	mu      sync.Mutex
+	buckets map[key]*bucket
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a limiter and starts dropping idle buckets every sweepInterval.
+// Stop must be called to release the sweeper.
+func New(policies Policies, sweepInterval time.Duration) *Limiter {
+	l := &Limiter{
+		buckets: make(map[key]*bucket),
+		stop:    make(chan struct{}),
+	}
+	l.policies.Store(&policies)
+
+	go l.sweepLoop(sweepInterval)
+
+	return l
+}
+
+// Update replaces the policies. Buckets of changed policies start full.
+func (l *Limiter) Update(policies Policies) {
+	l.policies.Store(&policies)
+}
+
+// Allow takes a token from the bucket of the client for method and appID.
+// If the bucket is empty it returns false and how long until a token is available.
+func (l *Limiter) Allow(method string, appID int, client string) (bool, time.Duration) {
+	policy, scope, ok := l.policies.Load().lookup(method, appID)
+	if !ok || policy.Rate <= 0 {
+		return true, 0
+	}
+	policy.Burst = max(policy.Burst, 1)
+
+	now := time.Now()
+	k := key{method: method, appID: scope, client: client}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[k]
+	if !ok || b.policy != policy {
+		b = &bucket{policy: policy, tokens: float64(policy.Burst), updated: now}
+		l.buckets[k] = b
+	}
+
+	b.tokens = math.Min(float64(policy.Burst), b.tokens+now.Sub(b.updated).Seconds()*policy.Rate)
+	b.updated = now
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		Rejections.Add(method, 1)
+
+		wait := (1 - b.tokens) / policy.Rate
+
+		return false, time.Duration(wait * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+func (l *Limiter) Stop() {
+	l.stopOnce.Do(func() { close(l.stop) })
+}
+
+func (l *Limiter) sweepLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.sweep()
+		}
+	}
+}
+
+// sweep drops buckets that have refilled completely, they are equal to new ones.
+func (l *Limiter) sweep() {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for k, b := range l.buckets {
+		full := time.Duration(float64(b.policy.Burst) / b.policy.Rate * float64(time.Second))
+		if now.Sub(b.lastSeen) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package scopes
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	// OpenID requests an OpenID Connect ID token. Every client may request it.
+	OpenID = "openid"
+	// Email grants access to the user's email in the ID token and userinfo.
+	Email = "email"
+)
+
+// Parse splits a space-delimited scope string (RFC 6749, section 3.3).
+func Parse(s string) []string {
+	return Normalize(strings.Fields(s))
+}
+
+// Format joins scopes into a space-delimited string.
+func Format(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// Normalize returns sorted scopes without duplicates and empty values.
+func Normalize(scopes []string) []string {
+	res := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			res = append(res, scope)
+		}
+	}
+
+	slices.Sort(res)
+
+	return slices.Compact(res)
+}
+
+// Subset reports whether every scope in requested is in allowed.
+func Subset(requested, allowed []string) bool {
+	for _, scope := range requested {
+		if !slices.Contains(allowed, scope) {
+			return false
+		}
+	}
+
+	return true
+}
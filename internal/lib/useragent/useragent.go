@@ -0,0 +1,26 @@
+// Package useragent passes the client software of a request to services,
+// which don't know about the transport.
+package useragent
+
+import "context"
+
+type ctxKey struct{}
+
+// Longer user agents are cut, they are only shown to operators
+const maxLength = 256
+
+// With returns a context carrying the client's user agent.
+func With(ctx context.Context, userAgent string) context.Context {
+	if len(userAgent) > maxLength {
+		userAgent = userAgent[:maxLength]
+	}
+
+	return context.WithValue(ctx, ctxKey{}, userAgent)
+}
+
+// From returns the client's user agent or "" if unknown.
+func From(ctx context.Context) string {
+	userAgent, _ := ctx.Value(ctxKey{}).(string)
+
+	return userAgent
+}
@@ -0,0 +1,94 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"math/big"
+	"sso/internal/lib/jwt"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported credential public key")
+
+// COSE key parameters (RFC 9053)
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// SupportedAlgorithms are COSE algorithm identifiers offered to authenticators,
+// in order of preference.
+var SupportedAlgorithms = []int{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+
+// parseCOSEKey converts a COSE_Key to a public key.
+// The algorithm is returned as its JOSE name.
+func parseCOSEKey(data []byte) (string, crypto.PublicKey, error) {
+	item, _, err := decodeCBOR(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, ok := item.(map[any]any)
+	if !ok {
+		return "", nil, ErrUnsupportedKey
+	}
+
+	kty, _ := key[int64(coseKty)].(int64)
+	alg, _ := key[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == coseAlgES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return "", nil, ErrUnsupportedKey
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return "", nil, ErrUnsupportedKey
+		}
+
+		return jwt.AlgES256, pub, nil
+	case kty == coseKtyOKP && alg == coseAlgEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return "", nil, ErrUnsupportedKey
+		}
+
+		return jwt.AlgEdDSA, ed25519.PublicKey(x), nil
+	case kty == coseKtyRSA && alg == coseAlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return "", nil, ErrUnsupportedKey
+		}
+
+		return jwt.AlgRS256, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	default:
+		return "", nil, ErrUnsupportedKey
+	}
+}
@@ -0,0 +1,114 @@
+package webauthn
+
+import "encoding/base64"
+
+// Options are encoded as PublicKeyCredential*OptionsJSON (WebAuthn Level 3),
+// which browsers accept via PublicKeyCredential.parse*OptionsFromJSON.
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameters struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type CreationOptions struct {
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []credentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// ExistingCredential is a credential to exclude from registration.
+type ExistingCredential struct {
+	ID         []byte
+	Transports []string
+}
+
+// CreationOptions returns options for navigator.credentials.create.
+// Registered credentials are excluded so the same authenticator
+// is not registered twice.
+func (rp RelyingParty) CreationOptions(
+	challenge string,
+	userHandle []byte,
+	userName string,
+	existing []ExistingCredential,
+	timeoutMs int64,
+) CreationOptions {
+	params := make([]credentialParameters, 0, len(SupportedAlgorithms))
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, credentialParameters{Type: "public-key", Alg: alg})
+	}
+
+	exclude := make([]credentialDescriptor, 0, len(existing))
+	for _, c := range existing {
+		exclude = append(exclude, credentialDescriptor{
+			Type:       "public-key",
+			ID:         base64.RawURLEncoding.EncodeToString(c.ID),
+			Transports: c.Transports,
+		})
+	}
+
+	return CreationOptions{
+		RP: rpEntity{ID: rp.ID, Name: rp.Name},
+		User: userEntity{
+			ID:          base64.RawURLEncoding.EncodeToString(userHandle),
+			Name:        userName,
+			DisplayName: userName,
+		},
+		Challenge:          challenge,
+		PubKeyCredParams:   params,
+		Timeout:            timeoutMs,
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns options for navigator.credentials.get.
+// No credentials are listed, so the authenticator offers discoverable
+// credentials (passkeys) of the user.
+func (rp RelyingParty) RequestOptions(challenge string, timeoutMs int64) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeoutMs,
+		RPID:             rp.ID,
+		AllowCredentials: []credentialDescriptor{},
+		UserVerification: "preferred",
+	}
+}
@@ -0,0 +1,233 @@
+package apps
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrAppNotFound = errors.New("app not found")
+	ErrAppExists   = errors.New("app already exists")
+)
+
+// Apps get every grant type unless told otherwise,
+// the same as apps created before grant types could be restricted.
+var defaultGrantTypes = []string{
+	models.GrantAuthorizationCode,
+	models.GrantRefreshToken,
+	models.GrantClientCredentials,
+}
+
+type AppProvider interface {
+	App(ctx context.Context, id int) (models.App, error)
+	Apps(ctx context.Context, afterID int, limit int) ([]models.App, error)
+}
+
+type AppManager interface {
+	SaveApp(ctx context.Context, app models.App) (int, error)
+	UpdateApp(ctx context.Context, app models.App) error
+	DeleteApp(ctx context.Context, id int) error
+	SaveClientSecret(ctx context.Context, appID int, secretHash []byte) error
+}
+
+// Apps lets operators register and configure apps (OAuth 2.0 clients).
+type Apps struct {
+	log         *slog.Logger
+	appProvider AppProvider
+	appManager  AppManager
+}
+
+func New(
+	log *slog.Logger,
+	appProvider AppProvider,
+	appManager AppManager,
+) *Apps {
+	return &Apps{
+		log:         log,
+		appProvider: appProvider,
+		appManager:  appManager,
+	}
+}
+
+// Create registers the app with the settings of app; its ID and secrets
+// are ignored. Secrets tokens are signed with are generated and never
+// leave the server. If confidential is true, a client secret is generated
+// too and returned; it is not stored and can't be shown again.
+func (a *Apps) Create(ctx context.Context, app models.App, confidential bool) (models.App, string, error) {
+	const op = "Apps.Create"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("name", app.Name),
+	)
+
+	app.ID = 0
+	app.Secret = rand.Text()
+	app.Refresh_secret = rand.Text()
+	app.ClientSecretHash = nil
+
+	if len(app.GrantTypes) == 0 {
+		app.GrantTypes = slices.Clone(defaultGrantTypes)
+	}
+
+	var clientSecret string
+	if confidential {
+		var err error
+		clientSecret, app.ClientSecretHash, err = newClientSecret()
+		if err != nil {
+			return models.App{}, "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	id, err := a.appManager.SaveApp(ctx, app)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppExists) {
+			return models.App{}, "", fmt.Errorf("%s: %w", op, ErrAppExists)
+		}
+
+		log.Error("failed to save app", sl.Err(err))
+
+		return models.App{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	app.ID = id
+
+	log.Info("app created", slog.Int("app_id", id))
+
+	return app, clientSecret, nil
+}
+
+// List returns a page of up to limit apps with IDs greater than afterID, ordered by ID.
+func (a *Apps) List(ctx context.Context, afterID int, limit int) ([]models.App, error) {
+	const op = "Apps.List"
+
+	list, err := a.appProvider.Apps(ctx, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return list, nil
+}
+
+func (a *Apps) App(ctx context.Context, id int) (models.App, error) {
+	const op = "Apps.App"
+
+	app, err := a.appProvider.App(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return app, nil
+}
+
+// Update replaces the settings of the app with the ID of app.
+// Secrets are kept, use RotateSecret to change the client secret.
+func (a *Apps) Update(ctx context.Context, app models.App) (models.App, error) {
+	const op = "Apps.Update"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", app.ID),
+	)
+
+	if len(app.GrantTypes) == 0 {
+		app.GrantTypes = slices.Clone(defaultGrantTypes)
+	}
+
+	if err := a.appManager.UpdateApp(ctx, app); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrAppNotFound):
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		case errors.Is(err, storage.ErrAppExists):
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrAppExists)
+		}
+
+		log.Error("failed to update app", sl.Err(err))
+
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("app updated")
+
+	return a.App(ctx, app.ID)
+}
+
+// Delete deletes the app. Its tokens, keys and role assignments go with it.
+func (a *Apps) Delete(ctx context.Context, id int) error {
+	const op = "Apps.Delete"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", id),
+	)
+
+	if err := a.appManager.DeleteApp(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+
+		log.Error("failed to delete app", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("app deleted")
+
+	return nil
+}
+
+// RotateSecret generates a new client secret for the app and returns it.
+// The previous secret stops working at once. A public client
+// becomes confidential.
+func (a *Apps) RotateSecret(ctx context.Context, id int) (string, error) {
+	const op = "Apps.RotateSecret"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", id),
+	)
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.appManager.SaveClientSecret(ctx, id, hash); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return "", fmt.Errorf("%s: %w", op, ErrAppNotFound)
+		}
+
+		log.Error("failed to save client secret", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("client secret rotated")
+
+	return secret, nil
+}
+
+// newClientSecret returns a random client secret and its hash.
+func newClientSecret() (string, []byte, error) {
+	secret := rand.Text()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return secret, hash, nil
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/peeraddr"
+	"sso/internal/lib/useragent"
+	"sync/atomic"
+	"time"
+)
+
+type Store interface {
+	SaveAuditEvents(ctx context.Context, events []models.AuditEvent) error
+	AuditEvents(ctx context.Context, filter models.AuditFilter, beforeID int64, limit int) ([]models.AuditEvent, error)
+}
+
+// Log records audit events in the background, so that requests never
+// wait for the store. Up to bufferSize events wait to be saved; if the
+// store falls further behind, new events are dropped and the number
+// of dropped events is logged.
+type Log struct {
+	log           *slog.Logger
+	store         Store
+	events        chan models.AuditEvent
+	batchSize     int
+	flushInterval time.Duration
+	dropped       atomic.Int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(
+	log *slog.Logger,
+	store Store,
+	bufferSize int,
+	batchSize int,
+	flushInterval time.Duration,
+) *Log {
+	l := &Log{
+		log:           log,
+		store:         store,
+		events:        make(chan models.AuditEvent, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	go l.writeLoop()
+
+	return l
+}
+
+// Stop saves the buffered events and stops the log.
+// Events recorded after Stop are lost.
+func (l *Log) Stop() {
+	close(l.stop)
+	<-l.done
+}
+
+// Record queues the event to be saved. The client address and user agent
+// of the request are taken from ctx unless the event has them.
+func (l *Log) Record(ctx context.Context, event models.AuditEvent) {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if event.IP == "" {
+		event.IP = peeraddr.From(ctx)
+	}
+	if event.UserAgent == "" {
+		event.UserAgent = useragent.From(ctx)
+	}
+
+	select {
+	case l.events <- event:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Events returns up to limit events matching the filter with IDs
+// less than beforeID, newest first. Zero beforeID starts from the newest event.
+// Events still buffered are not returned.
+func (l *Log) Events(
+	ctx context.Context,
+	filter models.AuditFilter,
+	beforeID int64,
+	limit int,
+) ([]models.AuditEvent, error) {
+	const op = "Audit.Events"
+
+	events, err := l.store.AuditEvents(ctx, filter, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// writeLoop saves events in batches of up to batchSize,
+// at least every flushInterval.
+func (l *Log) writeLoop() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.AuditEvent, 0, l.batchSize)
+
+	for {
+		select {
+		case event := <-l.events:
+			batch = append(batch, event)
+			if len(batch) >= l.batchSize {
+				batch = l.flush(batch)
+			}
+		case <-ticker.C:
+			batch = l.flush(batch)
+		case <-l.stop:
+			for {
+				select {
+				case event := <-l.events:
+					batch = append(batch, event)
+					if len(batch) >= l.batchSize {
+						batch = l.flush(batch)
+					}
+				default:
+					l.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush saves the batch and returns it emptied. Events that fail
+// to save are logged and dropped, the log never blocks on the store.
+func (l *Log) flush(batch []models.AuditEvent) []models.AuditEvent {
+	const op = "Audit.flush"
+
+	log := l.log.With(slog.String("op", op))
+
+	if dropped := l.dropped.Swap(0); dropped > 0 {
+		log.Warn("audit buffer is full, events dropped", slog.Int64("count", dropped))
+	}
+
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := l.store.SaveAuditEvents(context.Background(), batch); err != nil {
+		log.Error("failed to save audit events", slog.Int("count", len(batch)), sl.Err(err))
+	}
+
+	return batch[:0]
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/peeraddr"
+	"sso/internal/storage"
+)
+
+// ChangePassword replaces the password of a logged in user and revokes
+// all their sessions except sessionID, the one the change is made from.
+// newPassword must meet the policy of appID, the app of the session.
+// Returns ErrInvalidCredentials if currentPassword is wrong, wrong passwords
+// are throttled as failed logins.
+func (a *Auth) ChangePassword(
+	ctx context.Context,
+	userID int64,
+	appID int,
+	sessionID string,
+	currentPassword string,
+	newPassword string,
+) error {
+	const op = "Auth.ChangePassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkPassword(ctx, user, currentPassword); err != nil {
+		log.Info("invalid current password", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.passwords.Validate(appID, user.Email, newPassword); err != nil {
+		log.Info("new password rejected", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.hasher.Hash(newPassword)
+	if err != nil {
+		log.Error("failed to generate password hash", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.usrSaver.UpdatePassword(ctx, userID, passHash); err != nil {
+		log.Error("failed to update password", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := a.rtSaver.RevokeOtherRefreshTokens(ctx, userID, sessionID)
+	if err != nil {
+		log.Error("failed to revoke other sessions", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.revokeSessions(sessions)
+
+	log.Info("password changed")
+
+	return nil
+}
+
+// ChangeEmail starts changing the email of a logged in user: a confirmation
+// link is sent to newEmail, and the email is changed once it is opened.
+// Returns ErrInvalidCredentials if currentPassword is wrong, wrong passwords
+// are throttled as failed logins, and storage.ErrUserExists if another
+// user has newEmail.
+func (a *Auth) ChangeEmail(ctx context.Context, userID int64, currentPassword string, newEmail string) error {
+	const op = "Auth.ChangeEmail"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	user, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkPassword(ctx, user, currentPassword); err != nil {
+		log.Info("invalid current password", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if newEmail == user.Email {
+		return nil
+	}
+
+	// Checked again when the change is confirmed, someone may register the email meanwhile
+	_, err = a.usrProvider.User(ctx, newEmail)
+	if err == nil {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.verification.SendEmailChange(ctx, user, newEmail); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email change requested")
+
+	return nil
+}
+
+// checkPassword returns ErrInvalidCredentials if password isn't the user's one.
+// Failures count against the account as in authenticate, so a stolen
+// access token can't be used to guess the password.
+func (a *Auth) checkPassword(ctx context.Context, user models.User, password string) error {
+	addr := peeraddr.From(ctx)
+
+	if err := a.throttle.Allow(ctx, user.Email, addr); err != nil {
+		a.log.Warn("password check throttled", slog.Int64("uid", user.ID), slog.String("addr", addr))
+
+		return err
+	}
+
+	match, _, err := a.hasher.Verify(user.PassHash, password)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return a.loginFailed(ctx, user.Email, addr)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sso/internal/domain/models"
+	"sso/internal/lib/password"
+	"sso/internal/services/throttle"
+	"sso/internal/storage"
+)
+
+// Auditor records security events, see models.AuditEvent.
+type Auditor interface {
+	Record(ctx context.Context, event models.AuditEvent)
+}
+
+// Errors recorded as the reason of failed events, others are recorded
+// as internal errors so that no storage details end up in the audit log.
+var auditReasons = []error{
+	ErrInvalidCredentials,
+	ErrInvalidRefreshToken,
+	ErrRefreshTokenReused,
+	ErrScopeNotAllowed,
+	ErrEmailNotVerified,
+	ErrUserDisabled,
+	ErrUnauthorizedClient,
+	ErrInvalidClient,
+	ErrInvalidRedirectURI,
+	ErrInvalidPKCE,
+	ErrInvalidMFAToken,
+	ErrInvalidMFACode,
+	ErrInvalidPasskey,
+	throttle.ErrThrottled,
+	storage.ErrUserExists,
+	storage.ErrAppNotFound,
+}
+
+// record records the event with the outcome of err.
+func (a *Auth) record(ctx context.Context, event models.AuditEvent, err error) {
+	event.Outcome = models.AuditSuccess
+	if err != nil {
+		event.Outcome = models.AuditFailure
+		event.Reason = auditReason(err)
+	}
+
+	a.audit.Record(ctx, event)
+}
+
+// recordLogin records a login that returned tokens and err.
+// A login waiting for the second factor is recorded as such.
+func (a *Auth) recordLogin(ctx context.Context, event models.AuditEvent, tokens models.Tokens, err error) {
+	event.Type = models.AuditLogin
+
+	if errors.Is(err, ErrMFARequired) || (err == nil && tokens.AccessToken == "" && tokens.MFAToken != "") {
+		event.Outcome = models.AuditMFARequired
+		a.audit.Record(ctx, event)
+
+		return
+	}
+
+	a.record(ctx, event, err)
+}
+
+func auditReason(err error) string {
+	for _, reason := range auditReasons {
+		if errors.Is(err, reason) {
+			return reason.Error()
+		}
+	}
+
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return "password rejected by policy"
+	}
+
+	return "internal error"
+}
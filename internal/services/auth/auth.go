@@ -2,16 +2,20 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sso/internal/domain/models"
 	"sso/internal/lib/jwt"
 	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/peeraddr"
+	"sso/internal/lib/scopes"
 	"sso/internal/storage"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserSaver interface {
@@ -20,23 +24,111 @@ type UserSaver interface {
 		email string,
 		passHash []byte,
 	) (uid int64, err error)
+	UpdatePassword(ctx context.Context, userID int64, passHash []byte) error
 }
 
 type UserProvider interface {
 	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, id int64) (models.User, error)
 }
 
 type AppProvider interface {
 	App(ctx context.Context, appID int) (models.App, error)
 }
 
+type RefreshTokenSaver interface {
+	SaveRefreshToken(ctx context.Context, token models.RefreshToken) error
+	MarkRefreshTokenUsed(ctx context.Context, id string) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int64) ([]models.RefreshToken, error)
+	RevokeOtherRefreshTokens(ctx context.Context, userID int64, familyID string) ([]models.RefreshToken, error)
+}
+
+type RefreshTokenProvider interface {
+	RefreshToken(ctx context.Context, id string) (models.RefreshToken, error)
+}
+
+type KeyManager interface {
+	SigningKey(ctx context.Context, appID int) (models.SigningKey, error)
+	Keyring(ctx context.Context, app models.App) (jwt.Keyring, error)
+	Algorithms() []string
+}
+
+type RoleProvider interface {
+	UserRoles(ctx context.Context, userID int64, appID int) ([]string, error)
+}
+
+type TokenDenylist interface {
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+	RevokeSession(sid string, expiresAt time.Time)
+	IsSessionRevoked(ctx context.Context, sid string, expiresAt time.Time) (bool, error)
+}
+
+type AuthorizationCodeSaver interface {
+	SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, hash string) (models.AuthorizationCode, error)
+}
+
+// MFAVerifier checks the second factor of users who enabled it.
+type MFAVerifier interface {
+	Enabled(ctx context.Context, userID int64) (bool, error)
+	Verify(ctx context.Context, userID int64, code string) error
+}
+
+// LoginThrottler slows down password guessing.
+type LoginThrottler interface {
+	Allow(ctx context.Context, email string, addr string) error
+	Failure(ctx context.Context, email string, addr string) error
+	Success(ctx context.Context, email string) error
+}
+
+// PasswordValidator enforces the password policy of apps,
+// it returns *password.PolicyError for rejected passwords.
+type PasswordValidator interface {
+	Validate(appID int, email string, password string) error
+}
+
+// PasswordHasher hashes user passwords. Verify tells if a matching hash
+// is outdated and should be replaced with a new one.
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	Verify(hash []byte, password string) (match bool, rehash bool, err error)
+}
+
+// VerificationSender mails email verification links.
+type VerificationSender interface {
+	SendVerification(ctx context.Context, user models.User) error
+	SendEmailChange(ctx context.Context, user models.User, newEmail string) error
+}
+
 type Auth struct {
 	log             *slog.Logger
 	usrSaver        UserSaver
 	usrProvider     UserProvider
 	appProvider     AppProvider
+	rtSaver         RefreshTokenSaver
+	rtProvider      RefreshTokenProvider
+	keys            KeyManager
+	denylist        TokenDenylist
+	roleProvider    RoleProvider
+	codeSaver       AuthorizationCodeSaver
+	mfa             MFAVerifier
+	mfaChallenges   MFAChallengeStore
+	passkeys        PasskeyVerifier
+	verification    VerificationSender
+	resetTokens     ResetTokenStore
+	notifier        Notifier
+	throttle        LoginThrottler
+	passwords       PasswordValidator
+	hasher          PasswordHasher
+	audit           Auditor
 	tokenTTL        time.Duration
 	refreshTokenTTL time.Duration
+	codeTTL         time.Duration
+	resetTTL        time.Duration
+	issuer          string
+	resetURL        string
 }
 
 func New(
@@ -44,20 +136,62 @@ func New(
 	userSaver UserSaver,
 	userProvider UserProvider,
 	appProvider AppProvider,
+	refreshTokenSaver RefreshTokenSaver,
+	refreshTokenProvider RefreshTokenProvider,
+	keyManager KeyManager,
+	denylist TokenDenylist,
+	roleProvider RoleProvider,
+	codeSaver AuthorizationCodeSaver,
+	mfa MFAVerifier,
+	mfaChallenges MFAChallengeStore,
+	passkeys PasskeyVerifier,
+	verification VerificationSender,
+	resetTokens ResetTokenStore,
+	notifier Notifier,
+	throttle LoginThrottler,
+	passwords PasswordValidator,
+	hasher PasswordHasher,
+	audit Auditor,
 	tokenTTL time.Duration,
 	refreshTokenTTL time.Duration,
+	codeTTL time.Duration,
+	resetTTL time.Duration,
+	issuer string,
+	resetURL string,
 ) *Auth {
 	return &Auth{
 		usrSaver:        userSaver,
 		usrProvider:     userProvider,
 		log:             log,
 		appProvider:     appProvider,
+		rtSaver:         refreshTokenSaver,
+		rtProvider:      refreshTokenProvider,
+		keys:            keyManager,
+		denylist:        denylist,
+		roleProvider:    roleProvider,
+		codeSaver:       codeSaver,
+		mfa:             mfa,
+		mfaChallenges:   mfaChallenges,
+		passkeys:        passkeys,
+		verification:    verification,
+		resetTokens:     resetTokens,
+		notifier:        notifier,
+		throttle:        throttle,
+		passwords:       passwords,
+		hasher:          hasher,
+		audit:           audit,
 		tokenTTL:        tokenTTL,
 		refreshTokenTTL: refreshTokenTTL,
+		codeTTL:         codeTTL,
+		resetTTL:        resetTTL,
+		issuer:          issuer,
+		resetURL:        resetURL,
 	}
 }
 
-func (a *Auth) RegisterNewUser(ctx context.Context, email string, pass string) (int64, error) {
+// RegisterNewUser creates a user whose password meets the policy of appID,
+// 0 if the user registers outside of any app.
+func (a *Auth) RegisterNewUser(ctx context.Context, email string, pass string, appID int) (int64, error) {
 	const op = "Auth.RegisterNewUser"
 
 	log := a.log.With(
@@ -67,7 +201,16 @@ func (a *Auth) RegisterNewUser(ctx context.Context, email string, pass string) (
 
 	log.Info("registering user")
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	event := models.AuditEvent{Type: models.AuditRegister, Email: email, AppID: appID}
+
+	if err := a.passwords.Validate(appID, email, pass); err != nil {
+		log.Info("password rejected", sl.Err(err))
+		a.record(ctx, event, err)
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.hasher.Hash(pass)
 	if err != nil {
 		log.Error("failed to generate password hash", sl.Err(err))
 
@@ -77,27 +220,51 @@ func (a *Auth) RegisterNewUser(ctx context.Context, email string, pass string) (
 	id, err := a.usrSaver.SaveUser(ctx, email, passHash)
 	if err != nil {
 		log.Error("failed to save user", sl.Err(err))
+		a.record(ctx, event, err)
 
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
+	event.UserID = id
+	a.record(ctx, event, nil)
+
+	// The account is created anyway, the user can ask to resend the email
+	if err := a.verification.SendVerification(ctx, models.User{ID: id, Email: email}); err != nil {
+		log.Error("failed to send verification email", sl.Err(err))
+	}
+
 	return id, nil
 }
 
 var (
-	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrScopeNotAllowed     = errors.New("scope not allowed")
+	ErrEmailNotVerified    = errors.New("email not verified")
+	ErrUserDisabled        = errors.New("user disabled")
+
+	// ID tokens signed with the server-only app secret could not be
+	// verified by relying parties, so openid is refused for such apps.
+	ErrIDTokenKeyRequired = fmt.Errorf("%w: id tokens need an asymmetric signing key", ErrScopeNotAllowed)
 )
 
 // Login checks if user exists in the system and password correct, returns acess token
 //
 // if user exists, but password is incorrect, returns error
 // if user doesnt exists, returns error
+// if requested scopes are not allowed for the app, returns ErrScopeNotAllowed;
+// if no scopes are requested, all scopes allowed for the app are granted
+// if the app requires verified emails and the user's one isn't, returns ErrEmailNotVerified
+// if the user has been disabled by an admin, returns ErrUserDisabled
+// if the user has MFA enabled, only MFAToken is returned, see VerifyMFA
 func (a *Auth) Login(
 	ctx context.Context,
 	email string,
 	password string,
 	appID int,
-) (string, string, error) {
+	requestedScopes []string,
+) (models.Tokens, error) {
 	const op = "Auth.Login"
 
 	log := a.log.With(
@@ -108,79 +275,669 @@ func (a *Auth) Login(
 
 	log.Info("attempting to login user")
 
+	event := models.AuditEvent{Email: email, AppID: appID}
+
+	user, err := a.authenticate(ctx, email, password)
+	if err != nil {
+		a.recordLogin(ctx, event, models.Tokens{}, err)
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.UserID = user.ID
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		a.recordLogin(ctx, event, models.Tokens{}, err)
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.startSession(ctx, log, user, app, requestedScopes, []string{jwt.AMRPassword})
+	a.recordLogin(ctx, event, tokens, err)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Failures are reset only once the second factor is passed as well
+	if tokens.AccessToken != "" {
+		a.loginSucceeded(ctx, email)
+	}
+
+	return tokens, nil
+}
+
+// startSession completes a login after the first factor (amr) is verified.
+// If the user has MFA enabled and amr doesn't already include it,
+// only an MFA token is returned.
+func (a *Auth) startSession(
+	ctx context.Context,
+	log *slog.Logger,
+	user models.User,
+	app models.App,
+	requestedScopes []string,
+	amr []string,
+) (models.Tokens, error) {
+	if user.Disabled {
+		log.Info("user disabled")
+
+		return models.Tokens{}, ErrUserDisabled
+	}
+
+	if app.RequireVerifiedEmail && !user.EmailVerified {
+		log.Info("email not verified")
+
+		return models.Tokens{}, ErrEmailNotVerified
+	}
+
+	granted, err := grantScopes(requestedScopes, app.Scopes)
+	if err != nil {
+		log.Warn("requested scopes are not allowed", slog.Any("scopes", requestedScopes))
+
+		return models.Tokens{}, err
+	}
+
+	if !slices.Contains(amr, jwt.AMRMFA) {
+		mfaEnabled, err := a.mfa.Enabled(ctx, user.ID)
+		if err != nil {
+			return models.Tokens{}, err
+		}
+
+		if mfaEnabled {
+			id := rand.Text()
+			if err := a.mfaChallenges.SaveMFAChallenge(ctx, id, user.ID, time.Now().Add(mfaTokenTTL)); err != nil {
+				log.Error("failed to save mfa challenge", sl.Err(err))
+
+				return models.Tokens{}, err
+			}
+
+			mfaToken, err := jwt.NewMFAToken(user, app, id, granted, amr, mfaTokenTTL)
+			if err != nil {
+				return models.Tokens{}, err
+			}
+
+			log.Info("second factor required")
+
+			return models.Tokens{MFAToken: mfaToken}, nil
+		}
+	}
+
+	log.Info("user logged in successfully")
+
+	tokens, err := a.issueTokens(ctx, user, app, rand.Text(), granted, amr)
+	if err != nil {
+		log.Error("failed to generate tokens", sl.Err(err))
+
+		return models.Tokens{}, err
+	}
+
+	return tokens, nil
+}
+
+// authenticate checks the user's credentials.
+// Returns ErrInvalidCredentials if the user doesn't exist or the password is wrong,
+// and a *throttle.RetryError if there were too many failures recently.
+// Failures aren't reset, the caller calls loginSucceeded once the login completes.
+func (a *Auth) authenticate(ctx context.Context, email string, password string) (models.User, error) {
+	addr := peeraddr.From(ctx)
+
+	if err := a.throttle.Allow(ctx, email, addr); err != nil {
+		a.log.Warn("login throttled", slog.String("email", email), slog.String("addr", addr))
+
+		return models.User{}, err
+	}
+
 	user, err := a.usrProvider.User(ctx, email)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
 			a.log.Warn("user not found", sl.Err(err))
 
-			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+			return models.User{}, a.loginFailed(ctx, email, addr)
 		}
 
 		a.log.Error("failed to get user", sl.Err(err))
 
-		return "", "", fmt.Errorf("%s: %w", op, err)
+		return models.User{}, err
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		a.log.Info("invalid credentials", sl.Err(err))
+	match, rehash, err := a.hasher.Verify(user.PassHash, password)
+	if err != nil {
+		a.log.Error("failed to verify password", slog.Int64("uid", user.ID), sl.Err(err))
 
-		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		return models.User{}, a.loginFailed(ctx, email, addr)
 	}
+	if !match {
+		a.log.Info("invalid credentials", slog.Int64("uid", user.ID))
 
-	app, err := a.appProvider.App(ctx, appID)
-	if err != nil {
+		return models.User{}, a.loginFailed(ctx, email, addr)
+	}
 
-		return "", "", fmt.Errorf("%s: %w", op, err)
+	if rehash {
+		a.rehashPassword(ctx, user.ID, password)
 	}
 
-	log.Info("user logged in successfully")
+	return user, nil
+}
+
+// rehashPassword replaces an outdated hash of the user's password,
+// the login goes on if it fails, the hash is upgraded next time.
+func (a *Auth) rehashPassword(ctx context.Context, userID int64, password string) {
+	log := a.log.With(slog.Int64("uid", userID))
 
-	token, err := jwt.NewToken(user, app, a.tokenTTL)
+	passHash, err := a.hasher.Hash(password)
 	if err != nil {
-		a.log.Error("failed to generate token", sl.Err(err))
+		log.Error("failed to rehash password", sl.Err(err))
 
-		return "", "", fmt.Errorf("%s: %w", op, err)
+		return
 	}
-	refresh_token, err := jwt.NewRefreshToken(user, app, a.refreshTokenTTL)
-	if err != nil {
-		a.log.Error("failed to generate refresh token", sl.Err(err))
-		return "", "", fmt.Errorf("%s: %w", op, err)
+
+	if err := a.usrSaver.UpdatePassword(ctx, userID, passHash); err != nil {
+		log.Error("failed to save rehashed password", sl.Err(err))
+
+		return
 	}
 
-	return token, refresh_token, nil
+	log.Info("password hash upgraded")
 }
 
+// loginSucceeded resets the login failures of the email.
+func (a *Auth) loginSucceeded(ctx context.Context, email string) {
+	if err := a.throttle.Success(ctx, email); err != nil {
+		a.log.Error("failed to reset login failures", sl.Err(err))
+	}
+}
+
+// loginFailed records a failed login and returns ErrInvalidCredentials.
+func (a *Auth) loginFailed(ctx context.Context, email string, addr string) error {
+	if err := a.throttle.Failure(ctx, email, addr); err != nil {
+		a.log.Error("failed to record login failure", sl.Err(err))
+	}
+
+	return ErrInvalidCredentials
+}
+
+// grantScopes returns the requested scopes if all of them are allowed,
+// or every allowed scope if none are requested.
+func grantScopes(requested []string, allowed []string) ([]string, error) {
+	requested = scopes.Normalize(requested)
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+
+	if !scopes.Subset(requested, allowed) {
+		return nil, ErrScopeNotAllowed
+	}
+
+	return requested, nil
+}
+
+// RefreshToken exchanges a refresh token for a new token pair.
+// See Refresh for details.
 func (a *Auth) RefreshToken(
 	ctx context.Context,
 	refresh_token string,
 	appID int,
+	requestedScopes []string,
 ) (string, string, error) {
-	const op = "Auth.RefreshToken"
+	tokens, err := a.Refresh(ctx, refresh_token, appID, requestedScopes)
+	if err != nil {
+		return "", "", err
+	}
+
+	return tokens.AccessToken, tokens.RefreshToken, nil
+}
+
+// Refresh exchanges a refresh token for new tokens.
+//
+// Every refresh token can be used only once: it is rotated on each call.
+// If an already used token is presented, the whole token family is revoked
+// and ErrRefreshTokenReused is returned.
+//
+// Requested scopes may narrow the scopes granted at login but never widen them.
+// If no scopes are requested, the granted scopes are kept.
+//
+// Apps not allowed the refresh token grant get ErrUnauthorizedClient.
+func (a *Auth) Refresh(
+	ctx context.Context,
+	refresh_token string,
+	appID int,
+	requestedScopes []string,
+) (models.Tokens, error) {
+	tokens, userID, err := a.refresh(ctx, refresh_token, appID, requestedScopes)
+	a.record(ctx, models.AuditEvent{Type: models.AuditRefresh, UserID: userID, AppID: appID}, err)
+
+	return tokens, err
+}
+
+// refresh implements Refresh, it also returns the user of the token once it is found.
+func (a *Auth) refresh(
+	ctx context.Context,
+	refresh_token string,
+	appID int,
+	requestedScopes []string,
+) (models.Tokens, int64, error) {
+	const op = "Auth.Refresh"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	var userID int64
+
 	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !app.AllowsGrant(models.GrantRefreshToken) {
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, ErrUnauthorizedClient)
+	}
 
+	claims, err := jwt.ParseJwtToken(
+		refresh_token,
+		jwt.Keyring{Secret: app.Refresh_secret},
+		[]string{jwt.AlgHS256},
+	)
 	if err != nil {
-		return "", "", fmt.Errorf("%s: %w", op, err)
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
 
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
 	}
 
-	claims, err := jwt.ParseJwtToken(refresh_token, app.Refresh_secret)
+	stored, err := a.rtProvider.RefreshToken(ctx, jti)
 	if err != nil {
-		return "", "", fmt.Errorf("%s: %w", op, err)
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+		}
+
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, err)
 	}
 
-	email := claims["email"].(string)
-	user, err := a.usrProvider.User(ctx, email)
+	hash := hashToken(refresh_token)
+	if subtle.ConstantTimeCompare(stored.TokenHash, hash) != 1 || stored.AppID != app.ID {
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	if stored.Revoked {
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	userID = stored.UserID
+
+	granted, err := grantScopes(requestedScopes, stored.Scopes)
 	if err != nil {
-		return "", "", fmt.Errorf("%s: %w", op, err)
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, err)
 	}
-	access_token, err := jwt.NewToken(user, app, a.tokenTTL)
 
+	if err := a.rtSaver.MarkRefreshTokenUsed(ctx, stored.ID); err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenUsed) {
+			log.Warn("refresh token reuse detected, revoking family",
+				slog.String("family_id", stored.FamilyID),
+				slog.Int64("uid", stored.UserID),
+			)
+
+			a.audit.Record(ctx, models.AuditEvent{
+				Type:    models.AuditTokenReuse,
+				Outcome: models.AuditDenied,
+				UserID:  stored.UserID,
+				AppID:   app.ID,
+				Reason:  "token family revoked",
+			})
+
+			if err := a.rtSaver.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+				log.Error("failed to revoke token family", sl.Err(err))
+
+				return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, err)
+			}
+			a.denylist.RevokeSession(stored.FamilyID, stored.ExpiresAt)
+
+			return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, ErrRefreshTokenReused)
+		}
+
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The email claim may be omitted or outdated, see models.App.OmitClaims
+	user, err := a.usrProvider.UserByID(ctx, stored.UserID)
 	if err != nil {
-		return "", "", fmt.Errorf("%s: %w", op, err)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+		}
+
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.issueTokens(ctx, user, app, stored.FamilyID, granted, stored.AMR)
+	if err != nil {
+		log.Error("failed to generate tokens", sl.Err(err))
+
+		return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if slices.Contains(granted, scopes.OpenID) {
+		tokens.IDToken, err = a.newIDToken(ctx, user, app, granted)
+		if err != nil {
+			log.Error("failed to generate id token", sl.Err(err))
+
+			return models.Tokens{}, userID, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return tokens, userID, nil
+}
+
+// Logout ends the session the refresh token belongs to
+// by revoking its whole token family.
+func (a *Auth) Logout(ctx context.Context, refresh_token string) error {
+	const op = "Auth.Logout"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	jti, err := jwt.TokenID(refresh_token)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	stored, err := a.rtProvider.RefreshToken(ctx, jti)
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Possession of the exact token is proven by its hash
+	if subtle.ConstantTimeCompare(stored.TokenHash, hashToken(refresh_token)) != 1 {
+		return fmt.Errorf("%s: %w", op, ErrInvalidRefreshToken)
+	}
+
+	if err := a.rtSaver.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		log.Error("failed to revoke token family", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.denylist.RevokeSession(stored.FamilyID, stored.ExpiresAt)
+
+	log.Info("user logged out", slog.Int64("uid", stored.UserID))
+
+	return nil
+}
+
+// LogoutAll revokes every outstanding refresh token of the user across all apps,
+// access tokens of the sessions are denied at once.
+func (a *Auth) LogoutAll(ctx context.Context, userID int64) error {
+	const op = "Auth.LogoutAll"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	sessions, err := a.rtSaver.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		log.Error("failed to revoke user refresh tokens", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.revokeSessions(sessions)
+
+	log.Info("all user sessions revoked")
+
+	return nil
+}
+
+// revokeSessions denies at once the access tokens of sessions
+// revoked in storage, see TokenDenylist.RevokeSession.
+func (a *Auth) revokeSessions(sessions []models.RefreshToken) {
+	for _, session := range sessions {
+		a.denylist.RevokeSession(session.FamilyID, session.ExpiresAt)
+	}
+}
+
+// ValidateToken introspects an access token.
+//
+// Invalid, expired and revoked tokens are reported as inactive, not as errors.
+// Errors are returned only if the token state can't be determined.
+func (a *Auth) ValidateToken(ctx context.Context, token string) (models.TokenInfo, error) {
+	const op = "Auth.ValidateToken"
+
+	inactive := models.TokenInfo{}
+
+	claims, err := a.parseAccessToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrInvalidToken) {
+			return inactive, nil
+		}
+
+		return inactive, fmt.Errorf("%s: %w", op, err)
+	}
+
+	info := tokenInfo(claims)
+
+	if jti, _ := claims["jti"].(string); jti != "" {
+		revoked, err := a.denylist.IsRevoked(ctx, jti, info.ExpiresAt)
+		if err != nil {
+			return inactive, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if revoked {
+			return models.TokenInfo{Revoked: true}, nil
+		}
+	}
+
+	if sid, _ := claims["sid"].(string); sid != "" {
+		revoked, err := a.denylist.IsSessionRevoked(ctx, sid, info.ExpiresAt)
+		if err != nil {
+			return inactive, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if revoked {
+			return models.TokenInfo{Revoked: true}, nil
+		}
+	}
+
+	info.Active = true
+
+	return info, nil
+}
+
+// RevokeToken revokes an access token before it expires.
+// Revoking an invalid or expired token is a no-op, as in RFC 7009.
+func (a *Auth) RevokeToken(ctx context.Context, token string) error {
+	const op = "Auth.RevokeToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := a.parseAccessToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrInvalidToken) {
+			return nil
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
 	}
-	new_refresh_token, err := jwt.NewRefreshToken(user, app, a.refreshTokenTTL)
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		// Issued before tokens had ids, can only expire
+		return nil
+	}
+
+	info := tokenInfo(claims)
+
+	if err := a.denylist.Revoke(ctx, jti, info.ExpiresAt); err != nil {
+		log.Error("failed to revoke token", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("access token revoked", slog.Int64("uid", info.UserID), slog.String("jti", jti))
+
+	return nil
+}
+
+// parseAccessToken verifies the access token with the keys of the app it claims
+// to be issued for. Returns jwt.ErrInvalidToken if it can't be verified.
+func (a *Auth) parseAccessToken(ctx context.Context, token string) (map[string]interface{}, error) {
+	unverified, err := jwt.ParseUnverified(token)
 	if err != nil {
-		return "", "", fmt.Errorf("%s: %w", op, err)
+		return nil, err
+	}
+
+	appID, ok := unverified["app_id"].(float64)
+	if !ok {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	app, err := a.appProvider.App(ctx, int(appID))
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, jwt.ErrInvalidToken
+		}
+
+		return nil, err
+	}
+
+	keyring, err := a.keys.Keyring(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := jwt.ParseJwtToken(token, keyring, a.keys.Algorithms())
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func tokenInfo(claims map[string]interface{}) models.TokenInfo {
+	var info models.TokenInfo
+
+	if uid, ok := claims["uid"].(float64); ok {
+		info.UserID = int64(uid)
+	}
+	if appID, ok := claims["app_id"].(float64); ok {
+		info.AppID = int(appID)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		info.ExpiresAt = time.Unix(int64(exp), 0)
 	}
-	return access_token, new_refresh_token, nil
+	info.Email, _ = claims["email"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		info.Scopes = scopes.Parse(scope)
+	}
+	if roles, ok := claims["roles"].([]interface{}); ok {
+		for _, role := range roles {
+			if role, ok := role.(string); ok {
+				info.Roles = append(info.Roles, role)
+			}
+		}
+	}
+	if amr, ok := claims["amr"].([]interface{}); ok {
+		info.MFA = slices.Contains(amr, interface{}(jwt.AMRMFA))
+	}
+	info.SessionID, _ = claims["sid"].(string)
+
+	return info
+}
+
+// issueTokens creates an access token and a refresh token belonging to familyID
+// and persists the refresh token hash. amr lists how the user authenticated,
+// it is kept for the whole session.
+func (a *Auth) issueTokens(
+	ctx context.Context,
+	user models.User,
+	app models.App,
+	familyID string,
+	granted []string,
+	amr []string,
+) (models.Tokens, error) {
+	key, err := a.keys.SigningKey(ctx, app.ID)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+
+	roles, err := a.roleProvider.UserRoles(ctx, user.ID, app.ID)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+
+	now := time.Now()
+
+	access_token, err := jwt.NewToken(
+		user,
+		app,
+		key,
+		a.accessTTL(app),
+		jwt.WithSessionID(familyID),
+		jwt.WithRoles(roles),
+		jwt.WithScopes(granted),
+		jwt.WithAMR(amr),
+		jwt.WithoutClaims(app.OmitClaims...),
+	)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+
+	rt := models.RefreshToken{
+		ID:        rand.Text(),
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		AppID:     app.ID,
+		ExpiresAt: now.Add(a.refreshTTL(app)),
+		Scopes:    granted,
+		AMR:       amr,
+	}
+
+	refresh_token, err := jwt.NewRefreshToken(user, app, rt, jwt.WithoutClaims(app.OmitClaims...))
+	if err != nil {
+		return models.Tokens{}, err
+	}
+
+	rt.TokenHash = hashToken(refresh_token)
+	if err := a.rtSaver.SaveRefreshToken(ctx, rt); err != nil {
+		return models.Tokens{}, err
+	}
+
+	return models.Tokens{
+		AccessToken:  access_token,
+		RefreshToken: refresh_token,
+		ExpiresAt:    now.Add(a.accessTTL(app)),
+		Scopes:       granted,
+	}, nil
+}
+
+// accessTTL returns the lifetime of the app's access tokens,
+// the app's own one or the global default.
+func (a *Auth) accessTTL(app models.App) time.Duration {
+	if app.AccessTokenTTL > 0 {
+		return app.AccessTokenTTL
+	}
+
+	return a.tokenTTL
+}
+
+// refreshTTL is accessTTL for refresh tokens.
+func (a *Auth) refreshTTL(app models.App) time.Duration {
+	if app.RefreshTokenTTL > 0 {
+		return app.RefreshTokenTTL
+	}
+
+	return a.refreshTokenTTL
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+
+	return sum[:]
 }
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/peeraddr"
+	"sso/internal/services/mfa"
+	"sso/internal/storage"
+	"time"
+)
+
+// How long the user has to enter the second factor after the password
+const mfaTokenTTL = 5 * time.Minute
+
+// How many codes can be tried with one MFA token
+const maxMFAAttempts = 5
+
+var (
+	ErrMFARequired     = errors.New("second factor required")
+	ErrInvalidMFAToken = errors.New("invalid mfa token")
+	ErrInvalidMFACode  = errors.New("invalid mfa code")
+)
+
+// MFAChallengeStore keeps the MFA tokens that are still usable.
+// UseMFAChallenge counts an attempt and returns storage.ErrMFAChallengeNotFound
+// once the challenge has expired, been used or run out of attempts.
+type MFAChallengeStore interface {
+	SaveMFAChallenge(ctx context.Context, id string, userID int64, expiresAt time.Time) error
+	UseMFAChallenge(ctx context.Context, id string, maxAttempts int) error
+	DeleteMFAChallenge(ctx context.Context, id string) error
+}
+
+// VerifyMFA completes a login started by Login for users with MFA enabled.
+// code is either a TOTP code or a recovery code. The MFA token is single use
+// and stops working after maxMFAAttempts wrong codes, which also count
+// as failed logins of the user.
+func (a *Auth) VerifyMFA(ctx context.Context, mfaToken string, code string, appID int) (models.Tokens, error) {
+	const op = "Auth.VerifyMFA"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidMFAToken)
+		}
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	challenge, err := jwt.ParseMFAToken(mfaToken, app)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidMFAToken)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidMFAToken)
+		}
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	event := models.AuditEvent{UserID: user.ID, Email: user.Email, AppID: app.ID}
+
+	addr := peeraddr.From(ctx)
+	if err := a.throttle.Allow(ctx, user.Email, addr); err != nil {
+		log.Warn("mfa throttled", slog.Int64("uid", user.ID), slog.String("addr", addr))
+		a.recordLogin(ctx, event, models.Tokens{}, err)
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.mfaChallenges.UseMFAChallenge(ctx, challenge.ID, maxMFAAttempts); err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			log.Warn("mfa token already used or out of attempts", slog.Int64("uid", user.ID))
+
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidMFAToken)
+		}
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.verifySecondFactor(ctx, user.ID, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			a.mfaFailed(ctx, user.Email)
+		}
+		a.recordLogin(ctx, event, models.Tokens{}, err)
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Only one of concurrent requests with the right code wins
+	if err := a.mfaChallenges.DeleteMFAChallenge(ctx, challenge.ID); err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidMFAToken)
+		}
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The user may have been disabled since entering the password
+	if user.Disabled {
+		a.recordLogin(ctx, event, models.Tokens{}, ErrUserDisabled)
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
+	}
+
+	amr := append(challenge.AMR, jwt.AMROTP, jwt.AMRMFA)
+
+	tokens, err := a.issueTokens(ctx, user, app, rand.Text(), challenge.Scopes, amr)
+	if err != nil {
+		log.Error("failed to generate tokens", sl.Err(err))
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in with second factor", slog.Int64("uid", user.ID))
+	a.recordLogin(ctx, event, tokens, nil)
+	a.loginSucceeded(ctx, user.Email)
+
+	return tokens, nil
+}
+
+// mfaFailed counts a wrong second factor as a failed login of the email.
+func (a *Auth) mfaFailed(ctx context.Context, email string) {
+	if err := a.throttle.Failure(ctx, email, peeraddr.From(ctx)); err != nil {
+		a.log.Error("failed to record mfa failure", sl.Err(err))
+	}
+}
+
+func (a *Auth) verifySecondFactor(ctx context.Context, userID int64, code string) error {
+	if err := a.mfa.Verify(ctx, userID, code); err != nil {
+		if errors.Is(err, mfa.ErrInvalidCode) || errors.Is(err, mfa.ErrNotEnrolled) {
+			return ErrInvalidMFACode
+		}
+
+		return err
+	}
+
+	return nil
+}
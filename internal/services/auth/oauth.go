@@ -0,0 +1,500 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/scopes"
+	"sso/internal/storage"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidClient      = errors.New("invalid client")
+	ErrInvalidRedirectURI = errors.New("invalid redirect uri")
+	ErrInvalidGrant       = errors.New("invalid grant")
+	ErrInvalidPKCE        = errors.New("invalid pkce parameters")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrInsufficientScope  = errors.New("insufficient scope")
+	ErrAudienceNotAllowed = errors.New("audience not allowed")
+	ErrUnauthorizedClient = errors.New("grant type not allowed for client")
+)
+
+// ValidateClient checks that the app exists, may use the authorization code grant
+// and redirectURI is registered for it. It must succeed before the user is shown a login page.
+func (a *Auth) ValidateClient(ctx context.Context, clientID int, redirectURI string) (models.App, error) {
+	const op = "Auth.ValidateClient"
+
+	app, err := a.appProvider.App(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+		}
+
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Redirect URIs are compared exactly, as required by OAuth 2.0 Security BCP
+	if !slices.Contains(app.RedirectURIs, redirectURI) {
+		return models.App{}, fmt.Errorf("%s: %w", op, ErrInvalidRedirectURI)
+	}
+
+	if !app.AllowsGrant(models.GrantAuthorizationCode) {
+		return models.App{}, fmt.Errorf("%s: %w", op, ErrUnauthorizedClient)
+	}
+
+	return app, nil
+}
+
+// Authorize checks the user's credentials and issues a short-lived
+// single-use authorization code bound to the client, redirect URI
+// and PKCE challenge. Only the S256 challenge method is supported.
+//
+// Any client signing tokens with an asymmetric key may request the openid
+// scope; the nonce is then echoed in the ID token issued for the code.
+// Otherwise ErrIDTokenKeyRequired is returned.
+//
+// Users with MFA enabled must also pass otp, otherwise ErrMFARequired is returned.
+func (a *Auth) Authorize(
+	ctx context.Context,
+	email string,
+	password string,
+	clientID int,
+	redirectURI string,
+	requestedScopes []string,
+	codeChallenge string,
+	nonce string,
+	otp string,
+) (string, error) {
+	const op = "Auth.Authorize"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("username", email),
+		slog.Int("app_id", clientID),
+	)
+
+	// base64url of a SHA-256 digest
+	if len(codeChallenge) != 43 {
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidPKCE)
+	}
+
+	app, err := a.ValidateClient(ctx, clientID, redirectURI)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	granted, err := grantScopes(requestedScopes, append(slices.Clone(app.Scopes), scopes.OpenID))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Refuse openid before the user logs in rather than on the code exchange
+	if slices.Contains(granted, scopes.OpenID) {
+		if _, err := a.idTokenKey(ctx, app.ID); err != nil {
+			if errors.Is(err, ErrIDTokenKeyRequired) {
+				log.Warn("openid requested without an asymmetric key")
+			}
+
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	event := models.AuditEvent{Email: email, AppID: app.ID}
+
+	user, err := a.authenticate(ctx, email, password)
+	if err != nil {
+		a.recordLogin(ctx, event, models.Tokens{}, err)
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.UserID = user.ID
+
+	if user.Disabled {
+		a.recordLogin(ctx, event, models.Tokens{}, ErrUserDisabled)
+
+		return "", fmt.Errorf("%s: %w", op, ErrUserDisabled)
+	}
+
+	if app.RequireVerifiedEmail && !user.EmailVerified {
+		a.recordLogin(ctx, event, models.Tokens{}, ErrEmailNotVerified)
+
+		return "", fmt.Errorf("%s: %w", op, ErrEmailNotVerified)
+	}
+
+	mfaEnabled, err := a.mfa.Enabled(ctx, user.ID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	amr := []string{jwt.AMRPassword}
+	if mfaEnabled {
+		if otp == "" {
+			a.recordLogin(ctx, event, models.Tokens{}, ErrMFARequired)
+
+			return "", fmt.Errorf("%s: %w", op, ErrMFARequired)
+		}
+
+		if err := a.verifySecondFactor(ctx, user.ID, otp); err != nil {
+			if errors.Is(err, ErrInvalidMFACode) {
+				a.mfaFailed(ctx, email)
+			}
+			a.recordLogin(ctx, event, models.Tokens{}, err)
+
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		amr = append(amr, jwt.AMROTP, jwt.AMRMFA)
+	}
+
+	code := rand.Text()
+	now := time.Now()
+
+	err = a.codeSaver.SaveAuthorizationCode(ctx, models.AuthorizationCode{
+		Hash:          hashCode(code),
+		AppID:         app.ID,
+		UserID:        user.ID,
+		RedirectURI:   redirectURI,
+		Scopes:        granted,
+		CodeChallenge: codeChallenge,
+		Nonce:         nonce,
+		AuthTime:      now,
+		AMR:           amr,
+		ExpiresAt:     now.Add(a.codeTTL),
+	})
+	if err != nil {
+		log.Error("failed to save authorization code", sl.Err(err))
+
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("authorization code issued")
+	a.recordLogin(ctx, event, models.Tokens{}, nil)
+	a.loginSucceeded(ctx, email)
+
+	return code, nil
+}
+
+// ExchangeCode redeems an authorization code for tokens.
+// Confidential clients must also present their secret.
+// If the user has been disabled since the code was issued, returns ErrUserDisabled.
+func (a *Auth) ExchangeCode(
+	ctx context.Context,
+	code string,
+	clientID int,
+	clientSecret string,
+	redirectURI string,
+	codeVerifier string,
+) (models.Tokens, error) {
+	const op = "Auth.ExchangeCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", clientID),
+	)
+
+	app, err := a.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !app.AllowsGrant(models.GrantAuthorizationCode) {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrUnauthorizedClient)
+	}
+
+	stored, err := a.codeSaver.ConsumeAuthorizationCode(ctx, hashCode(code))
+	if err != nil {
+		if errors.Is(err, storage.ErrAuthCodeNotFound) {
+			log.Warn("unknown or already used authorization code")
+
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.AppID != app.ID ||
+		stored.RedirectURI != redirectURI ||
+		time.Now().After(stored.ExpiresAt) ||
+		!verifyPKCE(codeVerifier, stored.CodeChallenge) {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The user may have been disabled since the code was issued
+	if user.Disabled {
+		log.Warn("user disabled", slog.Int64("uid", user.ID))
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
+	}
+
+	tokens, err := a.issueTokens(ctx, user, app, rand.Text(), stored.Scopes, stored.AMR)
+	if err != nil {
+		log.Error("failed to generate tokens", sl.Err(err))
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if slices.Contains(stored.Scopes, scopes.OpenID) {
+		tokens.IDToken, err = a.newIDToken(
+			ctx,
+			user,
+			app,
+			stored.Scopes,
+			jwt.WithNonce(stored.Nonce),
+			jwt.WithAuthTime(stored.AuthTime),
+		)
+		if err != nil {
+			log.Error("failed to generate id token", sl.Err(err))
+
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return tokens, nil
+}
+
+// RefreshGrant is the refresh_token grant of the token endpoint, see Refresh.
+// Confidential clients must also present their secret.
+func (a *Auth) RefreshGrant(
+	ctx context.Context,
+	refreshToken string,
+	clientID int,
+	clientSecret string,
+	requestedScopes []string,
+) (models.Tokens, error) {
+	const op = "Auth.RefreshGrant"
+
+	if _, err := a.authenticateClient(ctx, clientID, clientSecret); err != nil {
+		a.record(ctx, models.AuditEvent{Type: models.AuditRefresh, AppID: clientID}, err)
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.Refresh(ctx, refreshToken, clientID, requestedScopes)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// ClientCredentials issues an access token to the app itself.
+// Only confidential clients may use this grant.
+//
+// Scopes are limited to the app's client scopes, which are separate
+// from the scopes users may delegate to it. The token is restricted
+// to the requested audience, or to every audience of the app if none
+// is requested.
+func (a *Auth) ClientCredentials(
+	ctx context.Context,
+	clientID int,
+	clientSecret string,
+	requestedScopes []string,
+	audience string,
+) (models.Tokens, error) {
+	const op = "Auth.ClientCredentials"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", clientID),
+	)
+
+	if clientSecret == "" {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+	}
+
+	app, err := a.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !app.AllowsGrant(models.GrantClientCredentials) {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrUnauthorizedClient)
+	}
+
+	granted, err := grantScopes(requestedScopes, app.ClientScopes)
+	if err != nil {
+		log.Warn("requested scopes are not allowed", slog.Any("scopes", requestedScopes))
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	audiences := app.Audiences
+	if audience != "" {
+		if !slices.Contains(app.Audiences, audience) {
+			log.Warn("requested audience is not allowed", slog.String("audience", audience))
+
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrAudienceNotAllowed)
+		}
+
+		audiences = []string{audience}
+	}
+
+	key, err := a.keys.SigningKey(ctx, app.ID)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+
+	token, err := jwt.NewClientToken(
+		app,
+		key,
+		a.accessTTL(app),
+		jwt.WithScopes(granted),
+		jwt.WithAudience(audiences),
+	)
+	if err != nil {
+		log.Error("failed to generate token", sl.Err(err))
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("client token issued")
+
+	return models.Tokens{
+		AccessToken: token,
+		ExpiresAt:   now.Add(a.accessTTL(app)),
+		Scopes:      granted,
+	}, nil
+}
+
+// UserInfo returns claims about the owner of the access token.
+// The token must be active and have the openid scope.
+func (a *Auth) UserInfo(ctx context.Context, token string) (models.UserInfo, error) {
+	const op = "Auth.UserInfo"
+
+	info, err := a.ValidateToken(ctx, token)
+	if err != nil {
+		return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Client credentials tokens have no user
+	if !info.Active || info.UserID == 0 {
+		return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if !slices.Contains(info.Scopes, scopes.OpenID) {
+		return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrInsufficientScope)
+	}
+
+	user, err := a.usrProvider.UserByID(ctx, info.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res := models.UserInfo{UserID: user.ID}
+	if slices.Contains(info.Scopes, scopes.Email) {
+		res.Email = user.Email
+	}
+
+	return res, nil
+}
+
+// idTokenKey returns the key ID tokens of the app are signed with.
+// Relying parties verify ID tokens against the JWKS, so the key must be
+// asymmetric; otherwise ErrIDTokenKeyRequired is returned.
+func (a *Auth) idTokenKey(ctx context.Context, appID int) (models.SigningKey, error) {
+	key, err := a.keys.SigningKey(ctx, appID)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	if key.ID == "" || key.Algorithm == jwt.AlgHS256 {
+		return models.SigningKey{}, ErrIDTokenKeyRequired
+	}
+
+	return key, nil
+}
+
+// newIDToken signs an ID token with the app's current asymmetric key.
+// The email claim is included only if the email scope is granted.
+func (a *Auth) newIDToken(
+	ctx context.Context,
+	user models.User,
+	app models.App,
+	granted []string,
+	opts ...jwt.TokenOption,
+) (string, error) {
+	key, err := a.idTokenKey(ctx, app.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if slices.Contains(granted, scopes.Email) {
+		opts = append(opts, jwt.WithEmail(user.Email))
+	}
+
+	return jwt.NewIDToken(user, app, key, a.issuer, a.accessTTL(app), opts...)
+}
+
+// authenticateClient loads the app and checks its secret.
+// Public clients (without a secret) must not send one.
+func (a *Auth) authenticateClient(ctx context.Context, clientID int, clientSecret string) (models.App, error) {
+	app, err := a.appProvider.App(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.App{}, ErrInvalidClient
+		}
+
+		return models.App{}, err
+	}
+
+	if len(app.ClientSecretHash) == 0 {
+		if clientSecret != "" {
+			return models.App{}, ErrInvalidClient
+		}
+
+		return app, nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword(app.ClientSecretHash, []byte(clientSecret)); err != nil {
+		return models.App{}, ErrInvalidClient
+	}
+
+	return app, nil
+}
+
+// verifyPKCE checks the code verifier against an S256 challenge (RFC 7636).
+func verifyPKCE(verifier string, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
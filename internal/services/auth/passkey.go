@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/services/passkey"
+)
+
+var ErrInvalidPasskey = errors.New("invalid passkey")
+
+// PasskeyVerifier checks WebAuthn assertions.
+type PasskeyVerifier interface {
+	VerifyAssertion(ctx context.Context, assertion models.PasskeyAssertion) (models.User, bool, error)
+}
+
+// LoginWithPasskey logs the user in with a WebAuthn assertion instead of a password.
+// A passkey with user verification counts as both factors,
+// otherwise users with MFA enabled still have to pass VerifyMFA.
+func (a *Auth) LoginWithPasskey(
+	ctx context.Context,
+	assertion models.PasskeyAssertion,
+	appID int,
+	requestedScopes []string,
+) (models.Tokens, error) {
+	const op = "Auth.LoginWithPasskey"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	log.Info("attempting to login user with passkey")
+
+	event := models.AuditEvent{AppID: appID}
+
+	user, userVerified, err := a.passkeys.VerifyAssertion(ctx, assertion)
+	if err != nil {
+		if errors.Is(err, passkey.ErrInvalidResponse) || errors.Is(err, passkey.ErrSignCountRegression) {
+			a.recordLogin(ctx, event, models.Tokens{}, ErrInvalidPasskey)
+
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+		}
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	event.UserID = user.ID
+	event.Email = user.Email
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		a.recordLogin(ctx, event, models.Tokens{}, err)
+
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	amr := []string{jwt.AMRHardwareKey}
+	if userVerified {
+		amr = append(amr, jwt.AMRMFA)
+	}
+
+	tokens, err := a.startSession(ctx, log.With(slog.Int64("uid", user.ID)), user, app, requestedScopes, amr)
+	a.recordLogin(ctx, event, tokens, err)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
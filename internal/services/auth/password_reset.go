@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mailer"
+	"sso/internal/storage"
+	"time"
+)
+
+var ErrInvalidResetToken = errors.New("invalid password reset token")
+
+type ResetTokenStore interface {
+	SaveEmailToken(ctx context.Context, token models.EmailToken) error
+	EmailToken(ctx context.Context, id string) (models.EmailToken, error)
+	// ResetPassword consumes the token and updates the password atomically
+	ResetPassword(ctx context.Context, tokenID string, userID int64, passHash []byte) error
+	DeleteUserEmailTokens(ctx context.Context, userID int64, purpose string) error
+}
+
+// Notifier delivers messages to users, e.g. by email.
+type Notifier interface {
+	Send(ctx context.Context, msg mailer.Message) error
+}
+
+// RequestPasswordReset sends a one-time password reset link to the email
+// in the background. Unknown emails are silently ignored and delivery errors
+// are only logged, so the result doesn't reveal which emails are registered.
+// Links sent earlier stop working.
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) error {
+	const op = "Auth.RequestPasswordReset"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	user, err := a.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("password reset requested for unknown email")
+
+			return nil
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Mailing takes much longer than ignoring an unknown email,
+	// it is done in the background not to reveal which emails are registered
+	go a.sendPasswordReset(context.WithoutCancel(ctx), log.With(slog.Int64("uid", user.ID)), user)
+
+	return nil
+}
+
+// sendPasswordReset replaces the reset links of the user with a new one
+// and mails it. Errors are only logged, the user can ask again.
+func (a *Auth) sendPasswordReset(ctx context.Context, log *slog.Logger, user models.User) {
+	if err := a.resetTokens.DeleteUserEmailTokens(ctx, user.ID, models.EmailTokenPasswordReset); err != nil {
+		log.Error("failed to delete password reset tokens", sl.Err(err))
+
+		return
+	}
+
+	token := rand.Text()
+
+	err := a.resetTokens.SaveEmailToken(ctx, models.EmailToken{
+		ID:        hashCode(token),
+		UserID:    user.ID,
+		Purpose:   models.EmailTokenPasswordReset,
+		ExpiresAt: time.Now().Add(a.resetTTL),
+	})
+	if err != nil {
+		log.Error("failed to save password reset token", sl.Err(err))
+
+		return
+	}
+
+	link, err := url.Parse(a.resetURL)
+	if err != nil {
+		log.Error("invalid password reset url", sl.Err(err))
+
+		return
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	err = a.notifier.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Open the link below to choose a new password:\n\n%s\n\n"+
+				"The link expires in %s. If you didn't ask to reset your password, ignore this email.\n",
+			link, a.resetTTL,
+		),
+	})
+	if err != nil {
+		log.Error("failed to send password reset email", sl.Err(err))
+
+		return
+	}
+
+	log.Info("password reset email sent")
+}
+
+// ResetPassword sets a new password with a token from RequestPasswordReset
+// and ends all sessions of the user. newPassword must meet the
+// policy of appID, 0 if the reset isn't made from an app. The token stays
+// valid if the password is rejected.
+func (a *Auth) ResetPassword(ctx context.Context, token string, newPassword string, appID int) error {
+	const op = "Auth.ResetPassword"
+
+	log := a.log.With(slog.String("op", op))
+
+	stored, err := a.resetTokens.EmailToken(ctx, hashCode(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrEmailTokenNotFound) {
+			log.Info("unknown or used password reset token")
+
+			return fmt.Errorf("%s: %w", op, ErrInvalidResetToken)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.Purpose != models.EmailTokenPasswordReset || time.Now().After(stored.ExpiresAt) {
+		return fmt.Errorf("%s: %w", op, ErrInvalidResetToken)
+	}
+
+	log = log.With(slog.Int64("uid", stored.UserID))
+
+	user, err := a.usrProvider.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidResetToken)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.passwords.Validate(appID, user.Email, newPassword); err != nil {
+		log.Info("new password rejected", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.hasher.Hash(newPassword)
+	if err != nil {
+		log.Error("failed to generate password hash", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The token is consumed together with the password update: it stays usable
+	// if anything before fails, and only one of concurrent requests gets through
+	if err := a.resetTokens.ResetPassword(ctx, stored.ID, stored.UserID, passHash); err != nil {
+		if errors.Is(err, storage.ErrEmailTokenNotFound) || errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidResetToken)
+		}
+
+		log.Error("failed to reset password", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := a.rtSaver.RevokeUserRefreshTokens(ctx, stored.UserID)
+	if err != nil {
+		log.Error("failed to revoke user refresh tokens", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	a.revokeSessions(sessions)
+
+	log.Info("password reset")
+
+	return nil
+}
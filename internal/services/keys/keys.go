@@ -0,0 +1,329 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sync"
+	"time"
+)
+
+var ErrNoActiveKey = errors.New("app has no active signing key")
+
+type KeySaver interface {
+	SaveSigningKey(ctx context.Context, key models.SigningKey) error
+	ActivateSigningKey(ctx context.Context, appID int, id string) error
+	UpdateSigningKeyState(ctx context.Context, id string, state models.KeyState) error
+}
+
+type KeyProvider interface {
+	// SigningKeys returns keys of the app, newest first.
+	SigningKeys(ctx context.Context, appID int) ([]models.SigningKey, error)
+	AllSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+}
+
+type Keys struct {
+	log         *slog.Logger
+	keySaver    KeySaver
+	keyProvider KeyProvider
+	algorithm   string
+	algorithms  []string
+	allowHMAC   bool // HS256 is explicitly accepted
+	tokenTTL    time.Duration
+
+	// mu serializes key ring changes within the process
+	mu sync.Mutex
+}
+
+// New creates the key service.
+//
+// algorithm is used for newly generated keys. algorithms is the list
+// accepted on verification; if empty, only algorithm is accepted.
+// Unless algorithms lists HS256, app secrets stop verifying tokens
+// once the app has an active asymmetric key.
+// Retiring keys are kept for tokenTTL, or the app's longer one, after rotation.
+func New(
+	log *slog.Logger,
+	keySaver KeySaver,
+	keyProvider KeyProvider,
+	algorithm string,
+	algorithms []string,
+	tokenTTL time.Duration,
+) *Keys {
+	allowHMAC := slices.Contains(algorithms, jwt.AlgHS256)
+	if len(algorithms) == 0 {
+		algorithms = []string{algorithm}
+	}
+
+	return &Keys{
+		log:         log,
+		keySaver:    keySaver,
+		keyProvider: keyProvider,
+		algorithm:   algorithm,
+		algorithms:  algorithms,
+		allowHMAC:   allowHMAC,
+		tokenTTL:    tokenTTL,
+	}
+}
+
+// Algorithms returns the signing algorithms accepted on verification.
+func (k *Keys) Algorithms() []string {
+	return k.algorithms
+}
+
+// SigningKey returns the active key new access tokens of the app are signed with.
+//
+// If the app has no keys and the service is configured for an asymmetric
+// algorithm, the first key is generated. For HS256 an empty key is returned
+// instead and tokens are signed with the app secret until a key is rotated in.
+func (k *Keys) SigningKey(ctx context.Context, appID int) (models.SigningKey, error) {
+	const op = "Keys.SigningKey"
+
+	key, err := k.activeKey(ctx, appID)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, ErrNoActiveKey) {
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if k.algorithm == jwt.AlgHS256 {
+		return models.SigningKey{}, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// Another request may have generated the key while we were waiting
+	key, err = k.activeKey(ctx, appID)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, ErrNoActiveKey) {
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err = k.newKey(ctx, appID, models.KeyStateActive)
+	if err != nil {
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// VerificationKeys returns the keys tokens of the app may be signed with:
+// the active key and keys retiring after rotation.
+func (k *Keys) VerificationKeys(ctx context.Context, appID int) ([]models.SigningKey, error) {
+	const op = "Keys.VerificationKeys"
+
+	keys, err := k.keyProvider.SigningKeys(ctx, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return slices.DeleteFunc(keys, func(key models.SigningKey) bool {
+		return key.State != models.KeyStateActive && key.State != models.KeyStateRetiring
+	}), nil
+}
+
+// Keyring returns everything tokens of the app are verified with.
+// The app secret is included only for tokens signed before the app got
+// an active asymmetric key, or if HS256 is explicitly accepted.
+func (k *Keys) Keyring(ctx context.Context, app models.App) (jwt.Keyring, error) {
+	const op = "Keys.Keyring"
+
+	keys, err := k.VerificationKeys(ctx, app.ID)
+	if err != nil {
+		return jwt.Keyring{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keyring := jwt.Keyring{Secret: app.Secret, Keys: keys}
+
+	asymmetric := slices.ContainsFunc(keys, func(key models.SigningKey) bool {
+		return key.State == models.KeyStateActive && key.Algorithm != jwt.AlgHS256
+	})
+	if asymmetric && !k.allowHMAC {
+		keyring.Secret = ""
+	}
+
+	return keyring, nil
+}
+
+// JWKS returns the public keys of the app as a JSON Web Key Set.
+// Pending keys are included so verifiers learn them before rotation.
+// If appID is 0, keys of all apps are returned.
+func (k *Keys) JWKS(ctx context.Context, appID int) (jwt.JWKS, error) {
+	const op = "Keys.JWKS"
+
+	var (
+		keys []models.SigningKey
+		err  error
+	)
+	if appID == 0 {
+		keys, err = k.keyProvider.AllSigningKeys(ctx)
+	} else {
+		keys, err = k.keyProvider.SigningKeys(ctx, appID)
+	}
+	if err != nil {
+		return jwt.JWKS{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	set := jwt.JWKS{Keys: make([]jwt.JWK, 0, len(keys))}
+	for _, key := range keys {
+		// Symmetric keys are secret
+		if key.State == models.KeyStateRevoked || key.Algorithm == jwt.AlgHS256 {
+			continue
+		}
+
+		jwk, err := jwt.PublicJWK(key)
+		if err != nil {
+			return jwt.JWKS{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+// PrepareKey generates a pending key to be activated by the next rotation.
+func (k *Keys) PrepareKey(ctx context.Context, appID int) (models.SigningKey, error) {
+	const op = "Keys.PrepareKey"
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, err := k.newKey(ctx, appID, models.KeyStatePending)
+	if err != nil {
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// RotateKey activates the newest pending key of the app, generating one if
+// there is none. The previously active key starts retiring: it keeps verifying
+// tokens until they expire and is revoked by a later rotation.
+func (k *Keys) RotateKey(ctx context.Context, app models.App) (models.SigningKey, error) {
+	const op = "Keys.RotateKey"
+
+	appID := app.ID
+
+	log := k.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys, err := k.keyProvider.SigningKeys(ctx, appID)
+	if err != nil {
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Tokens signed with keys retiring for longer than the token TTL have expired,
+	// the app may issue tokens living longer than the default
+	tokenTTL := max(k.tokenTTL, app.AccessTokenTTL)
+	for _, key := range keys {
+		if key.State == models.KeyStateRetiring && time.Since(key.UpdatedAt) > tokenTTL {
+			if err := k.keySaver.UpdateSigningKeyState(ctx, key.ID, models.KeyStateRevoked); err != nil {
+				return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+			}
+
+			log.Info("revoked retired signing key", slog.String("kid", key.ID))
+		}
+	}
+
+	i := slices.IndexFunc(keys, func(key models.SigningKey) bool {
+		return key.State == models.KeyStatePending
+	})
+
+	var next models.SigningKey
+	if i >= 0 {
+		next = keys[i]
+	} else {
+		next, err = k.newKey(ctx, appID, models.KeyStatePending)
+		if err != nil {
+			return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := k.keySaver.ActivateSigningKey(ctx, appID, next.ID); err != nil {
+		log.Error("failed to activate signing key", sl.Err(err))
+
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	next.State = models.KeyStateActive
+
+	log.Info("rotated signing key", slog.String("kid", next.ID), slog.String("alg", next.Algorithm))
+
+	return next, nil
+}
+
+// RevokeKey immediately stops the key from signing and verifying tokens.
+func (k *Keys) RevokeKey(ctx context.Context, id string) error {
+	const op = "Keys.RevokeKey"
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.keySaver.UpdateSigningKeyState(ctx, id, models.KeyStateRevoked); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	k.log.Info("revoked signing key", slog.String("op", op), slog.String("kid", id))
+
+	return nil
+}
+
+func (k *Keys) activeKey(ctx context.Context, appID int) (models.SigningKey, error) {
+	keys, err := k.keyProvider.SigningKeys(ctx, appID)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	i := slices.IndexFunc(keys, func(key models.SigningKey) bool {
+		return key.State == models.KeyStateActive
+	})
+	if i < 0 {
+		return models.SigningKey{}, ErrNoActiveKey
+	}
+
+	return keys[i], nil
+}
+
+func (k *Keys) newKey(ctx context.Context, appID int, state models.KeyState) (models.SigningKey, error) {
+	log := k.log.With(
+		slog.Int("app_id", appID),
+	)
+
+	key, err := jwt.GenerateSigningKey(appID, k.algorithm)
+	if err != nil {
+		log.Error("failed to generate signing key", sl.Err(err))
+
+		return models.SigningKey{}, err
+	}
+
+	key.State = state
+
+	if err := k.keySaver.SaveSigningKey(ctx, key); err != nil {
+		log.Error("failed to save signing key", sl.Err(err))
+
+		return models.SigningKey{}, err
+	}
+
+	log.Info("generated signing key",
+		slog.String("kid", key.ID),
+		slog.String("alg", key.Algorithm),
+		slog.String("state", string(key.State)),
+	)
+
+	return key, nil
+}
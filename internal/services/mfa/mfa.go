@@ -0,0 +1,279 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/totp"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+const recoveryCodesCount = 10
+
+var (
+	ErrNotEnrolled    = errors.New("mfa is not enabled")
+	ErrAlreadyEnabled = errors.New("mfa is already enabled")
+	ErrInvalidCode    = errors.New("invalid code")
+)
+
+type TOTPSaver interface {
+	SaveTOTP(ctx context.Context, userID int64, secret []byte) error
+	ConfirmTOTP(ctx context.Context, userID int64, step int64, recoveryCodes [][]byte) error
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int64, hash []byte) error
+	DeleteTOTP(ctx context.Context, userID int64) error
+}
+
+type TOTPProvider interface {
+	TOTP(ctx context.Context, userID int64) (models.TOTP, error)
+}
+
+type UserProvider interface {
+	UserByID(ctx context.Context, id int64) (models.User, error)
+}
+
+// Cipher encrypts TOTP secrets at rest.
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// MFA manages TOTP second factor enrollment and verification.
+type MFA struct {
+	log          *slog.Logger
+	totpSaver    TOTPSaver
+	totpProvider TOTPProvider
+	usrProvider  UserProvider
+	cipher       Cipher
+	issuer       string // shown in authenticator apps
+}
+
+func New(
+	log *slog.Logger,
+	totpSaver TOTPSaver,
+	totpProvider TOTPProvider,
+	userProvider UserProvider,
+	cipher Cipher,
+	issuer string,
+) *MFA {
+	return &MFA{
+		log:          log,
+		totpSaver:    totpSaver,
+		totpProvider: totpProvider,
+		usrProvider:  userProvider,
+		cipher:       cipher,
+		issuer:       issuer,
+	}
+}
+
+// Enroll generates a new TOTP secret for the user. MFA is not enabled
+// until the user confirms the enrollment with a code from the app.
+// Returns the base32 secret and the otpauth:// URI.
+func (m *MFA) Enroll(ctx context.Context, userID int64) (string, string, error) {
+	const op = "MFA.Enroll"
+
+	log := m.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	// A confirmed enrollment can only be replaced after disabling it with a code,
+	// so a stolen access token is not enough to take over the second factor
+	existing, err := m.totpProvider.TOTP(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrTOTPNotFound) {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err == nil && existing.Confirmed {
+		return "", "", fmt.Errorf("%s: %w", op, ErrAlreadyEnabled)
+	}
+
+	user, err := m.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	encrypted, err := m.cipher.Encrypt(secret)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := m.totpSaver.SaveTOTP(ctx, userID, encrypted); err != nil {
+		log.Error("failed to save totp secret", sl.Err(err))
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("totp enrollment started")
+
+	return totp.Encode(secret), totp.URI(m.issuer, user.Email, secret), nil
+}
+
+// Confirm enables MFA if the code matches the pending enrollment
+// and returns single-use recovery codes. They are shown only once.
+func (m *MFA) Confirm(ctx context.Context, userID int64, code string) ([]string, error) {
+	const op = "MFA.Confirm"
+
+	log := m.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	enrollment, err := m.enrollment(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if enrollment.Confirmed {
+		return nil, fmt.Errorf("%s: %w", op, ErrAlreadyEnabled)
+	}
+
+	secret, err := m.cipher.Decrypt(enrollment.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidCode)
+	}
+
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([][]byte, recoveryCodesCount)
+	for i := range codes {
+		raw := rand.Text()[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := m.totpSaver.ConfirmTOTP(ctx, userID, step, hashes); err != nil {
+		if errors.Is(err, storage.ErrTOTPStepUsed) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidCode)
+		}
+
+		log.Error("failed to confirm totp", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("mfa enabled")
+
+	return codes, nil
+}
+
+// Enabled reports whether the user has confirmed a second factor.
+func (m *MFA) Enabled(ctx context.Context, userID int64) (bool, error) {
+	const op = "MFA.Enabled"
+
+	enrollment, err := m.totpProvider.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return enrollment.Confirmed, nil
+}
+
+// Verify checks a TOTP code or a recovery code.
+// Every code is accepted only once.
+func (m *MFA) Verify(ctx context.Context, userID int64, code string) error {
+	const op = "MFA.Verify"
+
+	log := m.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	enrollment, err := m.enrollment(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !enrollment.Confirmed {
+		return fmt.Errorf("%s: %w", op, ErrNotEnrolled)
+	}
+
+	secret, err := m.cipher.Decrypt(enrollment.Secret)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		if err := m.totpSaver.UseTOTPStep(ctx, userID, step); err != nil {
+			if errors.Is(err, storage.ErrTOTPStepUsed) {
+				log.Warn("totp code replay")
+
+				return fmt.Errorf("%s: %w", op, ErrInvalidCode)
+			}
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		return nil
+	}
+
+	if err := m.totpSaver.UseRecoveryCode(ctx, userID, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+			log.Info("invalid mfa code")
+
+			return fmt.Errorf("%s: %w", op, ErrInvalidCode)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Warn("recovery code used")
+
+	return nil
+}
+
+// Disable turns MFA off. A valid code is required, so that
+// an access token alone is not enough to remove the second factor.
+func (m *MFA) Disable(ctx context.Context, userID int64, code string) error {
+	const op = "MFA.Disable"
+
+	if err := m.Verify(ctx, userID, code); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := m.totpSaver.DeleteTOTP(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	m.log.Info("mfa disabled", slog.String("op", op), slog.Int64("uid", userID))
+
+	return nil
+}
+
+func (m *MFA) enrollment(ctx context.Context, userID int64) (models.TOTP, error) {
+	enrollment, err := m.totpProvider.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return models.TOTP{}, ErrNotEnrolled
+		}
+
+		return models.TOTP{}, err
+	}
+
+	return enrollment, nil
+}
+
+// hashRecoveryCode ignores dashes and case, so codes may be typed loosely.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+
+	return sum[:]
+}
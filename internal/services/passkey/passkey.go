@@ -0,0 +1,296 @@
+package passkey
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/webauthn"
+	"sso/internal/storage"
+	"time"
+)
+
+var (
+	ErrInvalidResponse     = errors.New("invalid passkey response")
+	ErrAlreadyRegistered   = errors.New("passkey already registered")
+	ErrSignCountRegression = errors.New("passkey sign count regression")
+)
+
+type PasskeySaver interface {
+	SavePasskey(ctx context.Context, passkey models.Passkey) error
+	UpdatePasskeySignCount(ctx context.Context, id []byte, signCount uint32) error
+}
+
+type PasskeyProvider interface {
+	Passkey(ctx context.Context, id []byte) (models.Passkey, error)
+	UserPasskeys(ctx context.Context, userID int64) ([]models.Passkey, error)
+}
+
+type ChallengeStore interface {
+	SaveWebAuthnChallenge(ctx context.Context, challenge models.WebAuthnChallenge) error
+	ConsumeWebAuthnChallenge(ctx context.Context, challenge string) (models.WebAuthnChallenge, error)
+}
+
+type UserProvider interface {
+	UserByID(ctx context.Context, id int64) (models.User, error)
+}
+
+// Passkey runs WebAuthn registration and authentication ceremonies.
+type Passkey struct {
+	log             *slog.Logger
+	passkeySaver    PasskeySaver
+	passkeyProvider PasskeyProvider
+	challenges      ChallengeStore
+	usrProvider     UserProvider
+	rp              webauthn.RelyingParty
+	challengeTTL    time.Duration
+}
+
+func New(
+	log *slog.Logger,
+	passkeySaver PasskeySaver,
+	passkeyProvider PasskeyProvider,
+	challenges ChallengeStore,
+	userProvider UserProvider,
+	rp webauthn.RelyingParty,
+	challengeTTL time.Duration,
+) *Passkey {
+	return &Passkey{
+		log:             log,
+		passkeySaver:    passkeySaver,
+		passkeyProvider: passkeyProvider,
+		challenges:      challenges,
+		usrProvider:     userProvider,
+		rp:              rp,
+		challengeTTL:    challengeTTL,
+	}
+}
+
+// BeginRegistration returns options for navigator.credentials.create as JSON.
+func (p *Passkey) BeginRegistration(ctx context.Context, userID int64) ([]byte, error) {
+	const op = "Passkey.BeginRegistration"
+
+	user, err := p.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	registered, err := p.passkeyProvider.UserPasskeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	existing := make([]webauthn.ExistingCredential, 0, len(registered))
+	for _, passkey := range registered {
+		existing = append(existing, webauthn.ExistingCredential{ID: passkey.ID, Transports: passkey.Transports})
+	}
+
+	challenge, err := p.newChallenge(ctx, userID, models.WebAuthnRegistration)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	options := p.rp.CreationOptions(challenge, userHandle(userID), user.Email, existing, p.challengeTTL.Milliseconds())
+
+	res, err := json.Marshal(options)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return res, nil
+}
+
+// FinishRegistration verifies the response of navigator.credentials.create
+// and stores the new passkey.
+func (p *Passkey) FinishRegistration(
+	ctx context.Context,
+	userID int64,
+	clientDataJSON []byte,
+	attestationObject []byte,
+	transports []string,
+) (models.Passkey, error) {
+	const op = "Passkey.FinishRegistration"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	clientData, err := p.rp.ParseClientData(clientDataJSON, webauthn.TypeCreate)
+	if err != nil {
+		log.Warn("invalid client data", sl.Err(err))
+
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, ErrInvalidResponse)
+	}
+
+	challenge, err := p.consumeChallenge(ctx, clientData.Challenge, models.WebAuthnRegistration)
+	if err != nil {
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if challenge.UserID != userID {
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, ErrInvalidResponse)
+	}
+
+	credential, err := p.rp.VerifyRegistration(attestationObject, false)
+	if err != nil {
+		log.Warn("invalid attestation", sl.Err(err))
+
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, ErrInvalidResponse)
+	}
+
+	passkey := models.Passkey{
+		ID:         credential.ID,
+		UserID:     userID,
+		PublicKey:  credential.PublicKey,
+		Algorithm:  credential.Algorithm,
+		SignCount:  credential.SignCount,
+		Transports: transports,
+		CreatedAt:  time.Now(),
+	}
+
+	if err := p.passkeySaver.SavePasskey(ctx, passkey); err != nil {
+		if errors.Is(err, storage.ErrPasskeyExists) {
+			return models.Passkey{}, fmt.Errorf("%s: %w", op, ErrAlreadyRegistered)
+		}
+
+		log.Error("failed to save passkey", sl.Err(err))
+
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey registered", slog.String("algorithm", passkey.Algorithm))
+
+	return passkey, nil
+}
+
+// BeginLogin returns options for navigator.credentials.get as JSON.
+func (p *Passkey) BeginLogin(ctx context.Context) ([]byte, error) {
+	const op = "Passkey.BeginLogin"
+
+	challenge, err := p.newChallenge(ctx, 0, models.WebAuthnLogin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := json.Marshal(p.rp.RequestOptions(challenge, p.challengeTTL.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return res, nil
+}
+
+// VerifyAssertion verifies the response of navigator.credentials.get
+// and returns the passkey owner and whether the authenticator verified the user.
+func (p *Passkey) VerifyAssertion(ctx context.Context, assertion models.PasskeyAssertion) (models.User, bool, error) {
+	const op = "Passkey.VerifyAssertion"
+
+	log := p.log.With(slog.String("op", op))
+
+	clientData, err := p.rp.ParseClientData(assertion.ClientDataJSON, webauthn.TypeGet)
+	if err != nil {
+		log.Warn("invalid client data", sl.Err(err))
+
+		return models.User{}, false, fmt.Errorf("%s: %w", op, ErrInvalidResponse)
+	}
+
+	if _, err := p.consumeChallenge(ctx, clientData.Challenge, models.WebAuthnLogin); err != nil {
+		return models.User{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passkey, err := p.passkeyProvider.Passkey(ctx, assertion.CredentialID)
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyNotFound) {
+			log.Warn("unknown passkey")
+
+			return models.User{}, false, fmt.Errorf("%s: %w", op, ErrInvalidResponse)
+		}
+
+		return models.User{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", passkey.UserID))
+
+	if len(assertion.UserHandle) > 0 && string(assertion.UserHandle) != string(userHandle(passkey.UserID)) {
+		log.Warn("user handle mismatch")
+
+		return models.User{}, false, fmt.Errorf("%s: %w", op, ErrInvalidResponse)
+	}
+
+	verified, err := p.rp.VerifyAssertion(
+		assertion.ClientDataJSON,
+		assertion.AuthenticatorData,
+		assertion.Signature,
+		passkey.PublicKey,
+		passkey.Algorithm,
+		false,
+	)
+	if err != nil {
+		log.Warn("invalid assertion", sl.Err(err))
+
+		return models.User{}, false, fmt.Errorf("%s: %w", op, ErrInvalidResponse)
+	}
+
+	if err := p.passkeySaver.UpdatePasskeySignCount(ctx, passkey.ID, verified.SignCount); err != nil {
+		if errors.Is(err, storage.ErrSignCountRegression) {
+			log.Error("passkey sign count regression, the authenticator may be cloned",
+				slog.Uint64("stored", uint64(passkey.SignCount)),
+				slog.Uint64("received", uint64(verified.SignCount)),
+			)
+
+			return models.User{}, false, fmt.Errorf("%s: %w", op, ErrSignCountRegression)
+		}
+
+		return models.User{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := p.usrProvider.UserByID(ctx, passkey.UserID)
+	if err != nil {
+		return models.User{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, verified.UserVerified, nil
+}
+
+func (p *Passkey) newChallenge(ctx context.Context, userID int64, purpose string) (string, error) {
+	challenge := webauthn.NewChallenge()
+
+	err := p.challenges.SaveWebAuthnChallenge(ctx, models.WebAuthnChallenge{
+		Challenge: challenge,
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(p.challengeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+func (p *Passkey) consumeChallenge(ctx context.Context, value string, purpose string) (models.WebAuthnChallenge, error) {
+	challenge, err := p.challenges.ConsumeWebAuthnChallenge(ctx, value)
+	if err != nil {
+		if errors.Is(err, storage.ErrChallengeNotFound) {
+			return models.WebAuthnChallenge{}, ErrInvalidResponse
+		}
+
+		return models.WebAuthnChallenge{}, err
+	}
+
+	if challenge.Purpose != purpose || time.Now().After(challenge.ExpiresAt) {
+		return models.WebAuthnChallenge{}, ErrInvalidResponse
+	}
+
+	return challenge, nil
+}
+
+// userHandle is the WebAuthn user ID. It must not contain personal data,
+// so it is derived from the user ID only.
+func userHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
@@ -0,0 +1,147 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+)
+
+type RoleSaver interface {
+	SaveUserRole(ctx context.Context, userID int64, appID int, role string) error
+	DeleteUserRole(ctx context.Context, userID int64, appID int, role string) error
+	SetUserRoles(ctx context.Context, userID int64, appID int, roles []string) error
+}
+
+type RoleProvider interface {
+	UserRoles(ctx context.Context, userID int64, appID int) ([]string, error)
+	UserHasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error)
+}
+
+// RBAC manages per-app roles of users and the permissions they grant.
+type RBAC struct {
+	log          *slog.Logger
+	roleSaver    RoleSaver
+	roleProvider RoleProvider
+}
+
+func New(
+	log *slog.Logger,
+	roleSaver RoleSaver,
+	roleProvider RoleProvider,
+) *RBAC {
+	return &RBAC{
+		log:          log,
+		roleSaver:    roleSaver,
+		roleProvider: roleProvider,
+	}
+}
+
+func (r *RBAC) AssignRole(ctx context.Context, userID int64, appID int, role string) error {
+	const op = "RBAC.AssignRole"
+
+	log := r.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+		slog.Int("app_id", appID),
+		slog.String("role", role),
+	)
+
+	if err := r.roleSaver.SaveUserRole(ctx, userID, appID, role); err != nil {
+		log.Error("failed to assign role", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("role assigned")
+
+	return nil
+}
+
+func (r *RBAC) RevokeRole(ctx context.Context, userID int64, appID int, role string) error {
+	const op = "RBAC.RevokeRole"
+
+	log := r.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+		slog.Int("app_id", appID),
+		slog.String("role", role),
+	)
+
+	if err := r.roleSaver.DeleteUserRole(ctx, userID, appID, role); err != nil {
+		log.Warn("failed to revoke role", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("role revoked")
+
+	return nil
+}
+
+// SetRoles replaces all roles of the user in the app.
+func (r *RBAC) SetRoles(ctx context.Context, userID int64, appID int, roles []string) error {
+	const op = "RBAC.SetRoles"
+
+	log := r.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+		slog.Int("app_id", appID),
+		slog.Any("roles", roles),
+	)
+
+	if err := r.roleSaver.SetUserRoles(ctx, userID, appID, roles); err != nil {
+		log.Error("failed to set roles", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("roles set")
+
+	return nil
+}
+
+func (r *RBAC) UserRoles(ctx context.Context, userID int64, appID int) ([]string, error) {
+	const op = "RBAC.UserRoles"
+
+	roles, err := r.roleProvider.UserRoles(ctx, userID, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+func (r *RBAC) IsAdmin(ctx context.Context, userID int64, appID int) (bool, error) {
+	const op = "RBAC.IsAdmin"
+
+	roles, err := r.roleProvider.UserRoles(ctx, userID, appID)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return slices.Contains(roles, models.RoleAdmin), nil
+}
+
+// HasPermission reports whether the user is granted the permission in the app.
+// Admins are granted every permission.
+func (r *RBAC) HasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error) {
+	const op = "RBAC.HasPermission"
+
+	isAdmin, err := r.IsAdmin(ctx, userID, appID)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	if isAdmin {
+		return true, nil
+	}
+
+	ok, err := r.roleProvider.UserHasPermission(ctx, userID, appID, permission)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ok, nil
+}
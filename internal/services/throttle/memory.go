@@ -0,0 +1,101 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type counter struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// Memory is a Store for a single replica. Counters are lost on restart.
+type Memory struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{counters: make(map[string]*counter)}
+}
+
+func (m *Memory) RecordLoginFailure(_ context.Context, key string, since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		m.sweep(since, now)
+	}
+
+	c, ok := m.counters[key]
+	if !ok {
+		c = &counter{}
+		m.counters[key] = c
+	}
+	if c.lastFailure.Before(since) {
+		c.failures = 0
+	}
+
+	c.failures++
+	c.lastFailure = now
+
+	return c.failures, nil
+}
+
+func (m *Memory) BlockLogin(_ context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.counters[key]; ok {
+		c.blockedUntil = until
+	}
+
+	return nil
+}
+
+func (m *Memory) LoginBlockedUntil(_ context.Context, key string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.counters[key]; ok {
+		return c.blockedUntil, nil
+	}
+
+	return time.Time{}, nil
+}
+
+func (m *Memory) ResetLoginFailures(_ context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.counters, key)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *Memory) DeleteStaleLoginFailures(_ context.Context, since time.Time) error {
+	m.mu.Lock()
+	m.sweep(since, time.Now())
+	m.mu.Unlock()
+
+	return nil
+}
+
+// How often RecordLoginFailure sweeps all counters
+const sweepInterval = time.Minute
+
+// sweep removes counters that no longer count or block,
+// so addresses that failed once don't pile up between cleanups.
+func (m *Memory) sweep(since time.Time, now time.Time) {
+	m.lastSweep = now
+
+	for key, c := range m.counters {
+		if c.lastFailure.Before(since) && c.blockedUntil.Before(now) {
+			delete(m.counters, key)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"strings"
+	"time"
+)
+
+var ErrThrottled = errors.New("too many failed login attempts")
+
+// RetryError is returned while logins are throttled.
+type RetryError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrThrottled, e.RetryAfter)
+}
+
+func (e *RetryError) Unwrap() error {
+	return ErrThrottled
+}
+
+// Store keeps failed login counters. It must be shared by all replicas
+// for the limits to hold across them.
+type Store interface {
+	// RecordLoginFailure increments the failure counter of the key and returns it.
+	// Failures before since are forgotten.
+	RecordLoginFailure(ctx context.Context, key string, since time.Time) (int, error)
+	BlockLogin(ctx context.Context, key string, until time.Time) error
+	// LoginBlockedUntil returns zero time if the key is not blocked.
+	LoginBlockedUntil(ctx context.Context, key string) (time.Time, error)
+	ResetLoginFailures(ctx context.Context, key string) error
+	// DeleteStaleLoginFailures removes counters that neither count nor block.
+	DeleteStaleLoginFailures(ctx context.Context, since time.Time) error
+}
+
+// Auditor records security events, see models.AuditEvent.
+type Auditor interface {
+	Record(ctx context.Context, event models.AuditEvent)
+}
+
+// Policy configures throttling. Zero FreeAttempts or IPFreeAttempts
+// disable backoff by that key, zero LockoutAfter disables lockout.
+type Policy struct {
+	FreeAttempts    int // failures per account before backoff starts
+	IPFreeAttempts  int // failures per client address before backoff starts
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int // failures that lock the account for LockoutDuration
+	LockoutDuration time.Duration
+	Window          time.Duration // failures older than this are forgotten
+}
+
+// Throttle slows down password guessing: after a few failures, logins
+// by the account or the client address are blocked for exponentially
+// growing delays, and the account is locked after too many failures.
+type Throttle struct {
+	log    *slog.Logger
+	store  Store
+	audit  Auditor
+	policy Policy
+
+	stop chan struct{}
+}
+
+func New(log *slog.Logger, store Store, audit Auditor, policy Policy) *Throttle {
+	t := &Throttle{
+		log:    log,
+		store:  store,
+		audit:  audit,
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+
+	go t.cleanupLoop(policy.Window)
+
+	return t
+}
+
+func (t *Throttle) Stop() {
+	close(t.stop)
+}
+
+// cleanupLoop removes counters of the store that no longer matter.
+func (t *Throttle) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := t.store.DeleteStaleLoginFailures(context.Background(), time.Now().Add(-t.policy.Window))
+			if err != nil {
+				t.log.Error("failed to delete stale login failures", sl.Err(err))
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Allow returns a *RetryError if logins to the account or from the address are blocked.
+func (t *Throttle) Allow(ctx context.Context, email string, addr string) error {
+	const op = "Throttle.Allow"
+
+	var blockedUntil time.Time
+	for _, key := range t.keys(email, addr) {
+		until, err := t.store.LoginBlockedUntil(ctx, key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if until.After(blockedUntil) {
+			blockedUntil = until
+		}
+	}
+
+	if retryAfter := time.Until(blockedUntil); retryAfter > 0 {
+		return &RetryError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// Failure records a failed login and blocks further attempts if needed.
+func (t *Throttle) Failure(ctx context.Context, email string, addr string) error {
+	const op = "Throttle.Failure"
+
+	log := t.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+		slog.String("addr", addr),
+	)
+
+	now := time.Now()
+
+	for _, key := range t.keys(email, addr) {
+		failures, err := t.store.RecordLoginFailure(ctx, key, now.Add(-t.policy.Window))
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		isAccount := strings.HasPrefix(key, accountPrefix)
+
+		var delay time.Duration
+		if isAccount {
+			if t.policy.FreeAttempts > 0 {
+				delay = t.delay(failures, t.policy.FreeAttempts)
+			}
+
+			if t.policy.LockoutAfter > 0 && failures >= t.policy.LockoutAfter {
+				delay = max(delay, t.policy.LockoutDuration)
+
+				log.Warn("account locked", slog.Int("failures", failures), slog.Duration("duration", delay))
+				t.audit.Record(ctx, models.AuditEvent{
+					Type:    models.AuditLockout,
+					Outcome: models.AuditDenied,
+					Email:   email,
+					IP:      addr,
+					Reason:  fmt.Sprintf("%d failed logins, locked for %s", failures, delay),
+				})
+			}
+		} else {
+			delay = t.delay(failures, t.policy.IPFreeAttempts)
+		}
+
+		if delay == 0 {
+			continue
+		}
+
+		if err := t.store.BlockLogin(ctx, key, now.Add(delay)); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("login throttled", slog.String("key", key), slog.Int("failures", failures), slog.Duration("delay", delay))
+	}
+
+	return nil
+}
+
+// Success forgets the failures of the account. Failures of the address are
+// kept, otherwise logging in to an own account would reset them.
+func (t *Throttle) Success(ctx context.Context, email string) error {
+	const op = "Throttle.Success"
+
+	if !t.tracksAccounts() {
+		return nil
+	}
+
+	if err := t.store.ResetLoginFailures(ctx, accountPrefix+email); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Unlock lifts the lockout and backoff of the account.
+func (t *Throttle) Unlock(ctx context.Context, email string) error {
+	const op = "Throttle.Unlock"
+
+	if err := t.store.ResetLoginFailures(ctx, accountPrefix+email); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	t.log.Info("account unlocked", slog.String("op", op), slog.String("email", email))
+
+	return nil
+}
+
+const (
+	accountPrefix = "account:"
+	addrPrefix    = "addr:"
+)
+
+// tracksAccounts reports whether failures are counted per account,
+// for backoff or for lockout.
+func (t *Throttle) tracksAccounts() bool {
+	return t.policy.FreeAttempts > 0 || t.policy.LockoutAfter > 0
+}
+
+func (t *Throttle) keys(email string, addr string) []string {
+	keys := make([]string, 0, 2)
+	if t.tracksAccounts() {
+		keys = append(keys, accountPrefix+email)
+	}
+	if t.policy.IPFreeAttempts > 0 && addr != "" {
+		keys = append(keys, addrPrefix+addr)
+	}
+
+	return keys
+}
+
+// delay returns BaseDelay doubled for every failure over free, up to MaxDelay.
+func (t *Throttle) delay(failures int, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+
+	delay := t.policy.BaseDelay
+	for i := free + 1; i < failures && delay < t.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, t.policy.MaxDelay)
+}
@@ -0,0 +1,181 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+type UserProvider interface {
+	UserByID(ctx context.Context, id int64) (models.User, error)
+	ListUsers(ctx context.Context, emailQuery string, afterID int64, limit int) ([]models.User, error)
+}
+
+type UserManager interface {
+	SetUserDisabled(ctx context.Context, userID int64, disabled bool) error
+	DeleteUser(ctx context.Context, userID int64) error
+}
+
+type SessionRevoker interface {
+	RevokeUserRefreshTokens(ctx context.Context, userID int64) ([]models.RefreshToken, error)
+}
+
+// Users lets operators manage user accounts.
+type Users struct {
+	log          *slog.Logger
+	userProvider UserProvider
+	userManager  UserManager
+	sessions     SessionRevoker
+}
+
+func New(
+	log *slog.Logger,
+	userProvider UserProvider,
+	userManager UserManager,
+	sessions SessionRevoker,
+) *Users {
+	return &Users{
+		log:          log,
+		userProvider: userProvider,
+		userManager:  userManager,
+		sessions:     sessions,
+	}
+}
+
+// List returns a page of up to limit users with IDs greater than afterID,
+// ordered by ID. If emailQuery is not empty, only users whose email contains it
+// are returned. Pass the ID of the last returned user to get the next page.
+func (u *Users) List(ctx context.Context, emailQuery string, afterID int64, limit int) ([]models.User, error) {
+	const op = "Users.List"
+
+	list, err := u.userProvider.ListUsers(ctx, emailQuery, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return list, nil
+}
+
+func (u *Users) User(ctx context.Context, userID int64) (models.User, error) {
+	const op = "Users.User"
+
+	user, err := u.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// Disable stops the user from logging in and ends all their sessions.
+func (u *Users) Disable(ctx context.Context, userID int64) error {
+	const op = "Users.Disable"
+
+	log := u.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	if err := u.userManager.SetUserDisabled(ctx, userID, true); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to disable user", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := u.sessions.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		log.Error("failed to revoke user sessions", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user disabled")
+
+	return nil
+}
+
+// Enable lets a disabled user log in again. Their old sessions stay revoked.
+func (u *Users) Enable(ctx context.Context, userID int64) error {
+	const op = "Users.Enable"
+
+	log := u.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	if err := u.userManager.SetUserDisabled(ctx, userID, false); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to enable user", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user enabled")
+
+	return nil
+}
+
+// Logout ends all sessions of the user. Access tokens issued
+// for them become inactive too, see auth.Auth.ValidateToken.
+func (u *Users) Logout(ctx context.Context, userID int64) error {
+	const op = "Users.Logout"
+
+	log := u.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	if _, err := u.User(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := u.sessions.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		log.Error("failed to revoke user sessions", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged out of all sessions")
+
+	return nil
+}
+
+// Delete deletes the user with all their data, their sessions end.
+func (u *Users) Delete(ctx context.Context, userID int64) error {
+	const op = "Users.Delete"
+
+	log := u.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	if err := u.userManager.DeleteUser(ctx, userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to delete user", sl.Err(err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user deleted")
+
+	return nil
+}
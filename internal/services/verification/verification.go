@@ -0,0 +1,285 @@
+package verification
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mailer"
+	"sso/internal/storage"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid verification token")
+	ErrEmailTaken   = errors.New("email already taken")
+	ErrDisabled     = errors.New("email verification disabled")
+)
+
+type UserProvider interface {
+	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, id int64) (models.User, error)
+}
+
+type UserVerifier interface {
+	MarkEmailVerified(ctx context.Context, userID int64) error
+	UpdateEmail(ctx context.Context, userID int64, email string) error
+}
+
+type EmailTokenStore interface {
+	SaveEmailToken(ctx context.Context, token models.EmailToken) error
+	ConsumeEmailToken(ctx context.Context, id string) (models.EmailToken, error)
+	DeleteUserEmailTokens(ctx context.Context, userID int64, purpose string) error
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg mailer.Message) error
+}
+
+// Verification confirms that users own their email addresses
+// by mailing them single-use signed links.
+type Verification struct {
+	log         *slog.Logger
+	usrProvider UserProvider
+	usrVerifier UserVerifier
+	tokens      EmailTokenStore
+	mailer      Mailer
+	secret      string
+	tokenTTL    time.Duration
+	url         string
+}
+
+// New creates the service. If secret is empty, verification is off:
+// no verification links are sent and ErrDisabled is returned wherever
+// a link is needed.
+func New(
+	log *slog.Logger,
+	userProvider UserProvider,
+	userVerifier UserVerifier,
+	tokens EmailTokenStore,
+	mailer Mailer,
+	secret string,
+	tokenTTL time.Duration,
+	verificationURL string,
+) *Verification {
+	return &Verification{
+		log:         log,
+		usrProvider: userProvider,
+		usrVerifier: userVerifier,
+		tokens:      tokens,
+		mailer:      mailer,
+		secret:      secret,
+		tokenTTL:    tokenTTL,
+		url:         verificationURL,
+	}
+}
+
+// SendVerification mails a verification link to the user.
+// Links sent earlier stay valid until they expire.
+func (v *Verification) SendVerification(ctx context.Context, user models.User) error {
+	const op = "Verification.SendVerification"
+
+	if v.secret == "" || user.EmailVerified {
+		return nil
+	}
+
+	err := v.send(ctx, user.ID, user.Email, models.EmailTokenVerification, "Confirm your email",
+		"Open the link below to confirm your email address:\n\n%s\n\nThe link expires in %s.\n",
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	v.log.Info("verification email sent", slog.String("op", op), slog.Int64("uid", user.ID))
+
+	return nil
+}
+
+// SendEmailChange mails a confirmation link to the new email of the user.
+// The email is changed only when the link is opened, see Verify.
+// Links sent for earlier changes stop working.
+func (v *Verification) SendEmailChange(ctx context.Context, user models.User, newEmail string) error {
+	const op = "Verification.SendEmailChange"
+
+	if v.secret == "" {
+		return fmt.Errorf("%s: %w", op, ErrDisabled)
+	}
+
+	if err := v.tokens.DeleteUserEmailTokens(ctx, user.ID, models.EmailTokenEmailChange); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err := v.send(ctx, user.ID, newEmail, models.EmailTokenEmailChange, "Confirm your new email",
+		"Open the link below to use this address for your account:\n\n%s\n\nThe link expires in %s.\n",
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	v.log.Info("email change confirmation sent", slog.String("op", op), slog.Int64("uid", user.ID))
+
+	return nil
+}
+
+// send mails a link with a new token of the purpose to the email.
+// body gets the link and the token lifetime.
+func (v *Verification) send(ctx context.Context, userID int64, email string, purpose string, subject string, body string) error {
+	token := models.EmailToken{
+		ID:        rand.Text(),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(v.tokenTTL),
+	}
+
+	signed, err := jwt.NewEmailToken(token, email, v.secret)
+	if err != nil {
+		return err
+	}
+
+	if err := v.tokens.SaveEmailToken(ctx, token); err != nil {
+		v.log.Error("failed to save email token", sl.Err(err))
+
+		return err
+	}
+
+	link, err := url.Parse(v.url)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", signed)
+	link.RawQuery = query.Encode()
+
+	err = v.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: subject,
+		Body:    fmt.Sprintf(body, link, v.tokenTTL),
+	})
+	if err != nil {
+		v.log.Error("failed to send email", sl.Err(err), slog.Int64("uid", userID))
+
+		return err
+	}
+
+	return nil
+}
+
+// Resend invalidates earlier links and mails a new one.
+// Unknown and already verified addresses are silently ignored,
+// so the result doesn't reveal which emails are registered.
+func (v *Verification) Resend(ctx context.Context, email string) error {
+	const op = "Verification.Resend"
+
+	if v.secret == "" {
+		return fmt.Errorf("%s: %w", op, ErrDisabled)
+	}
+
+	user, err := v.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			v.log.Info("verification requested for unknown email", slog.String("op", op))
+
+			return nil
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.EmailVerified {
+		return nil
+	}
+
+	if err := v.tokens.DeleteUserEmailTokens(ctx, user.ID, models.EmailTokenVerification); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := v.SendVerification(ctx, user); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Verify confirms the email a token from SendVerification or SendEmailChange
+// was sent to and returns its owner. A verification token is rejected if the
+// user has changed the email since.
+func (v *Verification) Verify(ctx context.Context, token string) (int64, error) {
+	const op = "Verification.Verify"
+
+	log := v.log.With(slog.String("op", op))
+
+	if v.secret == "" {
+		return 0, fmt.Errorf("%s: %w", op, ErrDisabled)
+	}
+
+	claims, err := jwt.ParseEmailToken(token, v.secret)
+	if err != nil || (claims.Purpose != models.EmailTokenVerification && claims.Purpose != models.EmailTokenEmailChange) {
+		log.Info("invalid verification token", sl.Err(err))
+
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	log = log.With(
+		slog.Int64("uid", claims.UserID),
+		slog.String("purpose", claims.Purpose),
+	)
+
+	stored, err := v.tokens.ConsumeEmailToken(ctx, claims.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEmailTokenNotFound) {
+			log.Info("verification token already used")
+
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.UserID != claims.UserID || stored.Purpose != claims.Purpose {
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	user, err := v.usrProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if claims.Purpose == models.EmailTokenEmailChange {
+		if err := v.usrVerifier.UpdateEmail(ctx, user.ID, claims.Email); err != nil {
+			if errors.Is(err, storage.ErrUserExists) {
+				log.Info("new email taken by another user")
+
+				return 0, fmt.Errorf("%s: %w", op, ErrEmailTaken)
+			}
+
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("email changed")
+
+		return user.ID, nil
+	}
+
+	if user.Email != claims.Email {
+		log.Info("verification token issued for another email")
+
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	if err := v.usrVerifier.MarkEmailVerified(ctx, user.ID); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email verified")
+
+	return user.ID, nil
+}
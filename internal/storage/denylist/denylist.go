@@ -0,0 +1,152 @@
+package denylist
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/cache"
+	"sso/internal/lib/logger/sl"
+	"time"
+)
+
+type Storage interface {
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	AccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredRevokedTokens(ctx context.Context) error
+	RefreshTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error)
+}
+
+// Denylist keeps access token revocation state in storage
+// and caches it in memory, as well as the state of sessions
+// (refresh token families) access tokens belong to.
+//
+// Revoked tokens are cached until they expire, since revocation is final.
+// Tokens found not revoked are cached for at most negativeTTL, which bounds
+// how long a revocation made by another replica, or a revocation of all
+// sessions of a user, may go unnoticed.
+type Denylist struct {
+	log         *slog.Logger
+	storage     Storage
+	cache       *cache.TTL[string, bool]
+	negativeTTL time.Duration
+
+	stop chan struct{}
+}
+
+func New(
+	log *slog.Logger,
+	storage Storage,
+	negativeTTL time.Duration,
+	sweepInterval time.Duration,
+) *Denylist {
+	d := &Denylist{
+		log:         log,
+		storage:     storage,
+		cache:       cache.NewTTL[string, bool](sweepInterval),
+		negativeTTL: negativeTTL,
+		stop:        make(chan struct{}),
+	}
+
+	go d.cleanupLoop(sweepInterval)
+
+	return d
+}
+
+// Revoke denies the token until it expires.
+func (d *Denylist) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "denylist.Revoke"
+
+	if err := d.storage.RevokeAccessToken(ctx, jti, expiresAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	d.cache.Set(jti, true, expiresAt)
+
+	return nil
+}
+
+// IsRevoked reports whether the token expiring at expiresAt has been revoked.
+func (d *Denylist) IsRevoked(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	const op = "denylist.IsRevoked"
+
+	revoked, err := d.cached(jti, expiresAt, func() (bool, error) {
+		return d.storage.AccessTokenRevoked(ctx, jti)
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// RevokeSession caches that the session sid has been revoked in storage
+// until expiresAt, so that its access tokens are denied at once.
+func (d *Denylist) RevokeSession(sid string, expiresAt time.Time) {
+	d.cache.Set(sessionKey(sid), true, expiresAt)
+}
+
+// IsSessionRevoked reports whether the session of a token expiring
+// at expiresAt has been revoked.
+func (d *Denylist) IsSessionRevoked(ctx context.Context, sid string, expiresAt time.Time) (bool, error) {
+	const op = "denylist.IsSessionRevoked"
+
+	revoked, err := d.cached(sessionKey(sid), expiresAt, func() (bool, error) {
+		return d.storage.RefreshTokenFamilyRevoked(ctx, sid)
+	})
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// cached returns the cached state of key, asking lookup on a miss.
+func (d *Denylist) cached(key string, expiresAt time.Time, lookup func() (bool, error)) (bool, error) {
+	if revoked, ok := d.cache.Get(key); ok {
+		return revoked, nil
+	}
+
+	revoked, err := lookup()
+	if err != nil {
+		return false, err
+	}
+
+	cacheUntil := expiresAt
+	if !revoked {
+		cacheUntil = time.Now().Add(d.negativeTTL)
+		if expiresAt.Before(cacheUntil) {
+			cacheUntil = expiresAt
+		}
+	}
+
+	d.cache.Set(key, revoked, cacheUntil)
+
+	return revoked, nil
+}
+
+// sessionKey keeps session ids apart from token ids in the cache.
+func sessionKey(sid string) string {
+	return "sid:" + sid
+}
+
+func (d *Denylist) Stop() {
+	d.cache.Stop()
+	close(d.stop)
+}
+
+// cleanupLoop removes entries of expired tokens from storage.
+func (d *Denylist) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.storage.DeleteExpiredRevokedTokens(context.Background()); err != nil {
+				d.log.Error("failed to delete expired revoked tokens", sl.Err(err))
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
@@ -1,22 +1,38 @@
 package sqlite
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
 	"sso/internal/domain/models"
+	"sso/internal/lib/encryption"
+	"sso/internal/lib/scopes"
 	"sso/internal/storage"
+	"strings"
+	"time"
 
 	"github.com/mattn/go-sqlite3"
 )
 
 type Storage struct {
-	db *sql.DB
+	db      *sql.DB
+	secrets Cipher
+}
+
+// Cipher encrypts secrets at rest: the secrets of apps and the private
+// keys they sign tokens with.
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(data []byte) ([]byte, error)
+	// IsCurrent reports whether data is encrypted with the current key
+	IsCurrent(data []byte) bool
 }
 
 // Конструктор Storage
-func New(storagePath string) (*Storage, error) {
+func New(storagePath string, secrets Cipher) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
 	// Указываем путь до файла БД
@@ -25,7 +41,7 @@ func New(storagePath string) (*Storage, error) {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	return &Storage{db: db, secrets: secrets}, nil
 }
 
 func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (int64, error) {
@@ -62,7 +78,7 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	const op = "storage.sqlite.User"
 
-	stmt, err := s.db.Prepare("SELECT id, email, pass_hash FROM users WHERE email = ?")
+	stmt, err := s.db.Prepare("SELECT id, email, pass_hash, email_verified, disabled FROM users WHERE email = ?")
 	if err != nil {
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
@@ -70,7 +86,29 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	row := stmt.QueryRowContext(ctx, email)
 
 	var user models.User
-	err = row.Scan(&user.ID, &user.Email, &user.PassHash)
+	err = row.Scan(&user.ID, &user.Email, &user.PassHash, &user.EmailVerified, &user.Disabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+func (s *Storage) UserByID(ctx context.Context, id int64) (models.User, error) {
+	const op = "storage.sqlite.UserByID"
+
+	stmt, err := s.db.Prepare("SELECT id, email, pass_hash, email_verified, disabled FROM users WHERE id = ?")
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var user models.User
+	err = stmt.QueryRowContext(ctx, id).Scan(&user.ID, &user.Email, &user.PassHash, &user.EmailVerified, &user.Disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -82,18 +120,19 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	return user, nil
 }
 
+const appColumns = `id, name, secret, allowed_scopes, redirect_uris, client_secret_hash,
+	client_scopes, audiences, require_verified_email, grant_types, access_token_ttl, refresh_token_ttl, omit_claims`
+
 func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
 	const op = "storage.sqlite.App"
 
-	stmt, err := s.db.Prepare("SELECT id, name, secret FROM apps WHERE id = ?")
+	stmt, err := s.db.Prepare("SELECT " + appColumns + " FROM apps WHERE id = ?")
 	if err != nil {
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
+	defer stmt.Close()
 
-	row := stmt.QueryRowContext(ctx, id)
-
-	var app models.App
-	err = row.Scan(&app.ID, &app.Name, &app.Secret)
+	app, err := s.scanApp(stmt.QueryRowContext(ctx, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
@@ -105,6 +144,2123 @@ func (s *Storage) App(ctx context.Context, id int) (models.App, error) {
 	return app, nil
 }
 
+func (s *Storage) scanApp(row rowScanner) (models.App, error) {
+	var (
+		app           models.App
+		allowedScopes string
+		redirectURIs  string
+		clientScopes  string
+		audiences     string
+		grantTypes    string
+		accessTTL     int64
+		refreshTTL    int64
+		omitClaims    string
+	)
+	err := row.Scan(
+		&app.ID,
+		&app.Name,
+		&app.Secret,
+		&allowedScopes,
+		&redirectURIs,
+		&app.ClientSecretHash,
+		&clientScopes,
+		&audiences,
+		&app.RequireVerifiedEmail,
+		&grantTypes,
+		&accessTTL,
+		&refreshTTL,
+		&omitClaims,
+	)
+	if err != nil {
+		return models.App{}, err
+	}
+
+	app.Scopes = scopes.Parse(allowedScopes)
+	app.RedirectURIs = strings.Fields(redirectURIs)
+	app.ClientScopes = scopes.Parse(clientScopes)
+	app.Audiences = strings.Fields(audiences)
+	app.GrantTypes = strings.Fields(grantTypes)
+	app.AccessTokenTTL = time.Duration(accessTTL) * time.Second
+	app.RefreshTokenTTL = time.Duration(refreshTTL) * time.Second
+	app.OmitClaims = strings.Fields(omitClaims)
+
+	if app.Secret, err = s.decryptSecret(app.Secret); err != nil {
+		return models.App{}, err
+	}
+
+	return app, nil
+}
+
+// SaveClientSecret sets the hash of the app's client secret.
+// Returns storage.ErrAppNotFound if the app doesn't exist.
+func (s *Storage) SaveClientSecret(ctx context.Context, appID int, secretHash []byte) error {
+	const op = "storage.sqlite.SaveClientSecret"
+
+	stmt, err := s.db.Prepare("UPDATE apps SET client_secret_hash = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, secretHash, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	const op = "storage.sqlite.SaveRefreshToken"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO refresh_tokens(id, family_id, user_id, app_id, token_hash, expires_at, scopes, amr)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx,
+		token.ID,
+		token.FamilyID,
+		token.UserID,
+		token.AppID,
+		token.TokenHash,
+		token.ExpiresAt,
+		scopes.Format(token.Scopes),
+		strings.Join(token.AMR, " "),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) RefreshToken(ctx context.Context, id string) (models.RefreshToken, error) {
+	const op = "storage.sqlite.RefreshToken"
+
+	stmt, err := s.db.Prepare(`
+		SELECT id, family_id, user_id, app_id, token_hash, expires_at, used, revoked, scopes, amr
+		FROM refresh_tokens WHERE id = ?`)
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var (
+		token       models.RefreshToken
+		tokenScopes string
+		amr         string
+	)
+	err = stmt.QueryRowContext(ctx, id).Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&token.AppID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.Used,
+		&token.Revoked,
+		&tokenScopes,
+		&amr,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RefreshToken{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+		}
+
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token.Scopes = scopes.Parse(tokenScopes)
+	token.AMR = strings.Fields(amr)
+
+	return token, nil
+}
+
+// MarkRefreshTokenUsed atomically flags the token as used.
+// Returns storage.ErrRefreshTokenUsed if it has already been used.
+func (s *Storage) MarkRefreshTokenUsed(ctx context.Context, id string) error {
+	const op = "storage.sqlite.MarkRefreshTokenUsed"
+
+	stmt, err := s.db.Prepare("UPDATE refresh_tokens SET used = 1 WHERE id = ? AND used = 0")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenUsed)
+	}
+
+	return nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	const op = "storage.sqlite.RevokeRefreshTokenFamily"
+
+	stmt, err := s.db.Prepare("UPDATE refresh_tokens SET revoked = 1 WHERE family_id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, familyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RefreshTokenFamilyRevoked reports whether the session has been revoked
+// or no longer exists because the user was deleted.
+func (s *Storage) RefreshTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	const op = "storage.sqlite.RefreshTokenFamilyRevoked"
+
+	stmt, err := s.db.Prepare(`
+		SELECT NOT EXISTS(
+			SELECT 1 FROM refresh_tokens WHERE family_id = ? AND NOT revoked
+		)`)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var revoked bool
+	if err := stmt.QueryRowContext(ctx, familyID).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// RevokeUserRefreshTokens revokes all sessions of the user.
+// Returns the sessions it revoked, see scanRevokedSessions.
+func (s *Storage) RevokeUserRefreshTokens(ctx context.Context, userID int64) ([]models.RefreshToken, error) {
+	const op = "storage.sqlite.RevokeUserRefreshTokens"
+
+	stmt, err := s.db.Prepare(`
+		UPDATE refresh_tokens SET revoked = 1
+		WHERE user_id = ? AND NOT revoked
+		RETURNING family_id, expires_at`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := scanRevokedSessions(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeOtherRefreshTokens revokes all sessions of the user except the given one.
+// Returns the sessions it revoked, see scanRevokedSessions.
+func (s *Storage) RevokeOtherRefreshTokens(ctx context.Context, userID int64, familyID string) ([]models.RefreshToken, error) {
+	const op = "storage.sqlite.RevokeOtherRefreshTokens"
+
+	stmt, err := s.db.Prepare(`
+		UPDATE refresh_tokens SET revoked = 1
+		WHERE user_id = ? AND family_id <> ? AND NOT revoked
+		RETURNING family_id, expires_at`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, userID, familyID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err := scanRevokedSessions(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+func (s *Storage) SaveSigningKey(ctx context.Context, key models.SigningKey) error {
+	const op = "storage.sqlite.SaveSigningKey"
+
+	privateKey, err := s.secrets.Encrypt(key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO app_keys(id, app_id, algorithm, private_key, public_key, state, created_at, updated_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx,
+		key.ID,
+		key.AppID,
+		key.Algorithm,
+		privateKey,
+		key.PublicKey,
+		key.State,
+		key.CreatedAt,
+		key.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SigningKeys returns every key of the app in any state, newest first.
+func (s *Storage) SigningKeys(ctx context.Context, appID int) ([]models.SigningKey, error) {
+	const op = "storage.sqlite.SigningKeys"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, app_id, algorithm, private_key, public_key, state, created_at, updated_at
+		FROM app_keys WHERE app_id = ? ORDER BY created_at DESC`, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keys, err := s.scanSigningKeys(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+func (s *Storage) AllSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	const op = "storage.sqlite.AllSigningKeys"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, app_id, algorithm, private_key, public_key, state, created_at, updated_at
+		FROM app_keys ORDER BY app_id, created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keys, err := s.scanSigningKeys(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+// ActivateSigningKey makes the key the only active key of the app.
+// The previously active keys become retiring.
+func (s *Storage) ActivateSigningKey(ctx context.Context, appID int, id string) error {
+	const op = "storage.sqlite.ActivateSigningKey"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE app_keys SET state = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND app_id = ? AND state IN (?, ?)`,
+		models.KeyStateActive, id, appID, models.KeyStatePending, models.KeyStateActive,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrKeyNotFound)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE app_keys SET state = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE app_id = ? AND state = ? AND id <> ?`,
+		models.KeyStateRetiring, appID, models.KeyStateActive, id,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateSigningKeyState(ctx context.Context, id string, state models.KeyState) error {
+	const op = "storage.sqlite.UpdateSigningKeyState"
+
+	stmt, err := s.db.Prepare("UPDATE app_keys SET state = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, state, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrKeyNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) scanSigningKeys(rows *sql.Rows) ([]models.SigningKey, error) {
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		err := rows.Scan(
+			&key.ID,
+			&key.AppID,
+			&key.Algorithm,
+			&key.PrivateKey,
+			&key.PublicKey,
+			&key.State,
+			&key.CreatedAt,
+			&key.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.PrivateKey, err = s.decrypt(key.PrivateKey); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAccessToken adds the token to the denylist. Revoking twice is not an error.
+func (s *Storage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.sqlite.RevokeAccessToken"
+
+	stmt, err := s.db.Prepare("INSERT INTO revoked_tokens(jti, expires_at) VALUES(?, ?) ON CONFLICT (jti) DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, jti, expiresAt.UTC()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) AccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "storage.sqlite.AccessTokenRevoked"
+
+	stmt, err := s.db.Prepare("SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)")
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var revoked bool
+	if err := stmt.QueryRowContext(ctx, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// DeleteExpiredRevokedTokens removes denylist entries of tokens that have expired anyway.
+func (s *Storage) DeleteExpiredRevokedTokens(ctx context.Context) error {
+	const op = "storage.sqlite.DeleteExpiredRevokedTokens"
+
+	// Times are stored as text, so compare in UTC as written by RevokeAccessToken
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now().UTC()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveUserRole grants the role to the user in the app. Granting twice is not an error.
+func (s *Storage) SaveUserRole(ctx context.Context, userID int64, appID int, role string) error {
+	const op = "storage.sqlite.SaveUserRole"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO user_roles(user_id, app_id, role) VALUES(?, ?, ?)
+		ON CONFLICT (user_id, app_id, role) DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, userID, appID, role); err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteUserRole(ctx context.Context, userID int64, appID int, role string) error {
+	const op = "storage.sqlite.DeleteUserRole"
+
+	stmt, err := s.db.Prepare("DELETE FROM user_roles WHERE user_id = ? AND app_id = ? AND role = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, userID, appID, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRoleNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) UserRoles(ctx context.Context, userID int64, appID int) ([]string, error) {
+	const op = "storage.sqlite.UserRoles"
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT role FROM user_roles WHERE user_id = ? AND app_id = ? ORDER BY role",
+		userID, appID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// UserHasPermission reports whether any role of the user in the app grants the permission.
+func (s *Storage) UserHasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error) {
+	const op = "storage.sqlite.UserHasPermission"
+
+	stmt, err := s.db.Prepare(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_roles ur
+			JOIN role_permissions rp ON rp.app_id = ur.app_id AND rp.role = ur.role
+			WHERE ur.user_id = ? AND ur.app_id = ? AND rp.permission = ?
+		)`)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var ok bool
+	if err := stmt.QueryRowContext(ctx, userID, appID, permission).Scan(&ok); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ok, nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) error {
+	const op = "storage.sqlite.SaveAuthorizationCode"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO authorization_codes(code_hash, app_id, user_id, redirect_uri, scopes, code_challenge, nonce, auth_time, expires_at, amr)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx,
+		code.Hash,
+		code.AppID,
+		code.UserID,
+		code.RedirectURI,
+		scopes.Format(code.Scopes),
+		code.CodeChallenge,
+		code.Nonce,
+		code.AuthTime,
+		code.ExpiresAt,
+		strings.Join(code.AMR, " "),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumeAuthorizationCode atomically marks the code as used and returns it.
+// Returns storage.ErrAuthCodeNotFound if the code doesn't exist or has been used.
+func (s *Storage) ConsumeAuthorizationCode(ctx context.Context, hash string) (models.AuthorizationCode, error) {
+	const op = "storage.sqlite.ConsumeAuthorizationCode"
+
+	stmt, err := s.db.Prepare(`
+		UPDATE authorization_codes SET used = 1
+		WHERE code_hash = ? AND used = 0
+		RETURNING code_hash, app_id, user_id, redirect_uri, scopes, code_challenge, nonce, auth_time, expires_at, amr`)
+	if err != nil {
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var (
+		code       models.AuthorizationCode
+		codeScopes string
+		amr        string
+	)
+	err = stmt.QueryRowContext(ctx, hash).Scan(
+		&code.Hash,
+		&code.AppID,
+		&code.UserID,
+		&code.RedirectURI,
+		&codeScopes,
+		&code.CodeChallenge,
+		&code.Nonce,
+		&code.AuthTime,
+		&code.ExpiresAt,
+		&amr,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, storage.ErrAuthCodeNotFound)
+		}
+
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code.Scopes = scopes.Parse(codeScopes)
+	code.AMR = strings.Fields(amr)
+
+	return code, nil
+}
+
+// SaveTOTP stores a new unconfirmed TOTP secret of the user,
+// replacing the previous enrollment.
+func (s *Storage) SaveTOTP(ctx context.Context, userID int64, secret []byte) error {
+	const op = "storage.sqlite.SaveTOTP"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO user_totp(user_id, secret) VALUES(?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, confirmed = 0, last_used_step = 0`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, userID, secret); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TOTP returns the user's TOTP enrollment.
+// Returns storage.ErrTOTPNotFound if the user hasn't enrolled.
+func (s *Storage) TOTP(ctx context.Context, userID int64) (models.TOTP, error) {
+	const op = "storage.sqlite.TOTP"
+
+	stmt, err := s.db.Prepare("SELECT user_id, secret, confirmed, last_used_step FROM user_totp WHERE user_id = ?")
+	if err != nil {
+		return models.TOTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var totp models.TOTP
+	err = stmt.QueryRowContext(ctx, userID).Scan(&totp.UserID, &totp.Secret, &totp.Confirmed, &totp.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TOTP{}, fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+		}
+
+		return models.TOTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return totp, nil
+}
+
+// ConfirmTOTP enables the user's TOTP enrollment, recording the step of the
+// confirmation code, and replaces the user's recovery codes.
+func (s *Storage) ConfirmTOTP(ctx context.Context, userID int64, step int64, recoveryCodes [][]byte) error {
+	const op = "storage.sqlite.ConfirmTOTP"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE user_totp SET confirmed = 1, last_used_step = ? WHERE user_id = ? AND last_used_step < ?",
+		step, userID, step,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPStepUsed)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, hash := range recoveryCodes {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO recovery_codes(user_id, code_hash) VALUES(?, ?)",
+			userID, hash,
+		); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep atomically records a code of the given step as used.
+// Returns storage.ErrTOTPStepUsed if a code of this or a later step was already used.
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	const op = "storage.sqlite.UseTOTPStep"
+
+	stmt, err := s.db.Prepare(
+		"UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND confirmed = 1 AND last_used_step < ?",
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, step, userID, step)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPStepUsed)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode atomically marks the recovery code as used.
+// Returns storage.ErrRecoveryCodeNotFound if there is no such unused code.
+func (s *Storage) UseRecoveryCode(ctx context.Context, userID int64, hash []byte) error {
+	const op = "storage.sqlite.UseRecoveryCode"
+
+	stmt, err := s.db.Prepare(
+		"UPDATE recovery_codes SET used = 1 WHERE user_id = ? AND code_hash = ? AND used = 0",
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, userID, hash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRecoveryCodeNotFound)
+	}
+
+	return nil
+}
+
+// DeleteTOTP removes the user's TOTP enrollment and recovery codes.
+func (s *Storage) DeleteTOTP(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.DeleteTOTP"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveMFAChallenge records an MFA token so that it can be used only once,
+// expired ones are deleted.
+func (s *Storage) SaveMFAChallenge(ctx context.Context, id string, userID int64, expiresAt time.Time) error {
+	const op = "storage.sqlite.SaveMFAChallenge"
+
+	// Times are stored as text, so compare in UTC as written below
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE expires_at < ?", time.Now().UTC()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.db.Prepare("INSERT INTO mfa_challenges(id, user_id, expires_at) VALUES(?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, id, userID, expiresAt.UTC()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseMFAChallenge counts an attempt to pass the second factor with the MFA token.
+// Returns storage.ErrMFAChallengeNotFound if it doesn't exist, has expired
+// or has used up maxAttempts.
+func (s *Storage) UseMFAChallenge(ctx context.Context, id string, maxAttempts int) error {
+	const op = "storage.sqlite.UseMFAChallenge"
+
+	stmt, err := s.db.Prepare(`
+		UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE id = ? AND attempts < ? AND expires_at > ?`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, id, maxAttempts, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+	}
+
+	return nil
+}
+
+// DeleteMFAChallenge consumes the MFA token.
+// Returns storage.ErrMFAChallengeNotFound if it has been used.
+func (s *Storage) DeleteMFAChallenge(ctx context.Context, id string) error {
+	const op = "storage.sqlite.DeleteMFAChallenge"
+
+	stmt, err := s.db.Prepare("DELETE FROM mfa_challenges WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+	}
+
+	return nil
+}
+
+// SaveWebAuthnChallenge saves the challenge, challenges that expired
+// without being used are deleted on the way.
+func (s *Storage) SaveWebAuthnChallenge(ctx context.Context, challenge models.WebAuthnChallenge) error {
+	const op = "storage.sqlite.SaveWebAuthnChallenge"
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM webauthn_challenges WHERE expires_at < ?", time.Now().UTC()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO webauthn_challenges(challenge, user_id, purpose, expires_at)
+		VALUES(?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx,
+		challenge.Challenge,
+		challenge.UserID,
+		challenge.Purpose,
+		challenge.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ConsumeWebAuthnChallenge atomically deletes the challenge and returns it.
+// Returns storage.ErrChallengeNotFound if it doesn't exist or has been used.
+func (s *Storage) ConsumeWebAuthnChallenge(ctx context.Context, challenge string) (models.WebAuthnChallenge, error) {
+	const op = "storage.sqlite.ConsumeWebAuthnChallenge"
+
+	stmt, err := s.db.Prepare(`
+		DELETE FROM webauthn_challenges WHERE challenge = ?
+		RETURNING challenge, user_id, purpose, expires_at`)
+	if err != nil {
+		return models.WebAuthnChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var res models.WebAuthnChallenge
+	err = stmt.QueryRowContext(ctx, challenge).Scan(&res.Challenge, &res.UserID, &res.Purpose, &res.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.WebAuthnChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrChallengeNotFound)
+		}
+
+		return models.WebAuthnChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return res, nil
+}
+
+// SavePasskey registers a credential.
+// Returns storage.ErrPasskeyExists if the credential is already registered.
+func (s *Storage) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	const op = "storage.sqlite.SavePasskey"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO passkeys(id, user_id, public_key, algorithm, sign_count, transports)
+		VALUES(?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx,
+		passkey.ID,
+		passkey.UserID,
+		passkey.PublicKey,
+		passkey.Algorithm,
+		int64(passkey.SignCount),
+		strings.Join(passkey.Transports, " "),
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) {
+			switch sqliteErr.ExtendedCode {
+			case sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintUnique:
+				return fmt.Errorf("%s: %w", op, storage.ErrPasskeyExists)
+			case sqlite3.ErrConstraintForeignKey:
+				return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			}
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) Passkey(ctx context.Context, id []byte) (models.Passkey, error) {
+	const op = "storage.sqlite.Passkey"
+
+	stmt, err := s.db.Prepare(`
+		SELECT id, user_id, public_key, algorithm, sign_count, transports, created_at, last_used_at
+		FROM passkeys WHERE id = ?`)
+	if err != nil {
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	passkey, err := scanPasskey(stmt.QueryRowContext(ctx, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Passkey{}, fmt.Errorf("%s: %w", op, storage.ErrPasskeyNotFound)
+		}
+
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return passkey, nil
+}
+
+func (s *Storage) UserPasskeys(ctx context.Context, userID int64) ([]models.Passkey, error) {
+	const op = "storage.sqlite.UserPasskeys"
+
+	stmt, err := s.db.Prepare(`
+		SELECT id, user_id, public_key, algorithm, sign_count, transports, created_at, last_used_at
+		FROM passkeys WHERE user_id = ? ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var passkeys []models.Passkey
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return passkeys, nil
+}
+
+// UpdatePasskeySignCount records a successful use of the passkey.
+// The sign count must grow unless the authenticator doesn't keep one (always 0),
+// otherwise storage.ErrSignCountRegression is returned: the credential may be cloned.
+func (s *Storage) UpdatePasskeySignCount(ctx context.Context, id []byte, signCount uint32) error {
+	const op = "storage.sqlite.UpdatePasskeySignCount"
+
+	stmt, err := s.db.Prepare(`
+		UPDATE passkeys SET sign_count = ?, last_used_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0))`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	count := int64(signCount)
+
+	res, err := stmt.ExecContext(ctx, count, id, count, count)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSignCountRegression)
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPasskey(row rowScanner) (models.Passkey, error) {
+	var (
+		passkey    models.Passkey
+		signCount  int64
+		transports string
+		lastUsedAt sql.NullTime
+	)
+
+	err := row.Scan(
+		&passkey.ID,
+		&passkey.UserID,
+		&passkey.PublicKey,
+		&passkey.Algorithm,
+		&signCount,
+		&transports,
+		&passkey.CreatedAt,
+		&lastUsedAt,
+	)
+	if err != nil {
+		return models.Passkey{}, err
+	}
+
+	passkey.SignCount = uint32(signCount)
+	passkey.Transports = strings.Fields(transports)
+	passkey.LastUsedAt = lastUsedAt.Time
+
+	return passkey, nil
+}
+
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte) error {
+	const op = "storage.sqlite.UpdatePassword"
+
+	stmt, err := s.db.Prepare("UPDATE users SET pass_hash = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UpdateEmail sets a new, already verified email of the user.
+// Returns storage.ErrUserExists if another user has the email.
+func (s *Storage) UpdateEmail(ctx context.Context, userID int64, email string) error {
+	const op = "storage.sqlite.UpdateEmail"
+
+	stmt, err := s.db.Prepare("UPDATE users SET email = ?, email_verified = 1 WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, email, userID)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// MarkEmailVerified marks the user's email as verified.
+func (s *Storage) MarkEmailVerified(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.MarkEmailVerified"
+
+	stmt, err := s.db.Prepare("UPDATE users SET email_verified = 1 WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveEmailToken(ctx context.Context, token models.EmailToken) error {
+	const op = "storage.sqlite.SaveEmailToken"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO email_tokens(id, user_id, purpose, expires_at)
+		VALUES(?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, token.ID, token.UserID, token.Purpose, token.ExpiresAt.UTC())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// EmailToken returns the token without consuming it.
+// Returns storage.ErrEmailTokenNotFound if it doesn't exist or has been used.
+func (s *Storage) EmailToken(ctx context.Context, id string) (models.EmailToken, error) {
+	const op = "storage.sqlite.EmailToken"
+
+	stmt, err := s.db.Prepare("SELECT id, user_id, purpose, expires_at FROM email_tokens WHERE id = ?")
+	if err != nil {
+		return models.EmailToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var token models.EmailToken
+	err = stmt.QueryRowContext(ctx, id).Scan(&token.ID, &token.UserID, &token.Purpose, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.EmailToken{}, fmt.Errorf("%s: %w", op, storage.ErrEmailTokenNotFound)
+		}
+
+		return models.EmailToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// ConsumeEmailToken atomically deletes the token and returns it.
+// Returns storage.ErrEmailTokenNotFound if it doesn't exist or has been used.
+func (s *Storage) ConsumeEmailToken(ctx context.Context, id string) (models.EmailToken, error) {
+	const op = "storage.sqlite.ConsumeEmailToken"
+
+	stmt, err := s.db.Prepare(`
+		DELETE FROM email_tokens WHERE id = ?
+		RETURNING id, user_id, purpose, expires_at`)
+	if err != nil {
+		return models.EmailToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var token models.EmailToken
+	err = stmt.QueryRowContext(ctx, id).Scan(&token.ID, &token.UserID, &token.Purpose, &token.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.EmailToken{}, fmt.Errorf("%s: %w", op, storage.ErrEmailTokenNotFound)
+		}
+
+		return models.EmailToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// ResetPassword consumes the password reset token and sets the new password
+// of the user in one transaction, so a used token always means a changed password.
+// Returns storage.ErrEmailTokenNotFound if the token doesn't exist or has been used.
+func (s *Storage) ResetPassword(ctx context.Context, tokenID string, userID int64, passHash []byte) error {
+	const op = "storage.sqlite.ResetPassword"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM email_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrEmailTokenNotFound)
+	}
+
+	res, err = tx.ExecContext(ctx, "UPDATE users SET pass_hash = ? WHERE id = ?", passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err = res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteUserEmailTokens invalidates the user's outstanding tokens of the purpose.
+func (s *Storage) DeleteUserEmailTokens(ctx context.Context, userID int64, purpose string) error {
+	const op = "storage.sqlite.DeleteUserEmailTokens"
+
+	stmt, err := s.db.Prepare("DELETE FROM email_tokens WHERE user_id = ? AND purpose = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, userID, purpose); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RecordLoginFailure increments the failure counter of the key and returns it.
+// Failures before since are forgotten.
+func (s *Storage) RecordLoginFailure(ctx context.Context, key string, since time.Time) (int, error) {
+	const op = "storage.sqlite.RecordLoginFailure"
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO login_throttle(key, failures, last_failure, blocked_until)
+		VALUES(?, 1, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttle.last_failure < ? THEN 1 ELSE login_throttle.failures + 1 END,
+			last_failure = excluded.last_failure
+		RETURNING failures`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var failures int
+	err = stmt.QueryRowContext(ctx, key, time.Now().UTC(), time.Time{}, since.UTC()).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, nil
+}
+
+func (s *Storage) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	const op = "storage.sqlite.BlockLogin"
+
+	stmt, err := s.db.Prepare("UPDATE login_throttle SET blocked_until = ? WHERE key = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, until.UTC(), key); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// LoginBlockedUntil returns zero time if the key is not blocked.
+func (s *Storage) LoginBlockedUntil(ctx context.Context, key string) (time.Time, error) {
+	const op = "storage.sqlite.LoginBlockedUntil"
+
+	stmt, err := s.db.Prepare("SELECT blocked_until FROM login_throttle WHERE key = ?")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	var until time.Time
+	err = stmt.QueryRowContext(ctx, key).Scan(&until)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return until, nil
+}
+
+func (s *Storage) ResetLoginFailures(ctx context.Context, key string) error {
+	const op = "storage.sqlite.ResetLoginFailures"
+
+	stmt, err := s.db.Prepare("DELETE FROM login_throttle WHERE key = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, key); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteStaleLoginFailures removes counters with no failures since
+// the given time that no longer block logins.
+func (s *Storage) DeleteStaleLoginFailures(ctx context.Context, since time.Time) error {
+	const op = "storage.sqlite.DeleteStaleLoginFailures"
+
+	stmt, err := s.db.Prepare("DELETE FROM login_throttle WHERE last_failure < ? AND blocked_until < ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, since.UTC(), time.Now().UTC()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListUsers returns up to limit users with IDs greater than afterID, ordered by ID.
+// If emailQuery is not empty, only users whose email contains it are returned.
+func (s *Storage) ListUsers(ctx context.Context, emailQuery string, afterID int64, limit int) ([]models.User, error) {
+	const op = "storage.sqlite.ListUsers"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, email, email_verified, disabled FROM users
+		WHERE id > ? AND (? = '' OR email LIKE ? ESCAPE '\')
+		ORDER BY id
+		LIMIT ?`,
+		afterID, emailQuery, containsPattern(emailQuery), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Email, &user.EmailVerified, &user.Disabled); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return users, nil
+}
+
+// containsPattern returns a LIKE pattern matching strings that contain s.
+func containsPattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+
+	return "%" + s + "%"
+}
+
+func (s *Storage) SetUserDisabled(ctx context.Context, userID int64, disabled bool) error {
+	const op = "storage.sqlite.SetUserDisabled"
+
+	stmt, err := s.db.Prepare("UPDATE users SET disabled = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, disabled, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// DeleteUser deletes the user with their sessions, roles and other data.
+func (s *Storage) DeleteUser(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.DeleteUser"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// Foreign keys are not enforced unless enabled per connection,
+	// so ON DELETE CASCADE can't be relied on
+	for _, table := range []string{
+		"refresh_tokens",
+		"user_roles",
+		"authorization_codes",
+		"user_totp",
+		"recovery_codes",
+		"passkeys",
+		"email_tokens",
+	} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = ?", userID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SetUserRoles replaces the roles of the user in the app.
+func (s *Storage) SetUserRoles(ctx context.Context, userID int64, appID int, roles []string) error {
+	const op = "storage.sqlite.SetUserRoles"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = ? AND app_id = ?", userID, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, role := range roles {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO user_roles(user_id, app_id, role) VALUES(?, ?, ?)
+			ON CONFLICT (user_id, app_id, role) DO NOTHING`,
+			userID, appID, role,
+		)
+		if err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+			}
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveApp creates the app and returns its ID.
+// Returns storage.ErrAppExists if an app with the name exists.
+func (s *Storage) SaveApp(ctx context.Context, app models.App) (int, error) {
+	const op = "storage.sqlite.SaveApp"
+
+	secret, err := s.encryptSecret(app.Secret)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.db.Prepare(`
+		INSERT INTO apps(name, secret, allowed_scopes, redirect_uris, client_secret_hash,
+			client_scopes, audiences, require_verified_email, grant_types, access_token_ttl, refresh_token_ttl, omit_claims)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx,
+		app.Name,
+		secret,
+		scopes.Format(app.Scopes),
+		strings.Join(app.RedirectURIs, " "),
+		app.ClientSecretHash,
+		scopes.Format(app.ClientScopes),
+		strings.Join(app.Audiences, " "),
+		app.RequireVerifiedEmail,
+		strings.Join(app.GrantTypes, " "),
+		int64(app.AccessTokenTTL/time.Second),
+		int64(app.RefreshTokenTTL/time.Second),
+		strings.Join(app.OmitClaims, " "),
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int(id), nil
+}
+
+// Apps returns up to limit apps with IDs greater than afterID, ordered by ID.
+func (s *Storage) Apps(ctx context.Context, afterID int, limit int) ([]models.App, error) {
+	const op = "storage.sqlite.Apps"
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+appColumns+" FROM apps WHERE id > ? ORDER BY id LIMIT ?",
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	apps := []models.App{}
+	for rows.Next() {
+		app, err := s.scanApp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		apps = append(apps, app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return apps, nil
+}
+
+// UpdateApp saves the settings of the app. Secrets are left as they are.
+func (s *Storage) UpdateApp(ctx context.Context, app models.App) error {
+	const op = "storage.sqlite.UpdateApp"
+
+	stmt, err := s.db.Prepare(`
+		UPDATE apps SET
+			name = ?,
+			allowed_scopes = ?,
+			redirect_uris = ?,
+			client_scopes = ?,
+			audiences = ?,
+			require_verified_email = ?,
+			grant_types = ?,
+			access_token_ttl = ?,
+			refresh_token_ttl = ?,
+			omit_claims = ?
+		WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx,
+		app.Name,
+		scopes.Format(app.Scopes),
+		strings.Join(app.RedirectURIs, " "),
+		scopes.Format(app.ClientScopes),
+		strings.Join(app.Audiences, " "),
+		app.RequireVerifiedEmail,
+		strings.Join(app.GrantTypes, " "),
+		int64(app.AccessTokenTTL/time.Second),
+		int64(app.RefreshTokenTTL/time.Second),
+		strings.Join(app.OmitClaims, " "),
+		app.ID,
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// DeleteApp deletes the app with its keys, roles and tokens.
+func (s *Storage) DeleteApp(ctx context.Context, id int) error {
+	const op = "storage.sqlite.DeleteApp"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// See DeleteUser
+	for _, table := range []string{
+		"refresh_tokens",
+		"app_keys",
+		"user_roles",
+		"role_permissions",
+		"authorization_codes",
+	} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE app_id = ?", id); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM apps WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveAuditEvents appends the events to the audit log.
+func (s *Storage) SaveAuditEvents(ctx context.Context, events []models.AuditEvent) error {
+	const op = "storage.sqlite.SaveAuditEvents"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO audit_events(created_at, type, outcome, user_id, email, app_id, ip, user_agent, action, target, reason)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		_, err := stmt.ExecContext(ctx,
+			event.CreatedAt.UTC(),
+			event.Type,
+			event.Outcome,
+			event.UserID,
+			event.Email,
+			event.AppID,
+			event.IP,
+			event.UserAgent,
+			event.Action,
+			event.Target,
+			event.Reason,
+		)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AuditEvents returns up to limit events matching the filter with IDs
+// less than beforeID, newest first. Zero beforeID starts from the newest event.
+func (s *Storage) AuditEvents(
+	ctx context.Context,
+	filter models.AuditFilter,
+	beforeID int64,
+	limit int,
+) ([]models.AuditEvent, error) {
+	const op = "storage.sqlite.AuditEvents"
+
+	var (
+		where []string
+		args  []any
+	)
+	cond := func(expr string, arg any) {
+		args = append(args, arg)
+		where = append(where, expr)
+	}
+
+	cond("id < ?", cmp.Or(beforeID, math.MaxInt64))
+	if filter.Type != "" {
+		cond("type = ?", filter.Type)
+	}
+	if filter.Outcome != "" {
+		cond("outcome = ?", filter.Outcome)
+	}
+	if filter.UserID != 0 {
+		cond("user_id = ?", filter.UserID)
+	}
+	if filter.Email != "" {
+		cond("email = ?", filter.Email)
+	}
+	if filter.AppID != 0 {
+		cond("app_id = ?", filter.AppID)
+	}
+	if filter.IP != "" {
+		cond("ip = ?", filter.IP)
+	}
+	if !filter.Since.IsZero() {
+		cond("created_at >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		cond("created_at < ?", filter.Until.UTC())
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, created_at, type, outcome, user_id, email, app_id, ip, user_agent, action, target, reason
+		FROM audit_events
+		WHERE `+strings.Join(where, " AND ")+" ORDER BY id DESC LIMIT ?",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.Type,
+			&event.Outcome,
+			&event.UserID,
+			&event.Email,
+			&event.AppID,
+			&event.IP,
+			&event.UserAgent,
+			&event.Action,
+			&event.Target,
+			&event.Reason,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// ReencryptSecrets encrypts with the current key every secret stored
+// in plaintext or encrypted with another key: secrets of apps, private
+// keys of apps and TOTP secrets. Returns the number of updated rows.
+func (s *Storage) ReencryptSecrets(ctx context.Context) (int, error) {
+	const op = "storage.sqlite.ReencryptSecrets"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	type appSecret struct {
+		id     int
+		secret string
+	}
+
+	var apps []appSecret
+	err = collect(ctx, tx, "SELECT id, secret FROM apps", func(row rowScanner) error {
+		var app appSecret
+		if err := row.Scan(&app.id, &app.secret); err != nil {
+			return err
+		}
+
+		if !s.isCurrent(app.secret) {
+			apps = append(apps, app)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, app := range apps {
+		secret, err := s.reencryptSecret(app.secret)
+		if err != nil {
+			return 0, fmt.Errorf("%s: app %d: %w", op, app.id, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE apps SET secret = ? WHERE id = ?", secret, app.id); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	type privateKey struct {
+		id   string
+		data []byte
+	}
+
+	var keys []privateKey
+	err = collect(ctx, tx, "SELECT id, private_key FROM app_keys", func(row rowScanner) error {
+		var key privateKey
+		if err := row.Scan(&key.id, &key.data); err != nil {
+			return err
+		}
+
+		if !s.secrets.IsCurrent(key.data) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, key := range keys {
+		data, err := s.decrypt(key.data)
+		if err != nil {
+			return 0, fmt.Errorf("%s: key %s: %w", op, key.id, err)
+		}
+		if data, err = s.secrets.Encrypt(data); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE app_keys SET private_key = ? WHERE id = ?", data, key.id); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	type totpSecret struct {
+		userID int64
+		data   []byte
+	}
+
+	var totps []totpSecret
+	err = collect(ctx, tx, "SELECT user_id, secret FROM user_totp", func(row rowScanner) error {
+		var totp totpSecret
+		if err := row.Scan(&totp.userID, &totp.data); err != nil {
+			return err
+		}
+
+		if !s.secrets.IsCurrent(totp.data) {
+			totps = append(totps, totp)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, totp := range totps {
+		// TOTP secrets have always been stored encrypted, the ones
+		// without a key version with the legacy MFA key
+		data, err := s.secrets.Decrypt(totp.data)
+		if err != nil {
+			return 0, fmt.Errorf("%s: totp of user %d: %w", op, totp.userID, err)
+		}
+		if data, err = s.secrets.Encrypt(data); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE user_totp SET secret = ? WHERE user_id = ?", data, totp.userID); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return len(apps) + len(keys) + len(totps), nil
+}
+
+// collect calls scan for every row of the query.
+func collect(ctx context.Context, tx *sql.Tx, query string, scan func(row rowScanner) error) error {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// decrypt returns data as is if it was stored before secrets were encrypted.
+func (s *Storage) decrypt(data []byte) ([]byte, error) {
+	if !encryption.IsEncrypted(data) {
+		return data, nil
+	}
+
+	return s.secrets.Decrypt(data)
+}
+
+func (s *Storage) encryptSecret(secret string) (string, error) {
+	data, err := s.secrets.Encrypt([]byte(secret))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func (s *Storage) decryptSecret(stored string) (string, error) {
+	data, err := s.decrypt([]byte(stored))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// isCurrent reports whether the secret needs no re-encryption.
+// Empty secrets, such as the refresh secrets of old apps, are left empty.
+func (s *Storage) isCurrent(secret string) bool {
+	return secret == "" || s.secrets.IsCurrent([]byte(secret))
+}
+
+func (s *Storage) reencryptSecret(stored string) (string, error) {
+	if stored == "" {
+		return "", nil
+	}
+
+	secret, err := s.decryptSecret(stored)
+	if err != nil {
+		return "", err
+	}
+
+	return s.encryptSecret(secret)
+}
+
+// scanRevokedSessions reads the family_id and expires_at of revoked
+// refresh tokens into one token per session, the one expiring last.
+func scanRevokedSessions(rows *sql.Rows) ([]models.RefreshToken, error) {
+	defer rows.Close()
+
+	var sessions []models.RefreshToken
+	latest := make(map[string]int)
+	for rows.Next() {
+		var token models.RefreshToken
+		if err := rows.Scan(&token.FamilyID, &token.ExpiresAt); err != nil {
+			return nil, err
+		}
+
+		i, ok := latest[token.FamilyID]
+		if !ok {
+			latest[token.FamilyID] = len(sessions)
+			sessions = append(sessions, token)
+
+			continue
+		}
+		if token.ExpiresAt.After(sessions[i].ExpiresAt) {
+			sessions[i].ExpiresAt = token.ExpiresAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
 func (s *Storage) Stop() {
 	s.db.Close()
 }
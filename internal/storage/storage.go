@@ -6,4 +6,26 @@ var (
 	ErrUserExists   = errors.New("user already exists")
 	ErrUserNotFound = errors.New("user not found")
 	ErrAppNotFound  = errors.New("app not foud")
+	ErrAppExists    = errors.New("app already exists")
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenUsed     = errors.New("refresh token already used")
+
+	ErrKeyNotFound = errors.New("signing key not found")
+
+	ErrRoleNotFound = errors.New("role not found")
+
+	ErrAuthCodeNotFound = errors.New("authorization code not found")
+
+	ErrTOTPNotFound         = errors.New("totp not found")
+	ErrTOTPStepUsed         = errors.New("totp code already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	ErrMFAChallengeNotFound = errors.New("mfa challenge not found")
+
+	ErrPasskeyExists       = errors.New("passkey already registered")
+	ErrPasskeyNotFound     = errors.New("passkey not found")
+	ErrSignCountRegression = errors.New("passkey sign count did not increase")
+	ErrChallengeNotFound   = errors.New("webauthn challenge not found")
+
+	ErrEmailTokenNotFound = errors.New("email token not found")
 )
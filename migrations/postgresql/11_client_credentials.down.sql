@@ -0,0 +1,2 @@
+ALTER TABLE apps DROP COLUMN IF EXISTS audiences;
+ALTER TABLE apps DROP COLUMN IF EXISTS client_scopes;
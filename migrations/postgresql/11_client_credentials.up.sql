@@ -0,0 +1,2 @@
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS client_scopes TEXT NOT NULL DEFAULT '';
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS audiences TEXT NOT NULL DEFAULT '';
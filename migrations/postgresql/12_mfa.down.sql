@@ -0,0 +1,4 @@
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS amr;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS amr;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id         BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret          BYTEA NOT NULL,
+    confirmed       BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step  BIGINT NOT NULL DEFAULT 0,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id     BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash   BYTEA NOT NULL,
+    used        BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+-- Sessions keep authentication methods (RFC 8176), earlier ones were password logins
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS amr TEXT NOT NULL DEFAULT '';
+UPDATE refresh_tokens SET amr = 'pwd';
+
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS amr TEXT NOT NULL DEFAULT '';
+UPDATE authorization_codes SET amr = 'pwd';
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id              BYTEA PRIMARY KEY,
+    user_id         BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    public_key      BYTEA NOT NULL,
+    algorithm       VARCHAR(16) NOT NULL,
+    sign_count      BIGINT NOT NULL DEFAULT 0,
+    transports      TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys (user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    challenge   TEXT PRIMARY KEY,
+    user_id     BIGINT NOT NULL DEFAULT 0,
+    purpose     VARCHAR(16) NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webauthn_challenges_expires_at ON webauthn_challenges (expires_at);
@@ -0,0 +1,7 @@
+-- Failed login counters by account or client address
+CREATE TABLE IF NOT EXISTS login_throttle (
+    key            TEXT PRIMARY KEY,
+    failures       INTEGER NOT NULL,
+    last_failure   TIMESTAMPTZ NOT NULL,
+    blocked_until  TIMESTAMPTZ NOT NULL
+);
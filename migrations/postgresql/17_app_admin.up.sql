@@ -0,0 +1,6 @@
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS grant_types TEXT NOT NULL DEFAULT 'authorization_code refresh_token client_credentials';
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS access_token_ttl BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS refresh_token_ttl BIGINT NOT NULL DEFAULT 0;
+
+-- Apps inserted by hand with explicit ids don't advance the sequence
+SELECT setval(pg_get_serial_sequence('apps', 'id'), COALESCE((SELECT MAX(id) FROM apps), 0) + 1, false);
@@ -0,0 +1 @@
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS omit_claims TEXT NOT NULL DEFAULT '';
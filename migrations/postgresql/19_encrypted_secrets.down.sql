@@ -0,0 +1,2 @@
+ALTER TABLE apps ALTER COLUMN refresh_secret TYPE VARCHAR(255);
+ALTER TABLE apps ALTER COLUMN secret TYPE VARCHAR(255);
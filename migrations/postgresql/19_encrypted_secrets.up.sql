@@ -0,0 +1,3 @@
+-- Encrypted secrets are longer than the plaintext ones
+ALTER TABLE apps ALTER COLUMN secret TYPE TEXT;
+ALTER TABLE apps ALTER COLUMN refresh_secret TYPE TEXT;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id          VARCHAR(64) PRIMARY KEY,
+    family_id   VARCHAR(64) NOT NULL,
+    user_id     BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id      BIGINT NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    token_hash  BYTEA NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    used        BOOLEAN NOT NULL DEFAULT FALSE,
+    revoked     BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
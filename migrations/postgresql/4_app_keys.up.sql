@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS app_keys (
+    id          VARCHAR(64) PRIMARY KEY,
+    app_id      BIGINT NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    algorithm   VARCHAR(16) NOT NULL,
+    private_key BYTEA NOT NULL,
+    public_key  BYTEA NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_app_keys_app_id ON app_keys (app_id);
@@ -0,0 +1,2 @@
+ALTER TABLE app_keys DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE app_keys DROP COLUMN IF EXISTS state;
@@ -0,0 +1,2 @@
+ALTER TABLE app_keys ADD COLUMN IF NOT EXISTS state VARCHAR(16) NOT NULL DEFAULT 'active';
+ALTER TABLE app_keys ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id     BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id      BIGINT NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    role        VARCHAR(64) NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, app_id, role)
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    app_id      BIGINT NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    role        VARCHAR(64) NOT NULL,
+    permission  VARCHAR(128) NOT NULL,
+    PRIMARY KEY (app_id, role, permission)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS scopes;
+ALTER TABLE apps DROP COLUMN IF EXISTS allowed_scopes;
@@ -0,0 +1,14 @@
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS redirect_uris TEXT NOT NULL DEFAULT '';
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS client_secret_hash BYTEA;
+
+CREATE TABLE IF NOT EXISTS authorization_codes (
+    code_hash       VARCHAR(64) PRIMARY KEY,
+    app_id          BIGINT NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    user_id         BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri    TEXT NOT NULL,
+    scopes          TEXT NOT NULL DEFAULT '',
+    code_challenge  VARCHAR(128) NOT NULL,
+    expires_at      TIMESTAMPTZ NOT NULL,
+    used            BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+ALTER TABLE apps DROP COLUMN audiences;
+ALTER TABLE apps DROP COLUMN client_scopes;
@@ -0,0 +1,2 @@
+ALTER TABLE apps ADD COLUMN client_scopes TEXT NOT NULL DEFAULT '';
+ALTER TABLE apps ADD COLUMN audiences TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS user_totp
+(
+    user_id         INTEGER  PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret          BLOB     NOT NULL,
+    confirmed       BOOLEAN  NOT NULL DEFAULT 0,
+    last_used_step  INTEGER  NOT NULL DEFAULT 0,
+    created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash   BLOB    NOT NULL,
+    used        BOOLEAN NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+-- Sessions keep authentication methods (RFC 8176), earlier ones were password logins
+ALTER TABLE refresh_tokens ADD COLUMN amr TEXT NOT NULL DEFAULT '';
+UPDATE refresh_tokens SET amr = 'pwd';
+
+ALTER TABLE authorization_codes ADD COLUMN amr TEXT NOT NULL DEFAULT '';
+UPDATE authorization_codes SET amr = 'pwd';
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS passkeys
+(
+    id              BLOB     PRIMARY KEY,
+    user_id         INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    public_key      BLOB     NOT NULL,
+    algorithm       TEXT     NOT NULL,
+    sign_count      INTEGER  NOT NULL DEFAULT 0,
+    transports      TEXT     NOT NULL DEFAULT '',
+    created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at    DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys (user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges
+(
+    challenge   TEXT     PRIMARY KEY,
+    user_id     INTEGER  NOT NULL DEFAULT 0,
+    purpose     TEXT     NOT NULL,
+    expires_at  DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webauthn_challenges_expires_at ON webauthn_challenges (expires_at);
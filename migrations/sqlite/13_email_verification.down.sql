@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS email_tokens;
+ALTER TABLE apps DROP COLUMN require_verified_email;
+ALTER TABLE users DROP COLUMN email_verified;
@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE apps ADD COLUMN require_verified_email BOOLEAN NOT NULL DEFAULT 0;
+
+-- Single-use tokens sent by email, the token itself is a signed JWT
+CREATE TABLE IF NOT EXISTS email_tokens
+(
+    id          TEXT     PRIMARY KEY,
+    user_id     INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose     TEXT     NOT NULL,
+    expires_at  DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_email_tokens_user ON email_tokens (user_id, purpose);
@@ -0,0 +1,8 @@
+-- Failed login counters by account or client address
+CREATE TABLE IF NOT EXISTS login_throttle
+(
+    key            TEXT     PRIMARY KEY,
+    failures       INTEGER  NOT NULL,
+    last_failure   DATETIME NOT NULL,
+    blocked_until  DATETIME NOT NULL
+);
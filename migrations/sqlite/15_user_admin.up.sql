@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE apps DROP COLUMN refresh_token_ttl;
+ALTER TABLE apps DROP COLUMN access_token_ttl;
+ALTER TABLE apps DROP COLUMN grant_types;
@@ -0,0 +1,3 @@
+ALTER TABLE apps ADD COLUMN grant_types TEXT NOT NULL DEFAULT 'authorization_code refresh_token client_credentials';
+ALTER TABLE apps ADD COLUMN access_token_ttl INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE apps ADD COLUMN refresh_token_ttl INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE apps ADD COLUMN omit_claims TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,32 @@
+-- Security events, see models.AuditEvent. There are no foreign keys,
+-- events outlive the users and apps they mention.
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id          INTEGER  PRIMARY KEY,
+    created_at  DATETIME NOT NULL,
+    type        TEXT     NOT NULL,
+    outcome     TEXT     NOT NULL,
+    user_id     INTEGER  NOT NULL DEFAULT 0,
+    email       TEXT     NOT NULL DEFAULT '',
+    app_id      INTEGER  NOT NULL DEFAULT 0,
+    ip          TEXT     NOT NULL DEFAULT '',
+    user_agent  TEXT     NOT NULL DEFAULT '',
+    action      TEXT     NOT NULL DEFAULT '',
+    target      TEXT     NOT NULL DEFAULT '',
+    reason      TEXT     NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events (user_id, id);
+
+-- The log is append-only
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update
+BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete
+BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
@@ -0,0 +1,9 @@
+-- MFA tokens not used yet, a token is accepted only while its row exists
+CREATE TABLE IF NOT EXISTS mfa_challenges
+(
+    id          TEXT     PRIMARY KEY,
+    user_id     INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    attempts    INTEGER  NOT NULL DEFAULT 0,
+    expires_at  DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_expires_at ON mfa_challenges (expires_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS app_keys
+(
+    id          TEXT     PRIMARY KEY,
+    app_id      INTEGER  NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    algorithm   TEXT     NOT NULL,
+    private_key BLOB     NOT NULL,
+    public_key  BLOB     NOT NULL,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_app_keys_app_id ON app_keys (app_id);
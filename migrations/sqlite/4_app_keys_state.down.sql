@@ -0,0 +1,2 @@
+ALTER TABLE app_keys DROP COLUMN updated_at;
+ALTER TABLE app_keys DROP COLUMN state;
@@ -0,0 +1,2 @@
+ALTER TABLE app_keys ADD COLUMN state TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE app_keys ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';
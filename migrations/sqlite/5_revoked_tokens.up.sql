@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    jti         TEXT     PRIMARY KEY,
+    expires_at  DATETIME NOT NULL,
+    revoked_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
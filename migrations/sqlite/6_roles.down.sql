@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS user_roles;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS user_roles
+(
+    user_id     INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    app_id      INTEGER  NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    role        TEXT     NOT NULL,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, app_id, role)
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    app_id      INTEGER NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    role        TEXT    NOT NULL,
+    permission  TEXT    NOT NULL,
+    PRIMARY KEY (app_id, role, permission)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE apps ADD COLUMN allowed_scopes TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN scopes TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS authorization_codes;
+ALTER TABLE apps DROP COLUMN client_secret_hash;
+ALTER TABLE apps DROP COLUMN redirect_uris;
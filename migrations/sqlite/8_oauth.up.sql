@@ -0,0 +1,15 @@
+ALTER TABLE apps ADD COLUMN redirect_uris TEXT NOT NULL DEFAULT '';
+ALTER TABLE apps ADD COLUMN client_secret_hash BLOB;
+
+CREATE TABLE IF NOT EXISTS authorization_codes
+(
+    code_hash       TEXT     PRIMARY KEY,
+    app_id          INTEGER  NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+    user_id         INTEGER  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri    TEXT     NOT NULL,
+    scopes          TEXT     NOT NULL DEFAULT '',
+    code_challenge  TEXT     NOT NULL,
+    expires_at      DATETIME NOT NULL,
+    used            BOOLEAN  NOT NULL DEFAULT 0,
+    created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
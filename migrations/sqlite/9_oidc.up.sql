@@ -0,0 +1,2 @@
+ALTER TABLE authorization_codes ADD COLUMN nonce TEXT NOT NULL DEFAULT '';
+ALTER TABLE authorization_codes ADD COLUMN auth_time DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';
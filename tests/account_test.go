@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestChangePassword_RevokesOtherSessions(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+	newPass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	// Две сессии: текущая и на другом устройстве
+	current, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	other, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	// Проверка до смены пароля кэширует, что сессия не отозвана
+	respValidate, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: other.GetToken()})
+	require.NoError(t, err)
+	require.True(t, respValidate.GetActive())
+
+	_, err = st.AuthClient.ChangePassword(ctx, &ssov1.ChangePasswordRequest{CurrentPassword: pass, NewPassword: newPass})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.ChangePassword(withToken(ctx, current.GetToken()), &ssov1.ChangePasswordRequest{
+		CurrentPassword: "wrong password",
+		NewPassword:     newPass,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.ChangePassword(withToken(ctx, current.GetToken()), &ssov1.ChangePasswordRequest{
+		CurrentPassword: pass,
+		NewPassword:     newPass,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.Error(t, err)
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: newPass, AppId: appID})
+	require.NoError(t, err)
+
+	// Другая сессия отозвана вместе с ее access-токеном
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{RefreshToken: other.GetRefreshToken(), AppId: appID})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	respValidate, err = st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: other.GetToken()})
+	require.NoError(t, err)
+	assert.False(t, respValidate.GetActive())
+	assert.True(t, respValidate.GetRevoked())
+
+	// Текущая сессия продолжает работать
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{RefreshToken: current.GetRefreshToken(), AppId: appID})
+	require.NoError(t, err)
+}
+
+func TestChangeEmail_RequiresConfirmation(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	newEmail := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	authCtx := withToken(ctx, respLogin.GetToken())
+
+	_, err = st.AuthClient.ChangeEmail(authCtx, &ssov1.ChangeEmailRequest{CurrentPassword: pass, NewEmail: newEmail})
+	require.NoError(t, err)
+
+	// До подтверждения email не меняется
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: newEmail, Password: pass, AppId: appID})
+	require.Error(t, err)
+
+	respVerify, err := st.AuthClient.VerifyEmail(ctx, &ssov1.VerifyEmailRequest{Token: lastEmailToken(t, st, newEmail)})
+	require.NoError(t, err)
+	assert.Equal(t, respReg.GetUserId(), respVerify.GetUserId())
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.Error(t, err)
+
+	// Новый email подтвержден, приложение с требованием подтверждения пускает
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: newEmail, Password: pass, AppId: verifiedEmailAppID})
+	require.NoError(t, err)
+}
+
+func TestChangeEmail_Fails(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+	takenEmail := gofakeit.Email()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: takenEmail, Password: randomFakePassword()})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	authCtx := withToken(ctx, respLogin.GetToken())
+
+	tests := []struct {
+		name     string
+		password string
+		newEmail string
+		code     codes.Code
+	}{
+		{name: "Email taken", password: pass, newEmail: takenEmail, code: codes.AlreadyExists},
+		{name: "Wrong password", password: "wrong password", newEmail: gofakeit.Email(), code: codes.InvalidArgument},
+		{name: "Invalid email", password: pass, newEmail: "not an email", code: codes.InvalidArgument},
+		{name: "Empty password", password: "", newEmail: gofakeit.Email(), code: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.ChangeEmail(authCtx, &ssov1.ChangeEmailRequest{
+				CurrentPassword: tt.password,
+				NewEmail:        tt.newEmail,
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestChangePassword_WrongPasswordsAreThrottled(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	authCtx := withToken(ctx, respLogin.GetToken())
+
+	// Подбор текущего пароля по украденному токену ограничен так же, как логин
+	for i := 0; i <= st.Cfg.Throttle.FreeAttempts; i++ {
+		_, err = st.AuthClient.ChangePassword(authCtx, &ssov1.ChangePasswordRequest{
+			CurrentPassword: "wrong password",
+			NewPassword:     randomFakePassword(),
+		})
+		require.Error(t, err)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), "attempt %d", i+1)
+	}
+
+	_, err = st.AuthClient.ChangeEmail(authCtx, &ssov1.ChangeEmailRequest{
+		CurrentPassword: pass,
+		NewEmail:        gofakeit.Email(),
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,243 @@
+package tests
+
+import (
+	"sso/internal/domain/models"
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminApps_RequiresPermission(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, userToken := registerAndLogin(ctx, t, st)
+
+	_, err := st.AdminClient.ListApps(withToken(ctx, userToken), &ssov1.ListAppsRequest{AppId: appID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAdminApps_OnlyOwnApp(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// Администратор другого приложения управляет только им
+	_, otherAdminCtx := appAdminContext(ctx, t, st, rotationAppID)
+
+	respApp, err := st.AdminClient.GetApp(otherAdminCtx, &ssov1.GetAppRequest{AppId: rotationAppID, ClientId: rotationAppID})
+	require.NoError(t, err)
+	assert.Equal(t, int32(rotationAppID), respApp.GetApp().GetId())
+
+	_, err = st.AdminClient.GetApp(otherAdminCtx, &ssov1.GetAppRequest{AppId: rotationAppID, ClientId: appID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AdminClient.UpdateApp(otherAdminCtx, &ssov1.UpdateAppRequest{
+		AppId: rotationAppID,
+		App:   &ssov1.App{Id: appID, Name: "test", RedirectUris: []string{"https://evil.example.com/callback"}},
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AdminClient.RotateAppSecret(otherAdminCtx, &ssov1.RotateAppSecretRequest{AppId: rotationAppID, ClientId: appID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AdminClient.DeleteApp(otherAdminCtx, &ssov1.DeleteAppRequest{AppId: rotationAppID, ClientId: appID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AdminClient.ListApps(otherAdminCtx, &ssov1.ListAppsRequest{AppId: rotationAppID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AdminClient.CreateApp(otherAdminCtx, &ssov1.CreateAppRequest{
+		AppId: rotationAppID,
+		App:   &ssov1.App{Name: "app-" + gofakeit.LetterN(10)},
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAdminApps_CreateRotateAndDelete(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	name := "app-" + gofakeit.LetterN(10)
+
+	created, err := st.AdminClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{
+		AppId: appID,
+		App: &ssov1.App{
+			Name:         name,
+			ClientScopes: []string{"orders:read"},
+			Audiences:    []string{"orders-api"},
+			GrantTypes:   []string{models.GrantClientCredentials},
+			Confidential: true,
+		},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, created.GetClientSecret())
+
+	clientID := created.GetApp().GetId()
+	assert.NotZero(t, clientID)
+	assert.True(t, created.GetApp().GetConfidential())
+
+	_, err = st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		ClientId:     clientID,
+		ClientSecret: created.GetClientSecret(),
+	})
+	require.NoError(t, err)
+
+	// Имя приложения уникально
+	_, err = st.AdminClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{AppId: appID, App: &ssov1.App{Name: name}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	rotated, err := st.AdminClient.RotateAppSecret(adminCtx, &ssov1.RotateAppSecretRequest{AppId: appID, ClientId: clientID})
+	require.NoError(t, err)
+	require.NotEqual(t, created.GetClientSecret(), rotated.GetClientSecret())
+
+	// Старый секрет перестаёт работать сразу
+	_, err = st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		ClientId:     clientID,
+		ClientSecret: created.GetClientSecret(),
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		ClientId:     clientID,
+		ClientSecret: rotated.GetClientSecret(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AdminClient.DeleteApp(adminCtx, &ssov1.DeleteAppRequest{AppId: appID, ClientId: clientID})
+	require.NoError(t, err)
+
+	_, err = st.AdminClient.GetApp(adminCtx, &ssov1.GetAppRequest{AppId: appID, ClientId: clientID})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Приложение, через которое пришёл запрос, удалить нельзя
+	_, err = st.AdminClient.DeleteApp(adminCtx, &ssov1.DeleteAppRequest{AppId: appID, ClientId: appID})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestAdminApps_GrantTypes(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	created, err := st.AdminClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{
+		AppId: appID,
+		App: &ssov1.App{
+			Name:         "app-" + gofakeit.LetterN(10),
+			RedirectUris: []string{"https://example.com/callback"},
+			GrantTypes:   []string{models.GrantAuthorizationCode},
+			Confidential: true,
+		},
+	})
+	require.NoError(t, err)
+
+	app := created.GetApp()
+
+	_, err = st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		ClientId:     app.GetId(),
+		ClientSecret: created.GetClientSecret(),
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login := &ssov1.LoginRequest{Email: email, Password: pass, AppId: app.GetId()}
+
+	respLogin, err := st.AuthClient.Login(ctx, login)
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{RefreshToken: respLogin.GetRefreshToken(), AppId: app.GetId()})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	app.GrantTypes = append(app.GrantTypes, models.GrantRefreshToken)
+	app.AccessTokenTtlSeconds = 600
+
+	updated, err := st.AdminClient.UpdateApp(adminCtx, &ssov1.UpdateAppRequest{AppId: appID, App: app})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{models.GrantAuthorizationCode, models.GrantRefreshToken}, updated.GetApp().GetGrantTypes())
+	assert.Equal(t, int64(600), updated.GetApp().GetAccessTokenTtlSeconds())
+	// Секрет при обновлении сохраняется
+	assert.True(t, updated.GetApp().GetConfidential())
+
+	respLogin, err = st.AuthClient.Login(ctx, login)
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{RefreshToken: respLogin.GetRefreshToken(), AppId: app.GetId()})
+	require.NoError(t, err)
+}
+
+func TestAdminApps_ListAndValidation(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	var ids []int32
+	for i := 0; i < 2; i++ {
+		created, err := st.AdminClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{
+			AppId: appID,
+			App:   &ssov1.App{Name: "app-" + gofakeit.LetterN(10)},
+		})
+		require.NoError(t, err)
+
+		// Без явных grant types приложению доступны все
+		assert.Len(t, created.GetApp().GetGrantTypes(), 3)
+		assert.False(t, created.GetApp().GetConfidential())
+
+		ids = append(ids, created.GetApp().GetId())
+	}
+
+	var listed []int32
+	req := &ssov1.ListAppsRequest{AppId: appID, PageSize: 1}
+	for {
+		page, err := st.AdminClient.ListApps(adminCtx, req)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.GetApps()), 1)
+
+		for _, app := range page.GetApps() {
+			listed = append(listed, app.GetId())
+		}
+
+		if page.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = page.GetNextPageToken()
+	}
+	assert.Subset(t, listed, ids)
+
+	tests := []struct {
+		name string
+		app  *ssov1.App
+	}{
+		{
+			name: "Empty name",
+			app:  &ssov1.App{Name: " "},
+		},
+		{
+			name: "Unknown grant type",
+			app:  &ssov1.App{Name: gofakeit.LetterN(10), GrantTypes: []string{"password"}},
+		},
+		{
+			name: "Redirect URI with fragment",
+			app:  &ssov1.App{Name: gofakeit.LetterN(10), RedirectUris: []string{"https://example.com/cb#frag"}},
+		},
+		{
+			name: "Negative TTL",
+			app:  &ssov1.App{Name: gofakeit.LetterN(10), RefreshTokenTtlSeconds: -1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AdminClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{AppId: appID, App: tt.app})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"sso/internal/domain/models"
+	"sso/internal/storage/postgresql"
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// adminContext регистрирует администратора приложения и возвращает контекст с его токеном.
+// Тестовое приложение - приложение администраторов, см. admin.app_id
+func adminContext(ctx context.Context, t *testing.T, st *suite.Suite) (int64, context.Context) {
+	t.Helper()
+
+	return appAdminContext(ctx, t, st, appID)
+}
+
+// appAdminContext регистрирует администратора приложения app
+// и возвращает контекст с его токеном для этого приложения
+func appAdminContext(ctx context.Context, t *testing.T, st *suite.Suite, app int) (int64, context.Context) {
+	t.Helper()
+
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+	require.NoError(t, storage.SaveUserRole(ctx, respReg.GetUserId(), app, models.RoleAdmin))
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: int32(app)})
+	require.NoError(t, err)
+
+	return respReg.GetUserId(), withToken(ctx, respLogin.GetToken())
+}
+
+func TestAdminUsers_RequiresPermission(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	userID, userToken := registerAndLogin(ctx, t, st)
+
+	req := &ssov1.GetUserRequest{AppId: appID, UserId: userID}
+
+	_, err := st.AdminClient.GetUser(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AdminClient.GetUser(withToken(ctx, userToken), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAdminUsers_OnlyAdminAppManagesUsers(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	userID, _ := registerAndLogin(ctx, t, st)
+
+	// Администратор другого приложения не управляет общими пользователями
+	otherAdminID, otherAdminCtx := appAdminContext(ctx, t, st, rotationAppID)
+
+	_, err := st.AdminClient.ListUsers(otherAdminCtx, &ssov1.ListUsersRequest{AppId: rotationAppID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AdminClient.DisableUser(otherAdminCtx, &ssov1.DisableUserRequest{AppId: rotationAppID, UserId: userID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AdminClient.DeleteUser(otherAdminCtx, &ssov1.DeleteUserRequest{AppId: rotationAppID, UserId: userID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Роль в приложении администраторов не действует с токеном другого приложения
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+	require.NoError(t, storage.SaveUserRole(ctx, otherAdminID, appID, models.RoleAdmin))
+
+	_, err = st.AdminClient.DisableUser(otherAdminCtx, &ssov1.DisableUserRequest{AppId: appID, UserId: userID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Роли в своём приложении назначать можно
+	_, err = st.AdminClient.SetUserRoles(otherAdminCtx, &ssov1.SetUserRolesRequest{
+		AppId:  rotationAppID,
+		UserId: userID,
+		Roles:  []string{"editor"},
+	})
+	require.NoError(t, err)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	respUser, err := st.AdminClient.GetUser(adminCtx, &ssov1.GetUserRequest{AppId: appID, UserId: userID})
+	require.NoError(t, err)
+	assert.False(t, respUser.GetUser().GetDisabled())
+}
+
+func TestAdminUsers_OnlyAppAdminsSetRoles(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// Роль поддержки управляет пользователями, но не является администратором
+	db, err := sql.Open("postgres", st.Cfg.Connection)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO role_permissions (app_id, role, permission) VALUES ($1, 'support', $2)
+		ON CONFLICT DO NOTHING`, appID, models.PermissionManageUsers)
+	require.NoError(t, err)
+
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+	supportID := respReg.GetUserId()
+	require.NoError(t, storage.SaveUserRole(ctx, supportID, appID, "support"))
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	supportCtx := withToken(ctx, respLogin.GetToken())
+
+	// Пользователями управлять можно
+	_, err = st.AdminClient.GetUser(supportCtx, &ssov1.GetUserRequest{AppId: appID, UserId: supportID})
+	require.NoError(t, err)
+
+	// А назначить себе администратора нельзя
+	_, err = st.AdminClient.SetUserRoles(supportCtx, &ssov1.SetUserRolesRequest{
+		AppId:  appID,
+		UserId: supportID,
+		Roles:  []string{models.RoleAdmin},
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	respUser, err := st.AdminClient.GetUser(adminCtx, &ssov1.GetUserRequest{AppId: appID, UserId: supportID})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"support"}, respUser.GetRoles())
+}
+
+func TestAdminUsers_ListWithSearchAndPagination(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	// Общая уникальная часть email, чтобы найти только своих пользователей
+	marker := gofakeit.LetterN(12)
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		resp, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+			Email:    gofakeit.LetterN(6) + "." + marker + "@example.com",
+			Password: randomFakePassword(),
+		})
+		require.NoError(t, err)
+
+		ids = append(ids, resp.GetUserId())
+	}
+
+	page, err := st.AdminClient.ListUsers(adminCtx, &ssov1.ListUsersRequest{
+		AppId:      appID,
+		EmailQuery: marker,
+		PageSize:   2,
+	})
+	require.NoError(t, err)
+	require.Len(t, page.GetUsers(), 2)
+	require.NotEmpty(t, page.GetNextPageToken())
+
+	last, err := st.AdminClient.ListUsers(adminCtx, &ssov1.ListUsersRequest{
+		AppId:      appID,
+		EmailQuery: marker,
+		PageSize:   2,
+		PageToken:  page.GetNextPageToken(),
+	})
+	require.NoError(t, err)
+	require.Len(t, last.GetUsers(), 1)
+	assert.Empty(t, last.GetNextPageToken())
+
+	var listed []int64
+	for _, user := range append(page.GetUsers(), last.GetUsers()...) {
+		assert.Contains(t, user.GetEmail(), marker)
+		listed = append(listed, user.GetId())
+	}
+	assert.Equal(t, ids, listed)
+
+	_, err = st.AdminClient.ListUsers(adminCtx, &ssov1.ListUsersRequest{AppId: appID, PageToken: "not a token"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAdminUsers_DisableAndEnable(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminID, adminCtx := adminContext(ctx, t, st)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+	userID := respReg.GetUserId()
+
+	login := &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID}
+
+	respLogin, err := st.AuthClient.Login(ctx, login)
+	require.NoError(t, err)
+
+	_, err = st.AdminClient.DisableUser(adminCtx, &ssov1.DisableUserRequest{AppId: appID, UserId: userID})
+	require.NoError(t, err)
+
+	user, err := st.AdminClient.GetUser(adminCtx, &ssov1.GetUserRequest{AppId: appID, UserId: userID})
+	require.NoError(t, err)
+	assert.True(t, user.GetUser().GetDisabled())
+
+	_, err = st.AuthClient.Login(ctx, login)
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Сессии, открытые до отключения, завершены
+	validated, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: respLogin.GetToken()})
+	require.NoError(t, err)
+	assert.False(t, validated.GetActive())
+
+	_, err = st.AdminClient.EnableUser(adminCtx, &ssov1.EnableUserRequest{AppId: appID, UserId: userID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, login)
+	require.NoError(t, err)
+
+	// Себя отключить нельзя
+	_, err = st.AdminClient.DisableUser(adminCtx, &ssov1.DisableUserRequest{AppId: appID, UserId: adminID})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestAdminUsers_LogoutRolesAndDelete(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+	userID := respReg.GetUserId()
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AdminClient.LogoutUser(adminCtx, &ssov1.LogoutUserRequest{AppId: appID, UserId: userID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{RefreshToken: respLogin.GetRefreshToken(), AppId: appID})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	respRoles, err := st.AdminClient.SetUserRoles(adminCtx, &ssov1.SetUserRolesRequest{
+		AppId:  appID,
+		UserId: userID,
+		Roles:  []string{"viewer", "editor", "viewer"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"editor", "viewer"}, respRoles.GetRoles())
+
+	user, err := st.AdminClient.GetUser(adminCtx, &ssov1.GetUserRequest{AppId: appID, UserId: userID})
+	require.NoError(t, err)
+	assert.Equal(t, email, user.GetUser().GetEmail())
+	assert.Equal(t, []string{"editor", "viewer"}, user.GetRoles())
+
+	respLogin, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AdminClient.DeleteUser(adminCtx, &ssov1.DeleteUserRequest{AppId: appID, UserId: userID})
+	require.NoError(t, err)
+
+	_, err = st.AdminClient.GetUser(adminCtx, &ssov1.GetUserRequest{AppId: appID, UserId: userID})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Токены удалённого пользователя больше не действуют
+	validated, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: respLogin.GetToken()})
+	require.NoError(t, err)
+	assert.False(t, validated.GetActive())
+
+	// Email снова свободен
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+}
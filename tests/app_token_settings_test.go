@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"sso/internal/domain/models"
+	"sso/tests/suite"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unverifiedClaims возвращает claims токена без проверки подписи
+func unverifiedClaims(t *testing.T, token string) jwt.MapClaims {
+	t.Helper()
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+
+	return claims
+}
+
+func TestAppTokenSettings_TTLAndClaims(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	const (
+		accessTTL  = 5 * time.Minute
+		refreshTTL = 8 * time.Hour
+	)
+
+	created, err := st.AdminClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{
+		AppId: appID,
+		App: &ssov1.App{
+			Name:                   "app-" + gofakeit.LetterN(10),
+			AccessTokenTtlSeconds:  int64(accessTTL / time.Second),
+			RefreshTokenTtlSeconds: int64(refreshTTL / time.Second),
+			OmitClaims:             []string{models.ClaimEmail},
+		},
+	})
+	require.NoError(t, err)
+
+	customAppID := created.GetApp().GetId()
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: customAppID})
+	require.NoError(t, err)
+
+	loginTime := time.Now()
+
+	const deltaSeconds = 1
+
+	claims := unverifiedClaims(t, respLogin.GetToken())
+	assert.Equal(t, respReg.GetUserId(), int64(claims["uid"].(float64)))
+	assert.NotContains(t, claims, "email")
+	assert.InDelta(t, loginTime.Add(accessTTL).Unix(), claims["exp"].(float64), deltaSeconds)
+
+	refreshClaims := unverifiedClaims(t, respLogin.GetRefreshToken())
+	assert.NotContains(t, refreshClaims, "email")
+	assert.InDelta(t, loginTime.Add(refreshTTL).Unix(), refreshClaims["exp"].(float64), deltaSeconds)
+
+	// Обновление работает без email в refresh token, настройки приложения сохраняются
+	respRefresh, err := st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+		AppId:        customAppID,
+	})
+	require.NoError(t, err)
+
+	refreshedClaims := unverifiedClaims(t, respRefresh.GetToken())
+	assert.NotContains(t, refreshedClaims, "email")
+	assert.InDelta(t, time.Now().Add(accessTTL).Unix(), refreshedClaims["exp"].(float64), deltaSeconds)
+
+	validated, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: respRefresh.GetToken()})
+	require.NoError(t, err)
+	assert.True(t, validated.GetActive())
+	assert.Empty(t, validated.GetEmail())
+
+	// Приложения без своих настроек используют глобальные
+	respDefault, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	defaultClaims := unverifiedClaims(t, respDefault.GetToken())
+	assert.Equal(t, email, defaultClaims["email"])
+	assert.InDelta(t, time.Now().Add(st.Cfg.TokenTTL).Unix(), defaultClaims["exp"].(float64), deltaSeconds)
+}
+
+func TestAppTokenSettings_ClaimsThatCantBeOmitted(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	for _, claim := range []string{"uid", "sid", "amr", "app_id"} {
+		_, err := st.AdminClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{
+			AppId: appID,
+			App: &ssov1.App{
+				Name:       "app-" + gofakeit.LetterN(10),
+				OmitClaims: []string{claim},
+			},
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), claim)
+	}
+}
@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sso/internal/domain/models"
+	"sso/tests/suite"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// waitAuditEvents ждёт, пока в журнале появится count событий по запросу:
+// события пишутся асинхронно
+func waitAuditEvents(
+	ctx context.Context,
+	t *testing.T,
+	st *suite.Suite,
+	req *ssov1.ListAuditEventsRequest,
+	count int,
+) []*ssov1.AuditEvent {
+	t.Helper()
+
+	var events []*ssov1.AuditEvent
+	require.Eventually(t, func() bool {
+		resp, err := st.AdminClient.ListAuditEvents(ctx, req)
+		require.NoError(t, err)
+
+		events = resp.GetEvents()
+
+		return len(events) >= count
+	}, 5*time.Second, 50*time.Millisecond)
+
+	return events
+}
+
+func TestAudit_RequiresPermission(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, userToken := registerAndLogin(ctx, t, st)
+
+	_, err := st.AdminClient.ListAuditEvents(withToken(ctx, userToken), &ssov1.ListAuditEventsRequest{AppId: appID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAudit_OnlyOwnAppEvents(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// Администратор другого приложения видит только события своего приложения
+	otherAdminID, otherAdminCtx := appAdminContext(ctx, t, st, rotationAppID)
+
+	_, err := st.AdminClient.ListAuditEvents(otherAdminCtx, &ssov1.ListAuditEventsRequest{
+		AppId:    rotationAppID,
+		ClientId: appID,
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	events := waitAuditEvents(otherAdminCtx, t, st, &ssov1.ListAuditEventsRequest{
+		AppId:  rotationAppID,
+		UserId: otherAdminID,
+	}, 1)
+
+	// Регистрация без app_id в выдачу не попадает
+	for _, event := range events {
+		assert.Equal(t, int32(rotationAppID), event.GetAppId())
+	}
+}
+
+func TestAudit_LoginEvents(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: randomFakePassword(), AppId: appID})
+	require.Error(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{RefreshToken: respLogin.GetRefreshToken(), AppId: appID})
+	require.NoError(t, err)
+
+	// События отдаются от новых к старым
+	logins := waitAuditEvents(adminCtx, t, st, &ssov1.ListAuditEventsRequest{
+		AppId: appID,
+		Type:  models.AuditLogin,
+		Email: email,
+	}, 2)
+	require.Len(t, logins, 2)
+
+	assert.Equal(t, models.AuditSuccess, logins[0].GetOutcome())
+	assert.Equal(t, respReg.GetUserId(), logins[0].GetUserId())
+	assert.Equal(t, int32(appID), logins[0].GetAppId())
+	assert.NotEmpty(t, logins[0].GetIp())
+	assert.NotEmpty(t, logins[0].GetUserAgent())
+	assert.Greater(t, logins[0].GetId(), logins[1].GetId())
+
+	assert.Equal(t, models.AuditFailure, logins[1].GetOutcome())
+	assert.Equal(t, "invalid credentials", logins[1].GetReason())
+
+	events := waitAuditEvents(adminCtx, t, st, &ssov1.ListAuditEventsRequest{
+		AppId:  appID,
+		UserId: respReg.GetUserId(),
+	}, 3)
+
+	var types []string
+	for _, event := range events {
+		types = append(types, event.GetType())
+	}
+	assert.Subset(t, types, []string{models.AuditRegister, models.AuditLogin, models.AuditRefresh})
+}
+
+func TestAudit_TokenReuse(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	refresh := &ssov1.RefreshRequest{RefreshToken: respLogin.GetRefreshToken(), AppId: appID}
+
+	_, err = st.AuthClient.RefreshToken(ctx, refresh)
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RefreshToken(ctx, refresh)
+	require.Error(t, err)
+
+	events := waitAuditEvents(adminCtx, t, st, &ssov1.ListAuditEventsRequest{
+		AppId:  appID,
+		Type:   models.AuditTokenReuse,
+		UserId: respReg.GetUserId(),
+	}, 1)
+	assert.Equal(t, models.AuditDenied, events[0].GetOutcome())
+}
+
+func TestAudit_AdminActions(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminID, adminCtx := adminContext(ctx, t, st)
+
+	userID, userToken := registerAndLogin(ctx, t, st)
+
+	_, err := st.AdminClient.DisableUser(adminCtx, &ssov1.DisableUserRequest{AppId: appID, UserId: userID})
+	require.NoError(t, err)
+
+	// Отказ в доступе тоже записывается
+	_, err = st.AdminClient.EnableUser(withToken(ctx, userToken), &ssov1.EnableUserRequest{AppId: appID, UserId: userID})
+	require.Error(t, err)
+
+	target := fmt.Sprintf("user:%d", userID)
+
+	var disabled, denied *ssov1.AuditEvent
+	require.Eventually(t, func() bool {
+		resp, err := st.AdminClient.ListAuditEvents(adminCtx, &ssov1.ListAuditEventsRequest{
+			AppId:    appID,
+			Type:     models.AuditAdmin,
+			PageSize: 500,
+		})
+		require.NoError(t, err)
+
+		disabled, denied = nil, nil
+		for _, event := range resp.GetEvents() {
+			if event.GetTarget() != target {
+				continue
+			}
+
+			switch event.GetAction() {
+			case "DisableUser":
+				disabled = event
+			case "EnableUser":
+				denied = event
+			}
+		}
+
+		return disabled != nil && denied != nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, models.AuditSuccess, disabled.GetOutcome())
+	assert.Equal(t, adminID, disabled.GetUserId())
+	assert.Equal(t, models.AuditDenied, denied.GetOutcome())
+}
+
+func TestAudit_Pagination(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	email := gofakeit.Email()
+	for i := 0; i < 3; i++ {
+		_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: randomFakePassword(), AppId: appID})
+		require.Error(t, err)
+	}
+
+	filter := &ssov1.ListAuditEventsRequest{AppId: appID, Email: email}
+	waitAuditEvents(adminCtx, t, st, filter, 3)
+
+	var ids []int64
+	req := &ssov1.ListAuditEventsRequest{AppId: appID, Email: email, PageSize: 1}
+	for {
+		page, err := st.AdminClient.ListAuditEvents(adminCtx, req)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.GetEvents()), 1)
+
+		for _, event := range page.GetEvents() {
+			ids = append(ids, event.GetId())
+		}
+
+		if page.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = page.GetNextPageToken()
+	}
+	require.Len(t, ids, 3)
+	assert.IsDecreasing(t, ids)
+
+	_, err := st.AdminClient.ListAuditEvents(adminCtx, &ssov1.ListAuditEventsRequest{AppId: appID, Since: 200, Until: 100})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
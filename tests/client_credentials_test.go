@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"sso/tests/suite"
+	"strconv"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Секрет клиента приложения rotationAppID, см. tests/migrations/5_client_credentials.up.sql
+const clientSecret = "client_secret"
+
+func TestClientCredentials_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+		ClientId:     rotationAppID,
+		ClientSecret: clientSecret,
+		Audience:     "orders-api",
+	})
+	require.NoError(t, err)
+	assert.Positive(t, resp.GetExpiresIn())
+	assert.Equal(t, []string{"orders:read"}, resp.GetScopes())
+
+	token, _, err := jwt.NewParser().ParseUnverified(resp.GetAccessToken(), jwt.MapClaims{})
+	require.NoError(t, err)
+	claims := token.Claims.(jwt.MapClaims)
+
+	// Токен выдан самому клиенту, пользовательских claims нет
+	assert.Equal(t, strconv.Itoa(rotationAppID), claims["sub"])
+	assert.Equal(t, "orders-api", claims["aud"])
+	assert.Nil(t, claims["uid"])
+	assert.Nil(t, claims["email"])
+
+	respValidate, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: resp.GetAccessToken(),
+	})
+	require.NoError(t, err)
+	assert.True(t, respValidate.GetActive())
+	assert.Zero(t, respValidate.GetUserId())
+}
+
+func TestClientCredentials_Rejected(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	tests := []struct {
+		name     string
+		clientID int32
+		secret   string
+		scopes   []string
+		audience string
+		code     codes.Code
+	}{
+		{
+			name:     "wrong secret",
+			clientID: rotationAppID,
+			secret:   "wrong",
+			code:     codes.Unauthenticated,
+		},
+		{
+			name:     "public client",
+			clientID: appID,
+			secret:   clientSecret,
+			code:     codes.Unauthenticated,
+		},
+		{
+			name:     "scope not allowed",
+			clientID: rotationAppID,
+			secret:   clientSecret,
+			scopes:   []string{"orders:write"},
+			code:     codes.PermissionDenied,
+		},
+		{
+			name:     "audience not allowed",
+			clientID: rotationAppID,
+			secret:   clientSecret,
+			audience: "admin-api",
+			code:     codes.PermissionDenied,
+		},
+		{
+			name:     "empty secret",
+			clientID: rotationAppID,
+			code:     codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.ClientCredentials(ctx, &ssov1.ClientCredentialsRequest{
+				ClientId:     tt.clientID,
+				ClientSecret: tt.secret,
+				Scopes:       tt.scopes,
+				Audience:     tt.audience,
+			})
+			require.Error(t, err)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
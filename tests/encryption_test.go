@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sso/internal/app"
+	"sso/internal/lib/encryption"
+	"sso/internal/storage/postgresql"
+	"sso/internal/storage/sqlite"
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// secretsKeyring возвращает ключи, которыми сервер шифрует секреты
+func secretsKeyring(t *testing.T, st *suite.Suite) *encryption.Keyring {
+	t.Helper()
+
+	keyring, err := app.SecretsKeyring(st.Cfg)
+	require.NoError(t, err)
+
+	return keyring
+}
+
+func TestEncryption_AppSecretsEncryptedAtRest(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, adminCtx := adminContext(ctx, t, st)
+
+	created, err := st.AdminClient.CreateApp(adminCtx, &ssov1.CreateAppRequest{
+		AppId: appID,
+		App:   &ssov1.App{Name: "app-" + gofakeit.LetterN(10)},
+	})
+	require.NoError(t, err)
+
+	customAppID := int(created.GetApp().GetId())
+
+	db, err := sql.Open("postgres", st.Cfg.Connection)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	var secret, refreshSecret string
+	err = db.QueryRowContext(ctx, "SELECT secret, refresh_secret FROM apps WHERE id = $1", customAppID).
+		Scan(&secret, &refreshSecret)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted([]byte(secret)))
+	assert.True(t, encryption.IsEncrypted([]byte(refreshSecret)))
+
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+
+	// Хранилище отдаёт расшифрованные секреты
+	stored, err := storage.App(ctx, customAppID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, stored.Secret)
+	assert.False(t, encryption.IsEncrypted([]byte(stored.Secret)))
+
+	// Токены приложения подписываются и проверяются как раньше
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: int32(customAppID)})
+	require.NoError(t, err)
+
+	validated, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: respLogin.GetToken()})
+	require.NoError(t, err)
+	assert.True(t, validated.GetActive())
+}
+
+func TestEncryption_ReencryptPlaintextSecrets(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+
+	// Тестовые приложения создаются миграциями с открытыми секретами
+	before, err := storage.App(ctx, appID)
+	require.NoError(t, err)
+
+	_, err = storage.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+
+	db, err := sql.Open("postgres", st.Cfg.Connection)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	var secret string
+	err = db.QueryRowContext(ctx, "SELECT secret FROM apps WHERE id = $1", appID).Scan(&secret)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted([]byte(secret)))
+
+	after, err := storage.App(ctx, appID)
+	require.NoError(t, err)
+	assert.Equal(t, before.Secret, after.Secret)
+
+	// Повторный запуск ничего не меняет
+	n, err := storage.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestEncryption_ReencryptPlaintextSecretsSQLite(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// Отдельная база SQLite со всеми миграциями
+	storagePath := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrate.New("file://../migrations/sqlite", "sqlite3://"+storagePath)
+	require.NoError(t, err)
+	require.NoError(t, m.Up())
+	_, _ = m.Close()
+
+	db, err := sql.Open("sqlite3", storagePath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	// Приложение, сохранённое до шифрования секретов
+	plainSecret := gofakeit.LetterN(32)
+	res, err := db.ExecContext(ctx, "INSERT INTO apps (name, secret) VALUES (?, ?)", "app-"+gofakeit.LetterN(10), plainSecret)
+	require.NoError(t, err)
+	id, err := res.LastInsertId()
+	require.NoError(t, err)
+
+	storage, err := sqlite.New(storagePath, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(storage.Stop)
+
+	n, err := storage.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	var secret string
+	err = db.QueryRowContext(ctx, "SELECT secret FROM apps WHERE id = ?", id).Scan(&secret)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted([]byte(secret)))
+
+	stored, err := storage.App(ctx, int(id))
+	require.NoError(t, err)
+	assert.Equal(t, plainSecret, stored.Secret)
+
+	// Повторный запуск ничего не меняет
+	n, err = storage.ReencryptSecrets(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"encoding/json"
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetJWKS_ContainsSigningKey(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	respJWKS, err := st.AuthClient.GetJWKS(ctx, &ssov1.GetJWKSRequest{AppId: appID})
+	require.NoError(t, err)
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+		} `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(respJWKS.GetJwks()), &set))
+
+	token, _, err := jwt.NewParser().ParseUnverified(respLogin.GetToken(), jwt.MapClaims{})
+	require.NoError(t, err)
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		// HS256: токены подписаны секретом приложения и не публикуются
+		assert.Equal(t, "HS256", token.Method.Alg())
+		return
+	}
+
+	// Ключ, которым подписан токен, должен быть опубликован
+	var found bool
+	for _, key := range set.Keys {
+		if key.Kid == kid {
+			found = true
+			assert.Equal(t, token.Method.Alg(), key.Alg)
+			assert.Equal(t, "sig", key.Use)
+		}
+	}
+	assert.True(t, found, "signing key %s is not published", kid)
+}
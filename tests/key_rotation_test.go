@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/services/keys"
+	"sso/internal/storage/postgresql"
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Приложение, ключи которого ротируются в тестах (tests/migrations)
+const rotationAppID = 2
+
+func TestKeyRotation_TokensBeforeAndAfter(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+
+	keysService := keys.New(
+		slog.New(slog.DiscardHandler),
+		storage,
+		storage,
+		jwt.AlgES256,
+		[]string{jwt.AlgES256},
+		st.Cfg.TokenTTL,
+	)
+
+	app := models.App{ID: rotationAppID}
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	login := func() string {
+		resp, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+			Email:    email,
+			Password: pass,
+			AppId:    rotationAppID,
+		})
+		require.NoError(t, err)
+
+		return resp.GetToken()
+	}
+
+	verify := func(token string) error {
+		keyring, err := keysService.Keyring(ctx, app)
+		require.NoError(t, err)
+
+		_, err = jwt.ParseJwtToken(token, keyring, keysService.Algorithms())
+
+		return err
+	}
+
+	oldKey, err := keysService.RotateKey(ctx, app)
+	require.NoError(t, err)
+
+	before := login()
+	require.NoError(t, verify(before))
+
+	newKey, err := keysService.RotateKey(ctx, app)
+	require.NoError(t, err)
+	require.NotEqual(t, oldKey.ID, newKey.ID)
+
+	after := login()
+
+	// Старый ключ продолжает проверять выданные им токены
+	assert.NoError(t, verify(before))
+	assert.NoError(t, verify(after))
+
+	set, err := keysService.JWKS(ctx, rotationAppID)
+	require.NoError(t, err)
+
+	kids := make([]string, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		kids = append(kids, key.Kid)
+	}
+	assert.Contains(t, kids, oldKey.ID)
+	assert.Contains(t, kids, newKey.ID)
+
+	// После отзыва старого ключа его токены недействительны
+	require.NoError(t, keysService.RevokeKey(ctx, oldKey.ID))
+
+	assert.ErrorIs(t, verify(before), jwt.ErrInvalidToken)
+	assert.NoError(t, verify(after))
+}
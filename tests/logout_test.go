@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLogout_RevokesSession(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	first, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	second, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{RefreshToken: first.GetRefreshToken()})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: first.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Другая сессия остаётся активной
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: second.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+}
+
+func TestLogoutAll_RevokesEverySession(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	first, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	second, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.LogoutAll(withToken(ctx, first.GetToken()), &ssov1.LogoutAllRequest{UserId: respReg.GetUserId()})
+	require.NoError(t, err)
+
+	for _, refreshToken := range []string{first.GetRefreshToken(), second.GetRefreshToken()} {
+		_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+			RefreshToken: refreshToken,
+			AppId:        appID,
+		})
+		require.Error(t, err)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+}
+
+func TestLogoutAll_DeniesAccessTokens(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	first, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	second, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	// Проверка до выхода кэширует, что сессии не отозваны
+	for _, token := range []string{first.GetToken(), second.GetToken()} {
+		resp, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: token})
+		require.NoError(t, err)
+		require.True(t, resp.GetActive())
+	}
+
+	_, err = st.AuthClient.LogoutAll(withToken(ctx, first.GetToken()), &ssov1.LogoutAllRequest{UserId: respReg.GetUserId()})
+	require.NoError(t, err)
+
+	for _, token := range []string{first.GetToken(), second.GetToken()} {
+		resp, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: token})
+		require.NoError(t, err)
+		assert.False(t, resp.GetActive())
+		assert.True(t, resp.GetRevoked())
+	}
+}
+
+func TestLogoutAll_RequiresCaller(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	userID, _ := registerAndLogin(ctx, t, st)
+	_, otherToken := registerAndLogin(ctx, t, st)
+
+	req := &ssov1.LogoutAllRequest{UserId: userID}
+
+	_, err := st.AuthClient.LogoutAll(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Чужие сессии завершить нельзя
+	_, err = st.AuthClient.LogoutAll(withToken(ctx, otherToken), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Администратор может
+	_, adminCtx := adminContext(ctx, t, st)
+
+	_, err = st.AuthClient.LogoutAll(adminCtx, req)
+	require.NoError(t, err)
+}
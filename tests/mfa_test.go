@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"context"
+	"encoding/base32"
+	"sso/internal/lib/totp"
+	"sso/tests/suite"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// enableTOTP включает MFA пользователю и возвращает его секрет и коды восстановления
+func enableTOTP(ctx context.Context, t *testing.T, st *suite.Suite, token string) ([]byte, []string) {
+	t.Helper()
+
+	respEnroll, err := st.AuthClient.EnrollTOTP(withToken(ctx, token), &ssov1.EnrollTOTPRequest{})
+	require.NoError(t, err)
+	assert.Contains(t, respEnroll.GetUri(), "otpauth://totp/")
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(respEnroll.GetSecret())
+	require.NoError(t, err)
+
+	respConfirm, err := st.AuthClient.ConfirmTOTP(withToken(ctx, token), &ssov1.ConfirmTOTPRequest{
+		Code: totp.Code(secret, time.Now()),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respConfirm.GetRecoveryCodes())
+
+	return secret, respConfirm.GetRecoveryCodes()
+}
+
+func TestMFA_LoginRequiresSecondFactor(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	require.False(t, respLogin.GetMfaRequired())
+
+	secret, _ := enableTOTP(ctx, t, st, respLogin.GetToken())
+
+	// После включения MFA логин возвращает только mfa_token
+	respLogin, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	require.True(t, respLogin.GetMfaRequired())
+	assert.Empty(t, respLogin.GetToken())
+	assert.Empty(t, respLogin.GetRefreshToken())
+	require.NotEmpty(t, respLogin.GetMfaToken())
+
+	// mfa_token нельзя использовать как access-токен
+	respValidate, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: respLogin.GetMfaToken()})
+	require.NoError(t, err)
+	assert.False(t, respValidate.GetActive())
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLogin.GetMfaToken(),
+		Code:     "000000",
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Код текущего шага уже использован при подтверждении, берем следующий
+	respVerify, err := st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLogin.GetMfaToken(),
+		Code:     totp.Code(secret, time.Now().Add(totp.Period)),
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respVerify.GetToken())
+	require.NotEmpty(t, respVerify.GetRefreshToken())
+
+	respValidate, err = st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: respVerify.GetToken()})
+	require.NoError(t, err)
+	assert.True(t, respValidate.GetActive())
+	assert.Equal(t, email, respValidate.GetEmail())
+}
+
+func TestMFA_RecoveryCodeIsSingleUse(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	_, recoveryCodes := enableTOTP(ctx, t, st, respLogin.GetToken())
+
+	for i, wantCode := range []codes.Code{codes.OK, codes.Unauthenticated} {
+		respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+		require.NoError(t, err)
+		require.True(t, respLogin.GetMfaRequired())
+
+		_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+			MfaToken: respLogin.GetMfaToken(),
+			Code:     recoveryCodes[0],
+			AppId:    appID,
+		})
+		assert.Equal(t, wantCode, status.Code(err), "attempt %d", i+1)
+	}
+}
+
+func TestMFA_TokenIsSingleUse(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	_, recoveryCodes := enableTOTP(ctx, t, st, respLogin.GetToken())
+	require.GreaterOrEqual(t, len(recoveryCodes), 2)
+
+	respLogin, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	require.True(t, respLogin.GetMfaRequired())
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLogin.GetMfaToken(),
+		Code:     recoveryCodes[0],
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	// Тот же mfa_token не принимается даже с верным кодом
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLogin.GetMfaToken(),
+		Code:     recoveryCodes[1],
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestMFA_WrongCodesAreThrottled(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	_, recoveryCodes := enableTOTP(ctx, t, st, respLogin.GetToken())
+
+	respLogin, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	require.True(t, respLogin.GetMfaRequired())
+
+	// Неверные коды считаются неудачными входами, как неверный пароль,
+	// после бесплатных попыток не пускает даже с верным кодом
+	for i := 0; i <= st.Cfg.Throttle.FreeAttempts; i++ {
+		_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+			MfaToken: respLogin.GetMfaToken(),
+			Code:     "000000",
+			AppId:    appID,
+		})
+		require.Error(t, err)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), "attempt %d", i+1)
+	}
+
+	_, err = st.AuthClient.VerifyMFA(ctx, &ssov1.VerifyMFARequest{
+		MfaToken: respLogin.GetMfaToken(),
+		Code:     recoveryCodes[0],
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,5 @@
+INSERT INTO apps (id, name, secret, refresh_secret, redirect_uris)
+VALUES (6, 'test-oidc', 'oidc-secret', 'oidc-refresh-secret', 'http://localhost/callback')
+ON CONFLICT DO NOTHING;
+
+SELECT setval(pg_get_serial_sequence('apps', 'id'), (SELECT MAX(id) FROM apps) + 1, false);
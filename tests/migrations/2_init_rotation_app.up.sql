@@ -0,0 +1,3 @@
+INSERT INTO apps (id, name, secret, refresh_secret)
+VALUES (2, 'test-rotation', 'rotation-secret', 'rotation-refresh-secret')
+ON CONFLICT DO NOTHING
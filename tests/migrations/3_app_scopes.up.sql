@@ -0,0 +1 @@
+UPDATE apps SET allowed_scopes = 'profile email orders:read orders:write' WHERE id = 1
@@ -0,0 +1 @@
+UPDATE apps SET redirect_uris = 'http://localhost/callback' WHERE id = 1
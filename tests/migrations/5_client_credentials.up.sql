@@ -0,0 +1,5 @@
+UPDATE apps
+SET client_secret_hash = '$2a$10$wvuwuOgfbKJAs6ZGBvLEdu4kJjTqbgnvokXbuRPRqpGq4d4ZfKvvu'::bytea,
+    client_scopes      = 'orders:read',
+    audiences          = 'orders-api billing-api'
+WHERE id = 2
@@ -0,0 +1,3 @@
+INSERT INTO apps (id, name, secret, refresh_secret, require_verified_email)
+VALUES (3, 'test-verified-email', 'verified-email-secret', 'verified-email-refresh-secret', TRUE)
+ON CONFLICT DO NOTHING
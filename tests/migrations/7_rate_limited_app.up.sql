@@ -0,0 +1,3 @@
+INSERT INTO apps (id, name, secret, refresh_secret)
+VALUES (4, 'test-rate-limited', 'rate-limited-secret', 'rate-limited-refresh-secret')
+ON CONFLICT DO NOTHING
@@ -0,0 +1,3 @@
+INSERT INTO apps (id, name, secret, refresh_secret)
+VALUES (5, 'test-strict-password', 'strict-password-secret', 'strict-password-refresh-secret')
+ON CONFLICT DO NOTHING
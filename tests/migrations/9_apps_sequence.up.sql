@@ -0,0 +1 @@
+SELECT setval(pg_get_serial_sequence('apps', 'id'), (SELECT MAX(id) FROM apps) + 1, false)
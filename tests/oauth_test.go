@@ -0,0 +1,433 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sso/tests/suite"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURI = "http://localhost/callback"
+
+// httpClient не следует редиректам, чтобы можно было прочитать code из Location
+var httpClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func httpURL(st *suite.Suite, path string) string {
+	return "http://" + net.JoinHostPort("localhost", strconv.Itoa(st.Cfg.HTTP.Port)) + path
+}
+
+// authorize проходит логин на странице /authorize и возвращает полученный code
+func authorize(t *testing.T, st *suite.Suite, email, pass, challenge, scope, nonce string) string {
+	t.Helper()
+
+	return authorizeClient(t, st, appID, email, pass, challenge, scope, nonce)
+}
+
+func authorizeClient(t *testing.T, st *suite.Suite, clientID int, email, pass, challenge, scope, nonce string) string {
+	t.Helper()
+
+	resp := submitLogin(t, st, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(clientID)},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {scope},
+		"nonce":                 {nonce},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}, email, pass)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	return code
+}
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]*)"`)
+
+// loginForm открывает страницу логина для запроса авторизации
+// и возвращает её cookie и csrf-токен
+func loginForm(t *testing.T, st *suite.Suite, params url.Values) ([]*http.Cookie, string) {
+	t.Helper()
+
+	resp, err := httpClient.Get(httpURL(st, "/authorize") + "?" + params.Encode())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	page, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	match := csrfField.FindSubmatch(page)
+	require.NotNil(t, match, "csrf_token is missing")
+
+	return resp.Cookies(), string(match[1])
+}
+
+// postLogin отправляет форму логина с параметрами запроса авторизации
+func postLogin(t *testing.T, st *suite.Suite, form url.Values, cookies []*http.Cookie) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, httpURL(st, "/authorize"), strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	resp, err := httpClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+// submitLogin проходит логин так же, как браузер: открывает форму и отправляет её
+func submitLogin(t *testing.T, st *suite.Suite, params url.Values, email, pass string) *http.Response {
+	t.Helper()
+
+	cookies, csrfToken := loginForm(t, st, params)
+
+	form := maps.Clone(params)
+	form.Set("csrf_token", csrfToken)
+	form.Set("email", email)
+	form.Set("password", pass)
+
+	return postLogin(t, st, form, cookies)
+}
+
+func exchangeCode(t *testing.T, st *suite.Suite, code, verifier string) (*http.Response, map[string]any) {
+	t.Helper()
+
+	return exchangeClientCode(t, st, appID, code, verifier)
+}
+
+func exchangeClientCode(t *testing.T, st *suite.Suite, clientID int, code, verifier string) (*http.Response, map[string]any) {
+	t.Helper()
+
+	resp, err := httpClient.PostForm(httpURL(st, "/token"), url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.Itoa(clientID)},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	return resp, body
+}
+
+func pkcePair() (string, string) {
+	verifier := gofakeit.LetterN(64)
+	sum := sha256.Sum256([]byte(verifier))
+
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestOAuthAuthorizationCode_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	verifier, challenge := pkcePair()
+	code := authorize(t, st, email, pass, challenge, "profile", "")
+
+	resp, body := exchangeCode(t, st, code, verifier)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, "Bearer", body["token_type"])
+	assert.Equal(t, "profile", body["scope"])
+	assert.NotEmpty(t, body["refresh_token"])
+
+	// Полученный токен должен проходить проверку
+	respValidate, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: body["access_token"].(string),
+	})
+	require.NoError(t, err)
+	assert.True(t, respValidate.GetActive())
+	assert.Equal(t, email, respValidate.GetEmail())
+}
+
+func TestOAuthAuthorizationCode_Rejected(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		_, challenge := pkcePair()
+		code := authorize(t, st, email, pass, challenge, "profile", "")
+
+		otherVerifier, _ := pkcePair()
+		resp, body := exchangeCode(t, st, code, otherVerifier)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("code reuse", func(t *testing.T) {
+		verifier, challenge := pkcePair()
+		code := authorize(t, st, email, pass, challenge, "profile", "")
+
+		resp, _ := exchangeCode(t, st, code, verifier)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, body := exchangeCode(t, st, code, verifier)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, "invalid_grant", body["error"])
+	})
+
+	t.Run("unregistered redirect uri", func(t *testing.T) {
+		_, challenge := pkcePair()
+
+		resp, err := httpClient.Get(httpURL(st, "/authorize") + "?" + url.Values{
+			"response_type":         {"code"},
+			"client_id":             {strconv.Itoa(appID)},
+			"redirect_uri":          {"http://evil.example/callback"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}.Encode())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		// Ошибка показывается пользователю, а не отправляется редиректом
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Location"))
+	})
+}
+
+func TestOAuthAuthorizationCode_UserDisabledBeforeExchange(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	verifier, challenge := pkcePair()
+	code := authorize(t, st, email, pass, challenge, "profile", "")
+
+	// Администратор отключает пользователя, пока код ещё не обменян
+	_, adminCtx := adminContext(ctx, t, st)
+	_, err = st.AdminClient.DisableUser(adminCtx, &ssov1.DisableUserRequest{AppId: appID, UserId: respReg.GetUserId()})
+	require.NoError(t, err)
+
+	resp, body := exchangeCode(t, st, code, verifier)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_grant", body["error"])
+	assert.Empty(t, body["access_token"])
+}
+
+func TestOAuthAuthorize_PostValidatesRequest(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	_, challenge := pkcePair()
+
+	tests := []struct {
+		name      string
+		override  url.Values
+		wantError string
+	}{
+		{
+			name:      "unsupported response type",
+			override:  url.Values{"response_type": {"token"}},
+			wantError: "unsupported_response_type",
+		},
+		{
+			name:      "plain code challenge",
+			override:  url.Values{"code_challenge_method": {"plain"}},
+			wantError: "invalid_request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"response_type":         {"code"},
+				"client_id":             {strconv.Itoa(appID)},
+				"redirect_uri":          {redirectURI},
+				"code_challenge":        {challenge},
+				"code_challenge_method": {"S256"},
+				"email":                 {email},
+				"password":              {pass},
+			}
+			for k, v := range tt.override {
+				form[k] = v
+			}
+
+			resp, err := httpClient.PostForm(httpURL(st, "/authorize"), form)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			// Форму логина можно отправить в обход GET /authorize,
+			// поэтому POST проверяет запрос так же
+			require.Equal(t, http.StatusFound, resp.StatusCode)
+
+			location, err := url.Parse(resp.Header.Get("Location"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantError, location.Query().Get("error"))
+			assert.Empty(t, location.Query().Get("code"))
+		})
+	}
+}
+
+func TestOAuthAuthorize_RequiresCSRFToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	_, challenge := pkcePair()
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	cookies, csrfToken := loginForm(t, st, params)
+
+	tests := []struct {
+		name     string
+		override url.Values
+		cookies  []*http.Cookie
+	}{
+		{
+			name:     "missing token",
+			override: url.Values{"csrf_token": {""}},
+			cookies:  cookies,
+		},
+		{
+			name:     "missing cookie",
+			override: url.Values{},
+		},
+		{
+			// Токен выдан для другого запроса авторизации
+			name:     "other request",
+			override: url.Values{"scope": {"profile email"}},
+			cookies:  cookies,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := maps.Clone(params)
+			form.Set("csrf_token", csrfToken)
+			form.Set("email", email)
+			form.Set("password", pass)
+			for k, v := range tt.override {
+				form[k] = v
+			}
+
+			// Чужой сайт может отправить форму, но не знает токена
+			resp := postLogin(t, st, form, tt.cookies)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get("Location"))
+		})
+	}
+}
+
+func TestOAuthRefreshToken_ConfidentialClient(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: rotationAppID})
+	require.NoError(t, err)
+
+	refresh := func(secret string) (*http.Response, map[string]any) {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {strconv.Itoa(rotationAppID)},
+			"refresh_token": {respLogin.GetRefreshToken()},
+		}
+		if secret != "" {
+			form.Set("client_secret", secret)
+		}
+
+		resp, err := httpClient.PostForm(httpURL(st, "/token"), form)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+		return resp, body
+	}
+
+	// Конфиденциальный клиент без секрета не может обменять refresh-токен
+	resp, body := refresh("")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "invalid_client", body["error"])
+
+	resp, body = refresh("wrong secret")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "invalid_client", body["error"])
+
+	resp, body = refresh(clientSecret)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, body["access_token"])
+	assert.NotEmpty(t, body["refresh_token"])
+}
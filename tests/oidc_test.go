@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/services/keys"
+	"sso/internal/storage/postgresql"
+	"sso/tests/suite"
+	"strconv"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Приложение, подписывающее ID-токены ES256-ключом (tests/migrations)
+const oidcAppID = 6
+
+// oidcSigningKey возвращает активный ES256-ключ приложения oidcAppID,
+// создавая его при первом запуске
+func oidcSigningKey(ctx context.Context, t *testing.T, st *suite.Suite) models.SigningKey {
+	t.Helper()
+
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+
+	keysService := keys.New(
+		slog.New(slog.DiscardHandler),
+		storage,
+		storage,
+		jwt.SigningMethodES256.Alg(),
+		[]string{jwt.SigningMethodES256.Alg()},
+		st.Cfg.TokenTTL,
+	)
+
+	key, err := keysService.SigningKey(ctx, oidcAppID)
+	require.NoError(t, err)
+
+	return key
+}
+
+func TestOIDC_IDTokenAndUserInfo(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	key := oidcSigningKey(ctx, t, st)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	nonce := gofakeit.LetterN(16)
+	verifier, challenge := pkcePair()
+	code := authorizeClient(t, st, oidcAppID, email, pass, challenge, "openid email", nonce)
+
+	resp, body := exchangeClientCode(t, st, oidcAppID, code, verifier)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	idToken, ok := body["id_token"].(string)
+	require.True(t, ok, "id_token is missing")
+
+	// ID-токен проверяется публичным ключом приложения, без секрета
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (any, error) {
+		assert.Equal(t, key.ID, token.Header["kid"])
+
+		return x509.ParsePKIXPublicKey(key.PublicKey)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}))
+	require.NoError(t, err)
+	claims := token.Claims.(jwt.MapClaims)
+
+	uid := strconv.FormatInt(respReg.GetUserId(), 10)
+
+	assert.Equal(t, discoveryIssuer(t, st), claims["iss"])
+	assert.Equal(t, uid, claims["sub"])
+	assert.Equal(t, strconv.Itoa(oidcAppID), claims["aud"])
+	assert.Equal(t, nonce, claims["nonce"])
+	assert.Equal(t, email, claims["email"])
+	assert.NotZero(t, claims["auth_time"])
+
+	// userinfo по access-токену
+	req, err := http.NewRequest(http.MethodGet, httpURL(st, "/userinfo"), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+body["access_token"].(string))
+
+	respInfo, err := httpClient.Do(req)
+	require.NoError(t, err)
+	defer respInfo.Body.Close()
+	require.Equal(t, http.StatusOK, respInfo.StatusCode)
+
+	var info map[string]any
+	require.NoError(t, json.NewDecoder(respInfo.Body).Decode(&info))
+	assert.Equal(t, uid, info["sub"])
+	assert.Equal(t, email, info["email"])
+}
+
+func TestOIDC_UserInfoRequiresOpenIDScope(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	verifier, challenge := pkcePair()
+	code := authorize(t, st, email, pass, challenge, "profile", "")
+
+	resp, body := exchangeCode(t, st, code, verifier)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Nil(t, body["id_token"])
+
+	req, err := http.NewRequest(http.MethodGet, httpURL(st, "/userinfo"), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+body["access_token"].(string))
+
+	respInfo, err := httpClient.Do(req)
+	require.NoError(t, err)
+	defer respInfo.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, respInfo.StatusCode)
+}
+
+func TestOIDC_OpenIDRequiresAsymmetricKey(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	// Тестовое приложение подписывает токены секретом, который не знают клиенты
+	_, challenge := pkcePair()
+	resp := submitLogin(t, st, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(appID)},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}, email, pass)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "invalid_scope", location.Query().Get("error"))
+	assert.Empty(t, location.Query().Get("code"))
+
+	// HS256 не объявляется для ID-токенов
+	respDoc, err := httpClient.Get(httpURL(st, "/.well-known/openid-configuration"))
+	require.NoError(t, err)
+	defer respDoc.Body.Close()
+
+	var doc struct {
+		IDTokenAlgs []string `json:"id_token_signing_alg_values_supported"`
+	}
+	require.NoError(t, json.NewDecoder(respDoc.Body).Decode(&doc))
+	assert.NotContains(t, doc.IDTokenAlgs, jwt.SigningMethodHS256.Alg())
+	assert.Contains(t, doc.IDTokenAlgs, jwt.SigningMethodES256.Alg())
+}
+
+// discoveryIssuer читает issuer из /.well-known/openid-configuration
+func discoveryIssuer(t *testing.T, st *suite.Suite) string {
+	t.Helper()
+
+	resp, err := httpClient.Get(httpURL(st, "/.well-known/openid-configuration"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var doc struct {
+		Issuer        string `json:"issuer"`
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	require.NotEmpty(t, doc.Issuer)
+	assert.Equal(t, doc.Issuer+"/token", doc.TokenEndpoint)
+
+	return doc.Issuer
+}
@@ -0,0 +1,334 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	passkeyRPID   = "localhost"
+	passkeyOrigin = "http://localhost"
+)
+
+// softAuthenticator - программный аутентификатор с ключом ES256 вместо настоящего устройства
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	return &softAuthenticator{key: key, credentialID: id}
+}
+
+// create отвечает на navigator.credentials.create
+func (a *softAuthenticator) create(t *testing.T, options string) (clientDataJSON []byte, attestationObject []byte) {
+	t.Helper()
+
+	var opts struct {
+		Challenge string `json:"challenge"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(options), &opts))
+	require.Equal(t, passkeyRPID, opts.RP.ID)
+
+	userHandle, err := base64.RawURLEncoding.DecodeString(opts.User.ID)
+	require.NoError(t, err)
+	a.userHandle = userHandle
+
+	ecdhKey, err := a.key.PublicKey.ECDH()
+	require.NoError(t, err)
+	point := ecdhKey.Bytes() // 0x04 || X || Y
+
+	coseKey := cborMap(
+		cborInt(1), cborInt(2), // kty: EC2
+		cborInt(3), cborInt(-7), // alg: ES256
+		cborInt(-1), cborInt(1), // crv: P-256
+		cborInt(-2), cborBytes(point[1:33]),
+		cborInt(-3), cborBytes(point[33:]),
+	)
+
+	authData := a.authData(0x01|0x04|0x40, 0)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, coseKey...)
+
+	attestationObject = cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(authData),
+	)
+
+	return clientData(t, "webauthn.create", opts.Challenge), attestationObject
+}
+
+// get отвечает на navigator.credentials.get
+func (a *softAuthenticator) get(t *testing.T, options string) *ssov1.FinishPasskeyLoginRequest {
+	t.Helper()
+
+	var opts struct {
+		Challenge string `json:"challenge"`
+		RPID      string `json:"rpId"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(options), &opts))
+	require.Equal(t, passkeyRPID, opts.RPID)
+
+	a.signCount++
+
+	cd := clientData(t, "webauthn.get", opts.Challenge)
+
+	return a.sign(t, a.authData(0x01|0x04, a.signCount), cd)
+}
+
+func (a *softAuthenticator) sign(t *testing.T, authData []byte, clientDataJSON []byte) *ssov1.FinishPasskeyLoginRequest {
+	t.Helper()
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+
+	return &ssov1.FinishPasskeyLoginRequest{
+		CredentialId:      a.credentialID,
+		ClientDataJson:    clientDataJSON,
+		AuthenticatorData: authData,
+		Signature:         signature,
+		UserHandle:        a.userHandle,
+		AppId:             appID,
+	}
+}
+
+func (a *softAuthenticator) authData(flags byte, signCount uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(passkeyRPID))
+
+	data := append(rpIDHash[:], flags)
+
+	return binary.BigEndian.AppendUint32(data, signCount)
+}
+
+func clientData(t *testing.T, typ string, challenge string) []byte {
+	t.Helper()
+
+	res, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    passkeyOrigin,
+	})
+	require.NoError(t, err)
+
+	return res
+}
+
+// Минимальный CBOR-кодировщик, нужный для attestation object
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 256:
+		return []byte{major<<5 | 24, byte(n)}
+	default:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	}
+}
+
+func cborInt(n int) []byte {
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+
+	return cborHead(0, uint64(n))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+func cborMap(items ...[]byte) []byte {
+	res := cborHead(5, uint64(len(items)/2))
+	for _, item := range items {
+		res = append(res, item...)
+	}
+
+	return res
+}
+
+// registerPasskey регистрирует пользователя с паролем и привязывает к нему passkey
+func registerPasskey(ctx context.Context, t *testing.T, st *suite.Suite) (*softAuthenticator, int64) {
+	t.Helper()
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	authCtx := withToken(ctx, respLogin.GetToken())
+
+	respBegin, err := st.AuthClient.BeginPasskeyRegistration(authCtx, &ssov1.BeginPasskeyRegistrationRequest{})
+	require.NoError(t, err)
+
+	authenticator := newSoftAuthenticator(t)
+	clientDataJSON, attestationObject := authenticator.create(t, respBegin.GetOptions())
+
+	respFinish, err := st.AuthClient.FinishPasskeyRegistration(authCtx, &ssov1.FinishPasskeyRegistrationRequest{
+		ClientDataJson:    clientDataJSON,
+		AttestationObject: attestationObject,
+		Transports:        []string{"internal"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, authenticator.credentialID, respFinish.GetCredentialId())
+
+	// Повторная регистрация того же ключа запрещена
+	respBegin, err = st.AuthClient.BeginPasskeyRegistration(authCtx, &ssov1.BeginPasskeyRegistrationRequest{})
+	require.NoError(t, err)
+	assert.Contains(t, respBegin.GetOptions(), base64.RawURLEncoding.EncodeToString(authenticator.credentialID))
+
+	clientDataJSON, attestationObject = authenticator.create(t, respBegin.GetOptions())
+	_, err = st.AuthClient.FinishPasskeyRegistration(authCtx, &ssov1.FinishPasskeyRegistrationRequest{
+		ClientDataJson:    clientDataJSON,
+		AttestationObject: attestationObject,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	return authenticator, respReg.GetUserId()
+}
+
+func TestPasskey_RegisterAndLogin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	authenticator, userID := registerPasskey(ctx, t, st)
+
+	respBegin, err := st.AuthClient.BeginPasskeyLogin(ctx, &ssov1.BeginPasskeyLoginRequest{})
+	require.NoError(t, err)
+
+	assertion := authenticator.get(t, respBegin.GetOptions())
+
+	respLogin, err := st.AuthClient.FinishPasskeyLogin(ctx, assertion)
+	require.NoError(t, err)
+	require.False(t, respLogin.GetMfaRequired())
+	require.NotEmpty(t, respLogin.GetToken())
+	assert.NotEmpty(t, respLogin.GetRefreshToken())
+
+	respValidate, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: respLogin.GetToken()})
+	require.NoError(t, err)
+	assert.True(t, respValidate.GetActive())
+	assert.Equal(t, userID, respValidate.GetUserId())
+
+	// Challenge одноразовый
+	_, err = st.AuthClient.FinishPasskeyLogin(ctx, assertion)
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestPasskey_LoginFails(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	authenticator, _ := registerPasskey(ctx, t, st)
+
+	tests := []struct {
+		name   string
+		modify func(in *ssov1.FinishPasskeyLoginRequest)
+	}{
+		{
+			name: "Invalid signature",
+			modify: func(in *ssov1.FinishPasskeyLoginRequest) {
+				in.Signature[len(in.Signature)-1] ^= 0xff
+			},
+		},
+		{
+			name: "Unknown credential",
+			modify: func(in *ssov1.FinishPasskeyLoginRequest) {
+				in.CredentialId = []byte("unknown credential")
+			},
+		},
+		{
+			name: "Foreign user handle",
+			modify: func(in *ssov1.FinishPasskeyLoginRequest) {
+				in.UserHandle = []byte{0, 0, 0, 0, 0, 0, 0, 0}
+			},
+		},
+		{
+			name: "Unknown challenge",
+			modify: func(in *ssov1.FinishPasskeyLoginRequest) {
+				*in = *authenticator.sign(t, in.AuthenticatorData, clientData(t, "webauthn.get", "unknown"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			respBegin, err := st.AuthClient.BeginPasskeyLogin(ctx, &ssov1.BeginPasskeyLoginRequest{})
+			require.NoError(t, err)
+
+			assertion := authenticator.get(t, respBegin.GetOptions())
+			tt.modify(assertion)
+
+			_, err = st.AuthClient.FinishPasskeyLogin(ctx, assertion)
+			require.Error(t, err)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		})
+	}
+}
+
+func TestPasskey_SignCountRegression(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	authenticator, _ := registerPasskey(ctx, t, st)
+
+	authenticator.signCount = 10
+
+	respBegin, err := st.AuthClient.BeginPasskeyLogin(ctx, &ssov1.BeginPasskeyLoginRequest{})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.FinishPasskeyLogin(ctx, authenticator.get(t, respBegin.GetOptions()))
+	require.NoError(t, err)
+
+	// Клон аутентификатора со старым счетчиком подписей
+	authenticator.signCount = 5
+
+	respBegin, err = st.AuthClient.BeginPasskeyLogin(ctx, &ssov1.BeginPasskeyLoginRequest{})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.FinishPasskeyLogin(ctx, authenticator.get(t, respBegin.GetOptions()))
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"sso/internal/storage/postgresql"
+	"sso/tests/suite"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHash_RehashOnLogin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	user, err := storage.User(ctx, email)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(user.PassHash), "$"+st.Cfg.Password.Hash.Algorithm+"$"))
+
+	// Хеш, сохранённый до перехода на новый алгоритм
+	oldHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, storage.UpdatePassword(ctx, user.ID, oldHash))
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	user, err = storage.User(ctx, email)
+	require.NoError(t, err)
+	assert.NotEqual(t, oldHash, user.PassHash)
+	assert.True(t, strings.HasPrefix(string(user.PassHash), "$"+st.Cfg.Password.Hash.Algorithm+"$"))
+
+	// Пароль по-прежнему подходит
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"sso/internal/lib/password"
+	"sso/tests/suite"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	strictPasswordAppID = 5
+	// Есть в tests/testdata/pwned
+	breachedPassword = "Tr0ub4dor&3"
+)
+
+// violationReasons возвращает причины отказа из деталей ошибки для поля field
+func violationReasons(t *testing.T, err error, field string) []string {
+	t.Helper()
+
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+
+	var reasons []string
+	for _, detail := range st.Details() {
+		badRequest, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+
+		for _, v := range badRequest.GetFieldViolations() {
+			assert.Equal(t, field, v.GetField())
+			assert.NotEmpty(t, v.GetDescription())
+			reasons = append(reasons, v.GetReason())
+		}
+	}
+	require.NotEmpty(t, reasons, "no field violations in %v", err)
+
+	return reasons
+}
+
+func TestPasswordPolicy_AppPolicy(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: "short",
+		AppId:    strictPasswordAppID,
+	})
+	require.Error(t, err)
+
+	reasons := violationReasons(t, err, "password")
+	assert.ElementsMatch(t, []string{
+		password.ReasonTooShort,
+		password.ReasonMissingUpper,
+		password.ReasonMissingDigit,
+		password.ReasonMissingSymbol,
+	}, reasons)
+
+	// Тот же пароль подходит под политику по умолчанию, кроме длины
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: "shortish",
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: "Long-enough-Passw0rd",
+		AppId:    strictPasswordAppID,
+	})
+	require.NoError(t, err)
+}
+
+func TestPasswordPolicy_Rejects(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	local := gofakeit.LetterN(8)
+	email := local + "@example.com"
+
+	tests := []struct {
+		name     string
+		password string
+		reason   string
+	}{
+		{
+			name:     "Too long for bcrypt",
+			password: strings.Repeat("a", 73),
+			reason:   password.ReasonTooLong,
+		},
+		{
+			name:     "Contains email",
+			password: "My" + strings.ToUpper(local) + "!2024",
+			reason:   password.ReasonContainsEmail,
+		},
+		{
+			name:     "Breached",
+			password: breachedPassword,
+			reason:   password.ReasonBreached,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: tt.password})
+			require.Error(t, err)
+
+			assert.Contains(t, violationReasons(t, err, "password"), tt.reason)
+		})
+	}
+}
+
+func TestPasswordPolicy_ResetKeepsTokenOnRejection(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	verificationToken := lastEmailToken(t, st, email)
+
+	_, err = st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+	require.NoError(t, err)
+
+	token := awaitEmailToken(t, st, email, verificationToken)
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: token, NewPassword: breachedPassword})
+	require.Error(t, err)
+	assert.Contains(t, violationReasons(t, err, "new_password"), password.ReasonBreached)
+
+	// Отклонённый пароль не расходует токен
+	newPass := randomFakePassword()
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: token, NewPassword: newPass})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: newPass, AppId: appID})
+	require.NoError(t, err)
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPasswordReset_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	oldPass := randomFakePassword()
+	newPass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: oldPass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: oldPass, AppId: appID})
+	require.NoError(t, err)
+
+	// Последнее письмо пока что для подтверждения email
+	verificationToken := lastEmailToken(t, st, email)
+
+	_, err = st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+	require.NoError(t, err)
+
+	token := awaitEmailToken(t, st, email, verificationToken)
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: token, NewPassword: newPass})
+	require.NoError(t, err)
+
+	// Старый пароль больше не подходит
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: oldPass, AppId: appID})
+	require.Error(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: newPass, AppId: appID})
+	require.NoError(t, err)
+
+	// Сессии, открытые до сброса, отозваны
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{RefreshToken: respLogin.GetRefreshToken(), AppId: appID})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	respValidate, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: respLogin.GetToken()})
+	require.NoError(t, err)
+	assert.False(t, respValidate.GetActive())
+
+	// Токен одноразовый
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: token, NewPassword: oldPass})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestPasswordReset_NewRequestInvalidatesOldToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	verificationToken := lastEmailToken(t, st, email)
+
+	_, err = st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+	require.NoError(t, err)
+	oldToken := awaitEmailToken(t, st, email, verificationToken)
+
+	_, err = st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: email})
+	require.NoError(t, err)
+	newToken := awaitEmailToken(t, st, email, oldToken)
+	require.NotEqual(t, oldToken, newToken)
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: oldToken, NewPassword: randomFakePassword()})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: newToken, NewPassword: randomFakePassword()})
+	require.NoError(t, err)
+}
+
+func TestPasswordReset_DoesNotRevealEmails(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.RequestPasswordReset(ctx, &ssov1.RequestPasswordResetRequest{Email: gofakeit.Email()})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ResetPassword(ctx, &ssov1.ResetPasswordRequest{Token: "unknown", NewPassword: randomFakePassword()})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"sso/tests/suite"
+	"strconv"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const rateLimitedAppID = 4
+
+func TestRateLimit_PerAppPolicy(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	policy := st.Cfg.RateLimit.Apps[rateLimitedAppID]["Login"]
+	require.NotZero(t, policy.Burst)
+
+	// Разные email, чтобы не сработало ограничение неудачных входов в аккаунт
+	login := func(header *metadata.MD) error {
+		_, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+			Email:    gofakeit.Email(),
+			Password: randomFakePassword(),
+			AppId:    rateLimitedAppID,
+		}, grpc.Header(header))
+
+		return err
+	}
+
+	var header metadata.MD
+	for i := 0; i < policy.Burst; i++ {
+		err := login(&header)
+		require.Error(t, err)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	err := login(&header)
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.NotEmpty(t, header.Get("retry-after"))
+
+	retryAfter, err := strconv.Atoi(header.Get("retry-after")[0])
+	require.NoError(t, err)
+	assert.Positive(t, retryAfter)
+
+	// Лимит приложения не распространяется на другие приложения
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    gofakeit.Email(),
+		Password: randomFakePassword(),
+		AppId:    appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
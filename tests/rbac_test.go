@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"context"
+	"sso/internal/domain/models"
+	"sso/internal/storage/postgresql"
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRBAC_AssignRole(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminID, adminToken := registerAndLogin(ctx, t, st)
+	userID, userToken := registerAndLogin(ctx, t, st)
+
+	// Первого администратора назначаем напрямую в хранилище
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+	require.NoError(t, storage.SaveUserRole(ctx, adminID, appID, models.RoleAdmin))
+
+	respIsAdmin, err := st.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: adminID, AppId: appID})
+	require.NoError(t, err)
+	assert.True(t, respIsAdmin.GetIsAdmin())
+
+	respIsAdmin, err = st.AuthClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: userID, AppId: appID})
+	require.NoError(t, err)
+	assert.False(t, respIsAdmin.GetIsAdmin())
+
+	req := &ssov1.AssignRoleRequest{UserId: userID, AppId: appID, Role: "editor"}
+
+	// Без токена
+	_, err = st.AuthClient.AssignRole(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Не администратор
+	_, err = st.AuthClient.AssignRole(withToken(ctx, userToken), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AuthClient.AssignRole(withToken(ctx, adminToken), req)
+	require.NoError(t, err)
+
+	respPerm, err := st.AuthClient.HasPermission(ctx, &ssov1.HasPermissionRequest{
+		UserId:     adminID,
+		AppId:      appID,
+		Permission: "anything",
+	})
+	require.NoError(t, err)
+	assert.True(t, respPerm.GetAllowed())
+
+	respPerm, err = st.AuthClient.HasPermission(ctx, &ssov1.HasPermissionRequest{
+		UserId:     userID,
+		AppId:      appID,
+		Permission: "anything",
+	})
+	require.NoError(t, err)
+	assert.False(t, respPerm.GetAllowed())
+}
+
+func TestRBAC_RolesInToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+	require.NoError(t, storage.SaveUserRole(ctx, respReg.GetUserId(), appID, "editor"))
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	parsed, err := jwt.Parse(respLogin.GetToken(), func(token *jwt.Token) (interface{}, error) {
+		return []byte(appSecret), nil
+	})
+	require.NoError(t, err)
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	assert.Equal(t, []interface{}{"editor"}, claims["roles"])
+}
+
+func registerAndLogin(ctx context.Context, t *testing.T, st *suite.Suite) (int64, string) {
+	t.Helper()
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	return respReg.GetUserId(), respLogin.GetToken()
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
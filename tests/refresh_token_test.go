@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRefreshToken_Rotation(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	respRefresh, err := st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+
+	// Каждый refresh возвращает новый refresh token
+	assert.NotEqual(t, respLogin.GetRefreshToken(), respRefresh.GetRefreshToken())
+
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respRefresh.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+}
+
+func TestRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	respRefresh, err := st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+
+	// Повторное использование уже использованного токена
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.ErrorContains(t, err, "refresh token reuse detected")
+
+	// После обнаружения повторного использования отозвана вся цепочка
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respRefresh.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestRefreshToken_Forged(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: "not-a-token",
+		AppId:        appID,
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevokeToken_DeniesAccessToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	first, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	second, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	// Каждый токен имеет уникальный jti
+	assert.NotEqual(t, tokenID(t, first.GetToken()), tokenID(t, second.GetToken()))
+
+	_, err = st.AuthClient.RevokeToken(ctx, &ssov1.RevokeTokenRequest{Token: first.GetToken()})
+	require.NoError(t, err)
+
+	resp, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: first.GetToken()})
+	require.NoError(t, err)
+	assert.False(t, resp.GetActive())
+	assert.True(t, resp.GetRevoked())
+
+	// Отзыв одного токена не затрагивает другие
+	resp, err = st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{Token: second.GetToken()})
+	require.NoError(t, err)
+	assert.True(t, resp.GetActive())
+
+	// Повторный отзыв не является ошибкой
+	_, err = st.AuthClient.RevokeToken(ctx, &ssov1.RevokeTokenRequest{Token: first.GetToken()})
+	require.NoError(t, err)
+}
+
+func tokenID(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	require.True(t, ok)
+
+	jti, ok := claims["jti"].(string)
+	require.True(t, ok)
+	require.NotEmpty(t, jti)
+
+	return jti
+}
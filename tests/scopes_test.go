@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"sso/tests/suite"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Скоупы, разрешённые тестовому приложению (tests/migrations)
+const allowedScopes = "email orders:read orders:write profile"
+
+func TestScopes_LoginAndNarrowOnRefresh(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	// Без запрошенных скоупов выдаются все разрешённые приложению
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+	assert.Equal(t, allowedScopes, scopeClaim(t, respLogin.GetToken()))
+
+	respLogin, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+		Scopes:   []string{"profile", "orders:read"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "orders:read profile", scopeClaim(t, respLogin.GetToken()))
+
+	// Расширить набор при обновлении нельзя
+	_, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+		AppId:        appID,
+		Scopes:       []string{"profile", "orders:write"},
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Отклонённый запрос не расходует refresh token, сузить можно
+	respRefresh, err := st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+		AppId:        appID,
+		Scopes:       []string{"profile"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "profile", scopeClaim(t, respRefresh.GetToken()))
+
+	// Без запрошенных скоупов сохраняется выданный набор
+	respRefresh, err = st.AuthClient.RefreshToken(ctx, &ssov1.RefreshRequest{
+		RefreshToken: respRefresh.GetRefreshToken(),
+		AppId:        appID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "profile", scopeClaim(t, respRefresh.GetToken()))
+}
+
+func TestScopes_LoginOutOfPolicy(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+		Scopes:   []string{"profile", "admin:everything"},
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.ErrorContains(t, err, "scope not allowed")
+}
+
+func scopeClaim(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		return []byte(appSecret), nil
+	})
+	require.NoError(t, err)
+
+	scope, _ := parsed.Claims.(jwt.MapClaims)["scope"].(string)
+
+	return scope
+}
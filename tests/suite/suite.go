@@ -16,9 +16,10 @@ const grpcHost = "localhost"
 const configPath = "../config/local_tests_config.yaml"
 
 type Suite struct {
-	*testing.T                  // Потребуется для вызова методов *testing.T
-	Cfg        *config.Config   // Конфигурация приложения
-	AuthClient ssov1.AuthClient // Клиент для взаимодействия с gRPC-сервером Auth
+	*testing.T                    // Потребуется для вызова методов *testing.T
+	Cfg         *config.Config    // Конфигурация приложения
+	AuthClient  ssov1.AuthClient  // Клиент для взаимодействия с gRPC-сервером Auth
+	AdminClient ssov1.AdminClient // Клиент административного API
 }
 
 func New(t *testing.T) (context.Context, *Suite) {
@@ -53,8 +54,9 @@ func New(t *testing.T) (context.Context, *Suite) {
 	authClient := ssov1.NewAuthClient(cc)
 
 	return ctx, &Suite{
-		T:          t,
-		Cfg:        cfg,
-		AuthClient: authClient,
+		T:           t,
+		Cfg:         cfg,
+		AuthClient:  authClient,
+		AdminClient: ssov1.NewAdminClient(cc),
 	}
 }
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"sso/internal/domain/models"
+	"sso/internal/storage/postgresql"
+	"sso/tests/suite"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestThrottle_BackoffAndLockout(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	wrong := &ssov1.LoginRequest{Email: email, Password: "wrong password", AppId: appID}
+	right := &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID}
+
+	// Первые попытки не ограничены, последняя из них включает задержку
+	for i := 0; i <= st.Cfg.Throttle.FreeAttempts; i++ {
+		_, err = st.AuthClient.Login(ctx, wrong)
+		require.Error(t, err)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	// Во время задержки не пускает даже с верным паролем
+	var header metadata.MD
+	_, err = st.AuthClient.Login(ctx, right, grpc.Header(&header))
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.NotEmpty(t, header.Get("retry-after"))
+
+	retryAfter, err := strconv.Atoi(header.Get("retry-after")[0])
+	require.NoError(t, err)
+	assert.LessOrEqual(t, retryAfter, int(st.Cfg.Throttle.BaseDelay.Seconds()))
+
+	time.Sleep(time.Duration(retryAfter) * time.Second)
+
+	// После задержки снова можно пытаться, пока аккаунт не заблокирован
+	for i := st.Cfg.Throttle.FreeAttempts + 1; i < st.Cfg.Throttle.LockoutAfter; i++ {
+		_, err = st.AuthClient.Login(ctx, wrong)
+		require.Error(t, err)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	_, err = st.AuthClient.Login(ctx, right, grpc.Header(&header))
+	require.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	retryAfter, err = strconv.Atoi(header.Get("retry-after")[0])
+	require.NoError(t, err)
+	assert.Greater(t, retryAfter, int(st.Cfg.Throttle.BaseDelay.Seconds()))
+}
+
+func TestThrottle_AdminUnlock(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminID, adminToken := registerAndLogin(ctx, t, st)
+	_, userToken := registerAndLogin(ctx, t, st)
+
+	storage, err := postgresql.New(st.Cfg.Connection, secretsKeyring(t, st))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Stop() })
+	require.NoError(t, storage.SaveUserRole(ctx, adminID, appID, models.RoleAdmin))
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err = st.AuthClient.Register(ctx, &ssov1.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	for i := 0; i <= st.Cfg.Throttle.FreeAttempts; i++ {
+		_, err = st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: "wrong password", AppId: appID})
+		require.Error(t, err)
+	}
+
+	right := &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID}
+
+	_, err = st.AuthClient.Login(ctx, right)
+	require.Error(t, err)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	unlock := &ssov1.UnlockAccountRequest{Email: email, AppId: appID}
+
+	_, err = st.AuthClient.UnlockAccount(ctx, unlock)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.UnlockAccount(withToken(ctx, userToken), unlock)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = st.AuthClient.UnlockAccount(withToken(ctx, adminToken), unlock)
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, right)
+	require.NoError(t, err)
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"sso/tests/suite"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	ssov1 "github.com/iluha481/protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateToken_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	loginTime := time.Now()
+
+	resp, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: respLogin.GetToken(),
+	})
+	require.NoError(t, err)
+
+	assert.True(t, resp.GetActive())
+	assert.False(t, resp.GetRevoked())
+	assert.Equal(t, respReg.GetUserId(), resp.GetUserId())
+	assert.Equal(t, email, resp.GetEmail())
+	assert.Equal(t, int32(appID), resp.GetAppId())
+
+	const deltaSeconds = 1
+	assert.InDelta(t, loginTime.Add(st.Cfg.TokenTTL).Unix(), resp.GetExpiresAt(), deltaSeconds)
+}
+
+func TestValidateToken_RevokedAfterLogout(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	// Проверка до выхода кэширует, что сессия не отозвана
+	resp, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: respLogin.GetToken(),
+	})
+	require.NoError(t, err)
+	require.True(t, resp.GetActive())
+
+	_, err = st.AuthClient.Logout(ctx, &ssov1.LogoutRequest{RefreshToken: respLogin.GetRefreshToken()})
+	require.NoError(t, err)
+
+	resp, err = st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: respLogin.GetToken(),
+	})
+	require.NoError(t, err)
+
+	assert.False(t, resp.GetActive())
+	assert.True(t, resp.GetRevoked())
+	assert.Empty(t, resp.GetUserId())
+}
+
+func TestValidateToken_Invalid(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{
+		Token: "not-a-token",
+	})
+	require.NoError(t, err)
+	assert.False(t, resp.GetActive())
+
+	_, err = st.AuthClient.ValidateToken(ctx, &ssov1.ValidateTokenRequest{})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "token is required")
+}